@@ -0,0 +1,223 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"Unbewohnte/gospa/pkg/saver"
+)
+
+// Environment variable to take SMTP password from
+const smtpPasswordEnvVar string = "GOSPA_SMTP_PASSWORD"
+
+// Attachments bigger than this are replaced with a note where to find the file
+const maxEmailAttachmentSize int64 = 10 * 1024 * 1024
+
+// Build a MIME message with optional attachment
+func buildEmail(from string, to []string, subject string, text string, attachmentName string, attachment []byte) []byte {
+	var message bytes.Buffer
+	writer := multipart.NewWriter(&message)
+
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	textPart, _ := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	textPart.Write([]byte(text))
+
+	if attachment != nil {
+		contentType := mime.TypeByExtension(filepath.Ext(attachmentName))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		attachmentPart, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachmentName})},
+		})
+
+		encoded := base64.StdEncoding.EncodeToString(attachment)
+		for len(encoded) > 76 {
+			attachmentPart.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		attachmentPart.Write([]byte(encoded + "\r\n"))
+	}
+
+	writer.Close()
+
+	return message.Bytes()
+}
+
+// Files of the saved pages that are on disk on their own, relative to the output directory:
+// outputs, extras and page files, unless they have gone into an archive
+func savedFiles(result *saver.Result) []string {
+	var files []string
+	var seen map[string]bool = make(map[string]bool)
+	add := func(relPath string) {
+		if relPath == "" || seen[relPath] {
+			return
+		}
+		seen[relPath] = true
+
+		info, err := os.Stat(filepath.Join(result.OutputDir, relPath))
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, relPath)
+		}
+	}
+
+	for _, page := range result.Pages {
+		add(page.OutputPath)
+		for _, extra := range page.Extras {
+			add(extra)
+		}
+		for _, asset := range page.Assets {
+			if asset.Status == saver.AssetSaved {
+				add(asset.LocalPath)
+			}
+		}
+	}
+
+	return files
+}
+
+// Pack files (relative to dir) into a gzipped tar archive
+func packFiles(dir string, files []string) ([]byte, error) {
+	var packed bytes.Buffer
+	compressor := gzip.NewWriter(&packed)
+	archive := tar.NewWriter(compressor)
+	for _, relPath := range files {
+		contents, err := os.ReadFile(filepath.Join(dir, relPath))
+		if err != nil {
+			return nil, err
+		}
+
+		err = archive.WriteHeader(&tar.Header{
+			Name:    filepath.ToSlash(relPath),
+			Mode:    0644,
+			Size:    int64(len(contents)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = archive.Write(contents)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = compressor.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return packed.Bytes(), nil
+}
+
+// Send saved files (relative to outputDir) to comma-separated recipients via SMTP server at smtpAddr.
+// A single file is attached as it is, several ones are packed into a .tar.gz first
+func emailSavedPage(smtpAddr string, smtpUser string, from string, recipients string, pageURL string, outputDir string, files []string) error {
+	var to []string
+	for _, recipient := range strings.Split(recipients, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if recipient != "" {
+			to = append(to, recipient)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients specified")
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no saved files to send")
+	}
+
+	if from == "" {
+		from = smtpUser
+	}
+	if from == "" {
+		from = "gospa@localhost"
+	}
+
+	var attachmentName string = filepath.Base(files[0])
+	var location string = filepath.Join(outputDir, files[0])
+	var size int64 = 0
+	for _, relPath := range files {
+		info, err := os.Stat(filepath.Join(outputDir, relPath))
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+	}
+	if len(files) > 1 {
+		attachmentName = strings.TrimSuffix(attachmentName, filepath.Ext(attachmentName)) + ".tar.gz"
+		location = outputDir
+	}
+
+	var text string
+	var attachment []byte
+	var err error
+	if size > maxEmailAttachmentSize {
+		text = fmt.Sprintf(
+			"Saved %s\r\n\r\nThe saved files are too big to be attached (%s), they are stored at %s\r\n",
+			pageURL, formatSize(size), location,
+		)
+	} else {
+		if len(files) > 1 {
+			attachment, err = packFiles(outputDir, files)
+		} else {
+			attachment, err = os.ReadFile(location)
+		}
+		if err != nil {
+			return err
+		}
+		text = fmt.Sprintf("Saved %s\r\n\r\nThe saved files are attached.\r\n", pageURL)
+	}
+
+	message := buildEmail(from, to, "Saved page: "+pageURL, text, attachmentName, attachment)
+
+	var auth smtp.Auth = nil
+	if smtpUser != "" {
+		host, _, err := net.SplitHostPort(smtpAddr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP server address: %s", err)
+		}
+		auth = smtp.PlainAuth("", smtpUser, os.Getenv(smtpPasswordEnvVar), host)
+	}
+
+	return smtp.SendMail(smtpAddr, auth, from, to, message)
+}
@@ -13,232 +13,151 @@ THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR I
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
-	"path/filepath"
-	"regexp"
+	"os/signal"
 	"strings"
-	"sync"
-)
+	"time"
 
-const VERSION string = "v0.1"
+	"Unbewohnte/gospa/pkg/saver"
+)
 
 var (
-	help    *bool   = flag.Bool("help", false, "Print help message and exit")
-	version *bool   = flag.Bool("version", false, "Print version information and exit")
-	urlStr  *string = flag.String("url", "", "Specify URL to the webpage to be saved")
+	help               *bool          = flag.Bool("help", false, "Print help message and exit")
+	version            *bool          = flag.Bool("version", false, "Print version information and exit")
+	urlStr             *string        = flag.String("url", "", "Specify URL to the webpage to be saved")
+	depth              *uint          = flag.Uint("depth", 0, "Also save pages linked from the page, following links up to given depth")
+	maxPages           *uint          = flag.Uint("max-pages", 0, "Stop fetching pages when saving recursively once this many have been fetched. 0 means no limit")
+	maxTime            *time.Duration = flag.Duration("max-time", 0, "Stop fetching pages when saving recursively after given time (e.g. 30m), saving the ones fetched by then. 0 means no limit")
+	spanHosts          *bool          = flag.Bool("span-hosts", false, "Follow links to other hosts when saving recursively")
+	noRobots           *bool          = flag.Bool("no-robots", false, "Do not follow robots.txt when saving recursively")
+	thread             *bool          = flag.Bool("thread", false, "Save every page of a paginated forum thread or listing by following its \"next page\" links")
+	languages          *string        = flag.String("languages", "", "Comma-separated languages to also save the page in (e.g. en,ru), using hreflang alternates or Accept-Language")
+	outputPath         *string        = flag.String("output", "", "Directory to save the page into (created if missing). Defaults to the working directory")
+	format             *string        = flag.String("format", saver.FormatHTML, "Output format: \"html\" (page with its files), \"warc\" (WARC 1.1 capture of every request and response), \"epub\" (e-book), \"markdown\", \"reader\" (clean article for reading later) or \"obsidian\" (note in a -vault)")
+	vault              *string        = flag.String("vault", "", "Obsidian vault or Logseq graph to save \"obsidian\" format notes into instead of -output")
+	singleFile         *bool          = flag.Bool("single-file", false, "Save page as one self-contained .html with all files embedded as data: URIs")
+	mhtml              *bool          = flag.Bool("mhtml", false, "Save page as one MHTML (.mht) archive with all its files, viewable in Chrome and Edge")
+	inlineThreshold    *string        = flag.String("inline-threshold", "", "Embed page files smaller than given size (e.g. 32k) as data: URIs, keep the rest as files")
+	explodeDataURIs    *bool          = flag.Bool("explode-data-uris", false, "Move big inline base64 data: URIs of the page into separate files")
+	encrypt            *string        = flag.String("encrypt", "", "Encrypt saved page into an age-encrypted tar archive for given comma-separated recipients (or \"passphrase\" to use GOSPA_PASSPHRASE)")
+	redact             *string        = flag.String("redact", "", "Path to YAML file with redaction rules to apply to the saved page, its text files and HAR and WARC records")
+	redactKeepOriginal *string        = flag.String("redact-keep-original", "", "Keep unredacted page encrypted for given comma-separated recipients (or \"passphrase\")")
+	mimeTypes          *string        = flag.String("mime-types", "", "Path to YAML file with media types of file extensions, extending and overriding the system's")
+	srcsetMode         *string        = flag.String("srcset", saver.SrcsetAll, "Which srcset image candidates to download: \"all\", \"largest\" or \"smallest\"")
+	saveAlternates     *bool          = flag.Bool("alternates", false, "Also download <link rel=alternate> resources: RSS/Atom/JSON feeds and hreflang language variants")
+	noServiceWorkers   *bool          = flag.Bool("no-service-workers", false, "Stub out service worker registration in saved pages and scripts")
+	concurrency        *uint          = flag.Uint("concurrency", uint(saver.DefaultConcurrency), "How many page files to download at once")
+	hostConcurrency    *uint          = flag.Uint("host-concurrency", 0, "How many page files to download at once from the same host. 0 means no limit besides -concurrency")
+	maxAssetSize       *string        = flag.String("max-asset-size", "", "Give up on page files bigger than given size (e.g. 100m)")
+	maxAssetTime       *time.Duration = flag.Duration("max-asset-time", 10*time.Minute, "Give up on page files still downloading after given time, such as live streams. 0 means no limit")
+	memoryThreshold    *string        = flag.String("memory-threshold", "8m", "Page files bigger than given size (e.g. 64m) are streamed to disk instead of being held in memory")
+	progress           *bool          = flag.Bool("progress", false, "Print every page file with its size as soon as it is downloaded")
+	delay              *time.Duration = flag.Duration("delay", 0, "Least time between two requests to the same host (e.g. 500ms)")
+	maxRPS             *float64       = flag.Float64("max-rps", 0, "Most requests per second to the same host. 0 means no limit")
+	delayJitter        *time.Duration = flag.Duration("delay-jitter", 0, "Up to this much is randomly added to the time between requests to the same host")
+	limitRate          *string        = flag.String("limit-rate", "", "Most bytes per second to download in total (e.g. 500k, 2m)")
+	toc                *bool          = flag.Bool("toc", false, "Put a table of contents of the page's headings at the top of long pages")
+	wiki               *bool          = flag.Bool("wiki", false, "Save MediaWiki (e.g. Wikipedia) articles as clean offline pages without edit links and navigation")
+	noSiteProfiles     *bool          = flag.Bool("no-site-profiles", false, "Do not apply built-in profiles of popular platforms (GitHub, GitLab, Medium, Substack, MediaWiki, Discourse, WordPress)")
+	siteRulesDir       *string        = flag.String("site-rules", "", "Directory with a YAML file of rules (headers, elements to remove, lazy image attributes, article, login steps, script to run) for each site")
+	userAgent          *string        = flag.String("user-agent", "", "User agent to send: chrome, firefox, edge, safari, mobile or a full value. Go's default if not set")
+	rotateUserAgent    *bool          = flag.Bool("rotate-user-agent", false, "Take turns with desktop browser user agents from request to request")
+	basicAuth          *string        = flag.String("basic-auth", "", "Log in with HTTP basic authentication as user:password")
+	bearerToken        *string        = flag.String("bearer", "", "Send \"Authorization: Bearer TOKEN\" with requests")
+	loginURL           *string        = flag.String("login-url", "", "Page with a login form to fill in with -login-field values and submit before saving")
+	loginCheck         *string        = flag.String("login-check", "", "How to tell that logging in worked: status:CODE, redirect:TEXT or selector:SELECTOR")
+	cookiesPath        *string        = flag.String("cookies", "", "Netscape cookies.txt to send cookies from with every request")
+	saveCookiesPath    *string        = flag.String("save-cookies", "", "Write cookies, including ones set by servers during the run, to this cookies.txt afterwards")
+	videoDownloader    *string        = flag.String("video-downloader", "", "yt-dlp (or compatible) executable to download videos of YouTube, Vimeo and Dailymotion pages with")
+	hooksDir           *string        = flag.String("hooks", "", "Directory with pre-fetch, post-fetch and post-save scripts to run with JSON on stdin")
+	proxy              *string        = flag.String("proxy", "", "Send every request through this proxy (http://, https:// or socks5://host:port), e.g. socks5h://127.0.0.1:9050 for Tor")
+	insecure           *bool          = flag.Bool("insecure", false, "Do not verify certificates of HTTPS servers")
+	caCertPath         *string        = flag.String("ca-cert", "", "PEM file with certificate authorities to trust in addition to the system ones")
+	clientCertPath     *string        = flag.String("client-cert", "", "PEM client certificate to present to servers that require mutual TLS")
+	clientKeyPath      *string        = flag.String("client-key", "", "PEM private key of the client certificate")
+	timeout            *time.Duration = flag.Duration("timeout", time.Minute, "Give up on a request when the server does not respond or stops sending for given time (e.g. 30s). 0 means never")
+	deadline           *time.Duration = flag.Duration("deadline", 0, "Stop the whole run after given time (e.g. 10m), keeping what has been saved by then. 0 means no deadline")
+	maxRedirects       *uint          = flag.Uint("max-redirects", uint(saver.DefaultMaxRedirects), "How many redirects a request may follow. 0 means none")
+	noCrossHostRedir   *bool          = flag.Bool("no-cross-host-redirects", false, "Refuse redirects to other hosts than the one asked")
+	retries            *uint          = flag.Uint("retries", 2, "How many times to retry a request after a network error, 5xx or 429 response")
+	compat             *bool          = flag.Bool("compat", false, "Compatibility mode for ancient or embedded-device servers: HTTP/1.1 only, no keep-alive, no compression, legacy TLS and renegotiation")
+	lite               *bool          = flag.Bool("lite", false, "Low-bandwidth profile: send Save-Data, skip media and fonts, skip images over 200KB")
+	harPath            *string        = flag.String("har", "", "Write a HAR file describing every request made while saving to given path")
+	harCredentials     *bool          = flag.Bool("har-credentials", false, "Keep Authorization, Proxy-Authorization, Cookie and Set-Cookie values in the HAR file")
+	reportPath         *string        = flag.String("report", "", "Write an HTML summary of the run to given path")
+	emailTo            *string        = flag.String("email", "", "Send saved page as an attachment to given comma-separated addresses, packed into a .tar.gz with its files if it has any")
+	smtpAddr           *string        = flag.String("smtp", "localhost:25", "SMTP server (host:port) to send emails through")
+	smtpUser           *string        = flag.String("smtp-user", "", "SMTP username. Password is taken from GOSPA_SMTP_PASSWORD")
+	emailFrom          *string        = flag.String("email-from", "", "Sender address of emails (defaults to SMTP username)")
+	zotero             *bool          = flag.Bool("zotero", false, "Save page as a single file with a RIS record attaching it, to import into Zotero as a web page with its snapshot")
+	citationFormat     *string        = flag.String("citation", "", "Also write a citation record for the saved page: \"bibtex\" or \"csl\" (CSL-JSON)")
+	render             *bool          = flag.Bool("render", false, "Render pages in a headless Chrome/Chromium and save the resulting DOM, for pages built by JavaScript")
+	typesetMath        *bool          = flag.Bool("math", false, "Render pages that typeset formulas with MathJax or KaTeX in a headless Chrome/Chromium and save the formulas typeset")
+	renderWaitFor      *string        = flag.String("render-wait-for", "", "CSS selector to wait for when rendering, instead of waiting for the network to go idle")
+	savePDF            *bool          = flag.Bool("pdf", false, "Also print the page to PDF with a headless Chrome/Chromium")
+	pdfPageSize        *string        = flag.String("pdf-page-size", "A4", "PDF page size: A3, A4, A5, letter, legal, tabloid or WIDTHxHEIGHT (e.g. 210mmx297mm)")
+	pdfMargin          *string        = flag.String("pdf-margin", "1cm", "PDF page margin (mm, cm or in)")
+	browserPath        *string        = flag.String("browser", "", "Path to Chrome/Chromium executable for headless browser features. Found automatically if not set")
+	printVariant       *bool          = flag.Bool("print", false, "Also write a print-friendly copy of the page as *.print.html")
+	a11yReport         *bool          = flag.Bool("a11y", false, "Also write an accessibility report (missing alt text, labels, heading structure, low contrast when rendering) for the saved page")
+	priority           *string        = flag.String("priority", saver.DefaultPriority, "Comma-separated order in which asset kinds are fetched (css, font, script, image, document, media, other)")
 )
 
-// matches href="link" or something down bad like hReF =  'link'
-var tagHrefRegexp *regexp.Regexp = regexp.MustCompile(`(?i)(href)[\s]*=[\s]*("|')(.*?)("|')`)
-
-// matches src="link" or even something along the lines of SrC    =  'link'
-var tagSrcRegexp *regexp.Regexp = regexp.MustCompile(`(?i)(src)[\s]*=[\s]*("|')(.*?)("|')`)
-
-// Fix relative link and construct an absolute one. Does nothing if the URL already looks alright
-func resolveLink(link url.URL, fromHost string) *url.URL {
-	var resolvedLink url.URL = link
-
-	if !link.IsAbs() {
-		if link.Scheme == "" {
-			// add scheme
-			resolvedLink.Scheme = "https"
-		}
+// Repeatable -header "Name: value" flag
+type headerFlag http.Header
 
-		if link.Host == "" {
-			// add host
-			resolvedLink.Host = fromHost
+func (headers headerFlag) String() string {
+	var lines []string
+	for key, values := range headers {
+		for _, value := range values {
+			lines = append(lines, key+": "+value)
 		}
 	}
 
-	return &resolvedLink
+	return strings.Join(lines, ", ")
 }
 
-// Cleans link from form data
-func cleanLink(link url.URL, fromHost string) *url.URL {
-	resolvedLink := resolveLink(link, fromHost)
-	cleanLink, _ := url.Parse(resolvedLink.Scheme + "://" + resolvedLink.Host + resolvedLink.Path)
-
-	return cleanLink
-}
-
-// Find all links on page that are specified in <a> tag
-func findPageLinks(pageBody []byte) []*url.URL {
-	var urls []*url.URL
-
-	for _, match := range tagHrefRegexp.FindAllString(string(pageBody), -1) {
-		var linkStartIndex int
-		var linkEndIndex int
-
-		linkStartIndex = strings.Index(match, "\"")
-		if linkStartIndex == -1 {
-			linkStartIndex = strings.Index(match, "'")
-			if linkStartIndex == -1 {
-				continue
-			}
-
-			linkEndIndex = strings.LastIndex(match, "'")
-			if linkEndIndex == -1 {
-				continue
-			}
-		} else {
-			linkEndIndex = strings.LastIndex(match, "\"")
-			if linkEndIndex == -1 {
-				continue
-			}
-		}
-		if linkEndIndex <= linkStartIndex+1 {
-			continue
-		}
-
-		parsedURL, err := url.Parse(match[linkStartIndex+1 : linkEndIndex])
-		if err != nil {
-			continue
-		}
-
-		urls = append(urls, parsedURL)
+func (headers headerFlag) Set(value string) error {
+	name, headerValue, found := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("expected \"Name: value\"")
 	}
 
-	return urls
+	http.Header(headers).Add(name, strings.TrimSpace(headerValue))
+	return nil
 }
 
-func findPageSrcLinks(pageBody []byte) []*url.URL {
-	var urls []*url.URL
-
-	// for every element that has "src" attribute
-	for _, match := range tagSrcRegexp.FindAllString(string(pageBody), -1) {
-		var linkStartIndex int
-		var linkEndIndex int
-
-		linkStartIndex = strings.Index(match, "\"")
-		if linkStartIndex == -1 {
-			linkStartIndex = strings.Index(match, "'")
-			if linkStartIndex == -1 {
-				continue
-			}
-
-			linkEndIndex = strings.LastIndex(match, "'")
-			if linkEndIndex == -1 {
-				continue
-			}
-		} else {
-			linkEndIndex = strings.LastIndex(match, "\"")
-			if linkEndIndex == -1 {
-				continue
-			}
-		}
-
-		if linkEndIndex <= linkStartIndex+1 {
-			continue
-		}
-
-		parsedURL, err := url.Parse(match[linkStartIndex+1 : linkEndIndex])
-		if err != nil {
-			continue
-		}
+var headers headerFlag = make(headerFlag)
 
-		urls = append(urls, parsedURL)
-	}
+// Repeatable -login-field "name=value" flag
+type fieldFlag url.Values
 
-	return urls
+func (fields fieldFlag) String() string {
+	return url.Values(fields).Encode()
 }
 
-func findPageFileContentURLs(pageBody []byte) []*url.URL {
-	var urls []*url.URL
-
-	for _, link := range findPageLinks(pageBody) {
-		if strings.Contains(link.Path, ".css") ||
-			strings.Contains(link.Path, ".scss") ||
-			strings.Contains(link.Path, ".js") ||
-			strings.Contains(link.Path, ".mjs") {
-			urls = append(urls, link)
-		}
+func (fields fieldFlag) Set(value string) error {
+	name, fieldValue, found := strings.Cut(value, "=")
+	if !found || name == "" {
+		return fmt.Errorf("expected \"name=value\"")
 	}
-	urls = append(urls, findPageSrcLinks(pageBody)...)
 
-	return urls
+	url.Values(fields).Add(name, fieldValue)
+	return nil
 }
 
-func savePage(pageBody []byte, saveDirPath string, from *url.URL) error {
-	// Create directory with all file content on the page
-	var pageFilesDirectoryName string = fmt.Sprintf(
-		"%s_%s_files",
-		from.Host,
-		strings.ReplaceAll(from.EscapedPath(), "/", "_"),
-	)
-	err := os.MkdirAll(filepath.Join(saveDirPath, pageFilesDirectoryName), os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to create directory to store file contents in: %s", err)
-	}
-
-	srcLinks := findPageFileContentURLs(pageBody)
-	wg := sync.WaitGroup{}
-	for _, srcLink := range srcLinks {
-		wg.Add(1)
-
-		resolvedLink := resolveLink(*srcLink, from.Host)
-		func(link *url.URL, saveDirPath string, wg *sync.WaitGroup) error {
-			cleanLink := cleanLink(*srcLink, srcLink.Host)
-
-			defer wg.Done()
-			response, err := http.Get(link.String())
-			if err != nil {
-				return fmt.Errorf("failed to receive response from %s: %s", cleanLink.String(), err)
-			}
-			defer response.Body.Close()
-
-			contents, err := io.ReadAll(response.Body)
-			if err != nil {
-				return fmt.Errorf("failed to read response from %s: %s", cleanLink.String(), err)
-			}
-
-			outputFile, err := os.Create(filepath.Join(saveDirPath, path.Base(cleanLink.String())))
-			if err != nil {
-				return fmt.Errorf("failed to create output file for %s: %s", cleanLink.String(), err)
-			}
-			defer outputFile.Close()
-
-			outputFile.Write(contents)
-
-			return nil
-		}(resolvedLink, filepath.Join(saveDirPath, pageFilesDirectoryName), &wg)
-	}
-
-	// Redirect old URLs to local files
-	for _, srcLink := range srcLinks {
-		cleanLink := cleanLink(*srcLink, srcLink.Host)
-		pageBody = bytes.ReplaceAll(
-			pageBody,
-			[]byte(srcLink.String()),
-			[]byte("./"+filepath.Join(pageFilesDirectoryName, path.Base(cleanLink.String()))),
-		)
-	}
-
-	// Create page output file
-	outfile, err := os.Create(filepath.Join(
-		saveDirPath,
-		fmt.Sprintf(
-			"%s_%s.html",
-			from.Host,
-			strings.ReplaceAll(from.EscapedPath(), "/", "_")),
-	))
-	if err != nil {
-		fmt.Printf("Failed to create output file: %s\n", err)
-		return err
-	}
-	defer outfile.Close()
-
-	outfile.Write(pageBody)
-
-	wg.Wait()
+var loginFields fieldFlag = make(fieldFlag)
 
-	return nil
+func init() {
+	flag.Var(headers, "header", "Header to send with every request, as \"Name: value\". May be repeated")
+	flag.Var(loginFields, "login-field", "Login form field to fill in, as \"name=value\". May be repeated")
 }
 
 func main() {
@@ -246,14 +165,120 @@ func main() {
 		fmt.Printf(
 			`Gospa - GO and Save this (web) PAge
 Usage: gospa (optional)[FLAGs]... (mandatory)-url [webpage URL]
+       gospa scan [saved page, its directory or .tar, .epub, .mht or .warc(.gz) archive]...
+       gospa merge [saved page directory or .tar archive]... -o [combined collection directory]
+       gospa mirror [webpage URL] (optional)[FLAGs]...
 
 Flags:
--help -> Print this message and exit
--version -> Print version information and exit
--url (string) -> Specify URL to the webpage to be saved
+-help (bool) -> Print this message and exit
+-version (bool) -> Print version information and exit
+-url (string) -> Specify URL to the webpage to be saved. http(s):// and ftp(s):// URLs are supported; FTP directories are saved as listing pages and anonymous login is used unless the URL has credentials
+-depth (uint) -> Also save pages linked from the page, following links up to given depth. Links between saved pages are rewritten to local copies. Default: 0
+-max-pages (uint) -> Stop fetching pages when saving recursively (-depth or -thread) once this many have been fetched, the start page included. Links to pages left out keep pointing online. 0 means no limit. Default: 0
+-max-time (duration) -> Stop fetching pages when saving recursively after given time (e.g. 30m). Unlike -deadline, the pages fetched by then are saved with all their files. 0 means no limit. Default: 0
+-span-hosts (bool) -> Follow links to other hosts when saving recursively. Pages are fetched level by level, taking turns between hosts within a level, so that one big site does not crowd out the others under -max-pages or -max-time
+-no-robots (bool) -> Do not follow robots.txt when saving recursively. By default, with -depth or -thread, robots.txt of every crawled host is fetched first: pages it disallows for gospa (or for everyone) are not saved, and its Crawl-delay (up to a minute) is kept between requests to the host
+-thread (bool) -> Save every page of a paginated forum thread (Discourse, phpBB and others) or listing by following its rel="next" links, up to 1000 pages. Pages link to one another's local copies, links to posts keep their anchors. Cannot be used together with -depth
+-languages (string) -> Comma-separated languages to also save the page in (e.g. en,ru). Uses the page's hreflang alternates or asks the server via Accept-Language; saved versions are cross-linked
+-output (string) -> Directory to save the page into (created if missing). Defaults to the working directory
+-format (string) -> Output format: "html" (page with its files directory) "warc" (WARC 1.1 file with every HTTP request and response, headers included, replayable with pywb or ReplayWeb.page; a response whose body has been stored already, by this or an earlier WARC file of the run, is kept as a revisit record pointing at it. A CDXJ index (.cdxj) is written next to it, so that pywb and OpenWayback can serve it right away) "epub" (e-book with the page, its images, stylesheets and fonts), "markdown" (Markdown with images saved alongside and links kept), "reader" (just the article in a clean built-in style, with a table of contents and estimated reading time, images saved alongside) or "obsidian" (just the article as a Markdown note in the -vault, with YAML frontmatter giving its title, source, author, publication date, the date it was saved and tags from its keywords, and its images in the vault's attachment folder). Default: html
+-vault (string) -> Obsidian vault or Logseq graph directory "obsidian" format saves notes into, taking the place of -output. Notes go into its root and images into the attachment folder set in .obsidian/app.json; a Logseq graph (one with a logseq directory) gets notes in pages and images in assets. Images of a note are kept in a NOTE_files folder there, so that notes do not overwrite each other's. Default: none
+-single-file (bool) -> Save page as one self-contained .html with CSS, scripts, images and fonts embedded as data: URIs
+-mhtml (bool) -> Save page as one MHTML (.mht) archive holding the page and all its files with their original Content-Types. Opens directly in Chrome and Edge
+-inline-threshold (string) -> Embed page files smaller than given size (e.g. 32k, 1.5m) as data: URIs and keep bigger ones as files, combining single-file portability with sane sizes for large media
+-explode-data-uris (bool) -> Move inline data: URIs of 1KB and bigger from the page into separate files in its files directory, shrinking the saved HTML
+-encrypt (string) -> Encrypt saved page into an age-encrypted tar archive for given comma-separated recipients (age1...) or "passphrase" to use GOSPA_PASSPHRASE environment variable
+-redact (string) -> Path to YAML file with redaction rules to apply to the saved page, its text files (stylesheets, scripts and the like) and the -har and -format warc records
+-redact-keep-original (string) -> Keep unredacted page encrypted for given comma-separated recipients (age1...) or "passphrase"
+-mime-types (string) -> Path to YAML file with media types of file extensions, extending and overriding the system's. Used to tell what kind of file a page file is, to name files taken out of data: URIs and to label files in single-file, MHTML and EPUB output. AVIF, JPEG XL, APNG, WebAssembly, web fonts and common audio and video types are known without it
+-srcset (string) -> Which srcset and <picture> image candidates to download: "all", "largest" or "smallest". Default: all
+-alternates (bool) -> Also download <link rel=alternate> resources: RSS/Atom/JSON feeds and hreflang language variants
+-no-service-workers (bool) -> Stub out service worker registration in saved pages and scripts, so they do not break offline viewing
+-concurrency (uint) -> How many page files to download at once. Default: 4
+-host-concurrency (uint) -> How many page files to download at once from the same host, to go easy on small servers. 0 means no limit besides -concurrency. Default: 0
+-max-asset-size (string) -> Give up on page files bigger than given size (e.g. 100m), leaving references to them pointing online. Default: no limit
+-max-asset-time (duration) -> Give up on page files still downloading after given time (e.g. 2m), as live streams and other endless responses would otherwise hold up saving forever. Server-sent event streams and MJPEG-like streams are never downloaded. 0 means no limit. Default: 10m
+-memory-threshold (string) -> Page files bigger than given size (e.g. 64m) are streamed straight to disk instead of being held in memory. With -depth, pages waiting for the pages they link to are held in memory up to as much together, past it they are saved with links to pages not fetched yet left pointing online. Default: 8m
+-progress (bool) -> Print every page file with its status and size as soon as it is done
+-delay (duration) -> Least time between two requests to the same host (e.g. 500ms), to go easy on it. Default: 0
+-max-rps (float) -> Most requests per second to the same host (e.g. 2 or 0.5). 0 means no limit. Default: 0
+-delay-jitter (duration) -> Up to this much is randomly added to the time between requests to the same host, so they do not come like clockwork. Default: 0
+-limit-rate (string) -> Most bytes per second to download across all requests together (e.g. 500k, 2m), to leave room on metered or shared connections. Default: no limit
+-toc (bool) -> Put a table of contents of h1-h3 headings at the top of pages with at least 3 of them, for "html" and "epub" formats. Headings without an id get one made of their text, so anchors stay the same across saves. The "reader" format always has one. Default: false
+-wiki (bool) -> Save MediaWiki (e.g. Wikipedia) articles as clean offline pages: only the heading and the article, without edit links, navigation boxes and the site's menus, with image links leading to the full-size images instead of their description pages. Other pages are saved as usual. Default: false
+-no-site-profiles (bool) -> Do not apply built-in profiles of popular platforms (GitHub, GitLab, Medium, Substack, MediaWiki, Discourse, WordPress), recognized by domain or page markup. Profiles keep only the article when saving as Markdown or EPUB and reveal lazily loaded images so that they get saved. GitHub and GitLab file pages also get their raw file saved next to them (NAME.raw.EXT), unless -redact is used. Default: false
+-site-rules (string) -> Directory with a YAML file of rules for each site (see Site rules below): headers to send, elements to remove, lazy image attributes, where the article is, steps of logging in and a JavaScript script to run in its pages. A site with login steps is logged in to before the first of its pages is fetched, once for the whole run. Pages of a site with a script are rendered in a headless Chrome/Chromium, as with -render, and the script runs once they have loaded, e.g. to expand comments or dismiss overlays. Rules of a site take the place of its built-in profile. Default: none
+-video-downloader (string) -> yt-dlp (or a compatible downloader) executable to download the video of YouTube, Vimeo and Dailymotion pages with (e.g. yt-dlp or /usr/local/bin/yt-dlp). The video is saved among the page's files and played by a player put at the top of the saved page, since the platform's own player does not work offline. It is run with the same -proxy, -user-agent, cookies and -insecure as every other request, and not at all if the proxy is one it cannot use. Only for "html" format without -single-file, -mhtml or -inline-threshold. Default: none
+-hooks (string) -> Directory with executable pre-fetch, post-fetch and post-save scripts (any extension), run before every request, after every response and after every saved page with JSON describing it on stdin ({"event", "url", "headers", "status", "output_path"}). A pre-fetch script may print {"url": "...", "headers": {"Name": "value"}} to change the request, e.g. to add credentials or fix up URLs. Default: none
+-proxy (string) -> Send every request through this proxy: http://, https:// or socks5://host:port. Pages on onion services can be saved through Tor with socks5h://127.0.0.1:9050; links to them without a scheme are taken as http and their requests get 3 times the -timeout. Default: none
+-user-agent (string) -> User agent to send with every request: a preset (chrome, firefox, edge, safari, mobile) or a full value. Also used by the headless browser. Default: Go's default
+-rotate-user-agent (bool) -> Take turns with the chrome, firefox, edge and safari user agents from request to request instead. Default: false
+-basic-auth (string) -> Log in with HTTP basic authentication as user:password. Default: none
+-bearer (string) -> Send "Authorization: Bearer TOKEN" with requests. Credentials of -basic-auth and -bearer are only sent to the host of the page being saved, so that they do not leak to CDNs and other sites. Default: none
+-login-url (string) -> Page with a login form to fill in and submit before saving, keeping the session cookies for the run (save them with -save-cookies to reuse them). The form with a password field is used; its other fields, such as CSRF tokens, are submitted as they are. If page files start coming back as the login page midway, gospa logs in once more and fetches them again. Default: none
+-login-field (string) -> Login form field to fill in, as "name=value" (e.g. "username=me"). May be repeated. Default: none
+-login-check (string) -> How to tell that logging in worked: status:CODE, redirect:TEXT (the URL ended up at contains TEXT) or selector:SELECTOR (the resulting page has a matching element, e.g. selector:a.logout). Default: any response below 400
+-cookies (string) -> Netscape-format cookies.txt (as exported by browser extensions, curl or wget) to send cookies from with every request, e.g. to save pages behind a login. Default: none
+-save-cookies (string) -> Write cookies, including ones set by servers during the run, to this cookies.txt afterwards. May be the same file as -cookies. Default: none
+-header (string) -> Header to send with every request for the page and its files, as "Name: value" (e.g. "Accept-Language: de", "Authorization: Bearer ..."). May be repeated. Default: none
+-insecure (bool) -> Do not verify certificates of HTTPS servers, e.g. for internal servers with self-signed certificates. Anyone on the way can then read and change what is saved. Default: false
+-ca-cert (string) -> PEM file with certificate authorities to trust in addition to the system ones, e.g. of a private PKI of internal servers. Only applies to requests gospa makes itself, not to the headless browser. Default: none
+-client-cert (string) -> PEM client certificate to present to servers that require mutual TLS (mTLS). Needs -client-key. Default: none
+-client-key (string) -> PEM private key of the -client-cert certificate. Default: none
+-timeout (duration) -> Give up on a request when the server does not respond, or stops sending, for given time (e.g. 30s). Requests timing out before the response arrives are retried like other failures. 0 means never. Default: 1m
+-deadline (duration) -> Stop the whole run after given time (e.g. 10m): downloads in flight are cancelled and what has been saved by then is kept, same as on Ctrl-C. 0 means no deadline. Default: 0
+-max-redirects (uint) -> How many redirects a request may follow before giving up on it. 0 means none are followed. Pages are saved as coming from where their redirects end: links on them are resolved against it, and the page asked for is named after it. Default: 10
+-no-cross-host-redirects (bool) -> Refuse redirects to other hosts than the one asked, e.g. to login pages of identity providers or parked domains, instead of saving what they lead to
+-retries (uint) -> How many times to retry a request after a network error, 5xx or 429 response, with growing randomized delays or as long as the server asks with Retry-After (up to 2 minutes). A host failing 5 times within 30 seconds is left alone for a minute and its remaining files are skipped. Default: 2
+-compat (bool) -> Compatibility mode for ancient or embedded-device servers: forces HTTP/1.1 without keep-alive or compression, allows TLS 1.0/1.1 and server-initiated renegotiation
+-lite (bool) -> Low-bandwidth profile: send Save-Data header, skip media and fonts, skip images over 200KB, prefer compressed image formats
+-har (string) -> Write a HAR file describing every request made while saving (URLs, timings, status codes, sizes, headers) to given path. Authorization, Proxy-Authorization, Cookie and Set-Cookie values are redacted. With -encrypt it is put into the encrypted archive under its file name instead
+-har-credentials (bool) -> Keep Authorization, Proxy-Authorization, Cookie and Set-Cookie values in the -har file
+-report (string) -> Write an HTML summary of the run (saved pages, fetched and failed assets, sizes, durations) to given path
+-email (string) -> Send saved page as an attachment to given comma-separated addresses. A page saved as one file (archive, single file, MHTML, EPUB) is attached as it is, otherwise the page, its files and extras are packed into a .tar.gz. Files over 10MB in total are not attached, the message tells where they are instead
+-smtp (string) -> SMTP server (host:port) to send emails through. Default: localhost:25
+-smtp-user (string) -> SMTP username. Password is taken from GOSPA_SMTP_PASSWORD environment variable
+-email-from (string) -> Sender address of emails (defaults to SMTP username)
+-citation (string) -> Also write a citation record for the saved page: "bibtex" or "csl" (CSL-JSON)
+-zotero (bool) -> Save page as a single file (as with -single-file), the way Zotero keeps web page snapshots, and write a RIS record (NAME.ris) next to it with the page's title, author, site, dates and address and the page attached. Importing the record into Zotero (File > Import) makes a web page item with the page stored as its snapshot. Not for -mhtml, -inline-threshold or other formats than "html"
+-render (bool) -> Render pages in a headless Chrome/Chromium and save the DOM their scripts produced, along with everything it references. Scripts and WebAssembly modules the page loaded at runtime, such as workers and dynamic imports, are saved among its files too. For sites that build pages client-side
+-render-wait-for (string) -> CSS selector of an element to wait for when rendering. By default rendering waits until the network goes idle
+-math (bool) -> Render pages that typeset formulas with MathJax or KaTeX in a headless Chrome/Chromium, as with -render, and save the formulas typeset along with the fonts they need. The MathJax and KaTeX scripts are left out of rendered pages with typeset formulas, so that they do not try to load their parts from the network and typeset them again offline. Without it, such pages are saved as served with a warning, as their formulas may show as raw TeX offline
+-pdf (bool) -> Also print the page to PDF next to the saved page, using a headless Chrome/Chromium. With -redact, the redacted page is printed without its scripts instead of the live one
+-pdf-page-size (string) -> PDF page size: A3, A4, A5, letter, legal, tabloid or WIDTHxHEIGHT with units (e.g. 210mmx297mm). Default: A4
+-pdf-margin (string) -> PDF page margin in mm, cm or in. Default: 1cm
+-browser (string) -> Path to Chrome/Chromium executable for headless browser features. Found automatically if not set
+-print (bool) -> Also write a print-friendly copy of the page as *.print.html: scripts removed, fixed widths, floats and navigation dropped, and outside links followed by their address. For "html" and "reader" formats without -mhtml
+-a11y (bool) -> Also write an accessibility report (missing alt text, labels, heading structure) for the saved page. Rendered pages (-render or a site rules script) also get hints about text with too little contrast against its background, from the colors the browser computed
+-priority (string) -> Comma-separated order in which asset kinds are fetched (css, font, script, image, document, media, other). Default: css,font,script,image,document,other,media
+
+Commands:
+scan -> Report likely personal data (emails, phone numbers, national IDs) found in saved content: pages, their files directories and .tar, EPUB, MHTML and WARC archives. Encrypted archives have to be decrypted first. Exits with 1 if something could not be scanned
+mirror -> Save a whole small site into a directory named after its host, like wget --mirror --convert-links: same as -depth 50 -max-pages 5000 -host-concurrency 2 -delay 250ms -max-asset-size 200m, following robots.txt and staying on the site. Pages are saved side by side named after their URLs, linking to one another's local copies. Flags given after the URL take precedence, e.g. gospa mirror https://example.com -max-pages 100 -output site
+merge -> Combine saved page directories and .tar archives into one collection with an index.html of all pages. Identical files are stored once; nothing in the combined collection directory is overwritten and its earlier index is extended. Archives with entries leading outside the collection are refused
 `,
 		)
 	}
+
+	if len(os.Args) > 1 && os.Args[1] == "scan" {
+		runScan(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		runMerge(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "mirror" {
+		mirrorArgs, ok := mirrorArguments(os.Args[2:])
+		if !ok {
+			fmt.Printf("Usage: gospa mirror [webpage URL] (optional)[FLAGs]...\n")
+			return
+		}
+		os.Args = append(os.Args[:1], mirrorArgs...)
+	}
+
 	flag.Parse()
 
 	if *help {
@@ -262,7 +287,7 @@ Flags:
 	}
 
 	if *version {
-		fmt.Printf("Gospa %s\nBy Kasyanov Nikolay Alexeyevich (Unbewohnte)\n", VERSION)
+		fmt.Printf("Gospa %s\nBy Kasyanov Nikolay Alexeyevich (Unbewohnte)\n", saver.VERSION)
 		return
 	}
 
@@ -279,28 +304,184 @@ Flags:
 		return
 	}
 
-	workingDir, err := os.Getwd()
-	if err != nil {
-		fmt.Printf("Failed to figure out working directory: %s\n", err)
+	var threshold int64 = 0
+	if *inlineThreshold != "" {
+		threshold, err = parseSize(*inlineThreshold)
+		if err != nil || threshold == 0 {
+			fmt.Printf("Invalid inline threshold \"%s\"\n", *inlineThreshold)
+			return
+		}
+	}
+
+	memoryThresholdSize, err := parseSize(*memoryThreshold)
+	if err != nil || memoryThresholdSize == 0 {
+		fmt.Printf("Invalid memory threshold \"%s\"\n", *memoryThreshold)
 		return
 	}
 
-	response, err := http.Get(parsedURL.String())
+	var maxAssetSizeBytes int64 = 0
+	if *maxAssetSize != "" {
+		maxAssetSizeBytes, err = parseSize(*maxAssetSize)
+		if err != nil || maxAssetSizeBytes == 0 {
+			fmt.Printf("Invalid page file size limit \"%s\"\n", *maxAssetSize)
+			return
+		}
+	}
+
+	var limitRateSize int64 = 0
+	if *limitRate != "" {
+		limitRateSize, err = parseSize(*limitRate)
+		if err != nil || limitRateSize == 0 {
+			fmt.Printf("Invalid rate limit \"%s\"\n", *limitRate)
+			return
+		}
+	}
+
+	var redirectLimit int = int(*maxRedirects)
+	if redirectLimit == 0 {
+		// 0 would mean the default
+		redirectLimit = -1
+	}
+
+	var options saver.Options = saver.Options{
+		OutputDir:          *outputPath,
+		Vault:              *vault,
+		Depth:              *depth,
+		MaxPages:           int(*maxPages),
+		MaxTime:            *maxTime,
+		SpanHosts:          *spanHosts,
+		Thread:             *thread,
+		NoRobots:           *noRobots,
+		Languages:          *languages,
+		Format:             *format,
+		SingleFile:         *singleFile,
+		MHTML:              *mhtml,
+		InlineThreshold:    threshold,
+		ExplodeDataURIs:    *explodeDataURIs,
+		Encrypt:            *encrypt,
+		RedactionRules:     *redact,
+		RedactKeepOriginal: *redactKeepOriginal,
+		MediaTypesPath:     *mimeTypes,
+		Srcset:             *srcsetMode,
+		Alternates:         *saveAlternates,
+		NoServiceWorkers:   *noServiceWorkers,
+		Concurrency:        int(*concurrency),
+		HostConcurrency:    int(*hostConcurrency),
+		Delay:              *delay,
+		MaxRPS:             *maxRPS,
+		DelayJitter:        *delayJitter,
+		LimitRate:          limitRateSize,
+		TOC:                *toc,
+		Wiki:               *wiki,
+		NoSiteProfiles:     *noSiteProfiles,
+		SiteRulesDir:       *siteRulesDir,
+		VideoDownloader:    *videoDownloader,
+		HooksDir:           *hooksDir,
+		UserAgent:          *userAgent,
+		RotateUserAgent:    *rotateUserAgent,
+		BasicAuth:          *basicAuth,
+		BearerToken:        *bearerToken,
+		LoginURL:           *loginURL,
+		LoginFields:        url.Values(loginFields),
+		LoginCheck:         *loginCheck,
+		CookiesPath:        *cookiesPath,
+		SaveCookiesPath:    *saveCookiesPath,
+		Headers:            http.Header(headers),
+		Proxy:              *proxy,
+		Insecure:           *insecure,
+		CACertPath:         *caCertPath,
+		ClientCertPath:     *clientCertPath,
+		ClientKeyPath:      *clientKeyPath,
+		Timeout:            *timeout,
+		Retries:            *retries,
+		MaxRedirects:       redirectLimit,
+		SameHostRedirects:  *noCrossHostRedir,
+		Compat:             *compat,
+		Lite:               *lite,
+		HARPath:            *harPath,
+		HARCredentials:     *harCredentials,
+		Render:             *render,
+		RenderWaitFor:      *renderWaitFor,
+		Math:               *typesetMath,
+		PDF:                *savePDF,
+		PDFPageSize:        *pdfPageSize,
+		PDFMargin:          *pdfMargin,
+		BrowserPath:        *browserPath,
+		Citation:           *citationFormat,
+		Zotero:             *zotero,
+		Accessibility:      *a11yReport,
+		Print:              *printVariant,
+		Priority:           *priority,
+		MemoryThreshold:    memoryThresholdSize,
+		MaxAssetSize:       maxAssetSizeBytes,
+		MaxAssetTime:       *maxAssetTime,
+		OnMessage: func(message string) {
+			fmt.Printf("%s\n", message)
+		},
+	}
+	if *progress {
+		options.OnAsset = func(outcome saver.AssetOutcome) {
+			fmt.Printf("%s %s (%s)\n", outcome.Status, outcome.URL, formatSize(outcome.Size))
+		}
+	}
+
+	pageSaver, err := saver.New(options)
 	if err != nil {
-		fmt.Printf("Failed to GET %s: %s\n", *urlStr, err)
+		fmt.Printf("Invalid settings: %s\n", err)
 		return
 	}
-	defer response.Body.Close()
+	defer pageSaver.Close()
+
+	// on Ctrl-C stop downloading and write out whatever has been fetched by then
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-interrupted.Done()
+		// a second Ctrl-C kills the program right away
+		stop()
+	}()
+	var ctx context.Context = interrupted
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
 
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		fmt.Printf("Failed to read response from %s: %s\n", *urlStr, err)
+	result, err := pageSaver.Save(ctx, parsedURL.String())
+	if errors.Is(err, context.DeadlineExceeded) && result != nil {
+		// keep going with whatever has been saved by then
+		fmt.Printf("Deadline of %s exceeded, saving stopped early\n", deadline.String())
+	} else if errors.Is(err, context.Canceled) && result != nil {
+		fmt.Printf("Interrupted, saving stopped early\n")
+	} else if err != nil {
+		fmt.Printf("Failed to save %s: %s\n", parsedURL.String(), err)
 		return
 	}
 
-	err = savePage(body, workingDir, parsedURL)
-	if err != nil {
-		fmt.Printf("Failed to save page at %s: %s", parsedURL.String(), err)
+	for _, page := range result.Pages {
+		err = page.Err()
+		if err != nil {
+			fmt.Printf("Not everything of %s has been saved: %s\n", page.URL, err)
+		}
+	}
+
+	if len(result.Pages) == 0 {
 		return
 	}
+
+	if *reportPath != "" {
+		err = writeReport(*reportPath, result)
+		if err != nil {
+			fmt.Printf("Failed to write report: %s\n", err)
+			return
+		}
+	}
+
+	if *emailTo != "" {
+		err = emailSavedPage(*smtpAddr, *smtpUser, *emailFrom, *emailTo, parsedURL.String(), result.OutputDir, savedFiles(result))
+		if err != nil {
+			fmt.Printf("Failed to email saved page: %s\n", err)
+			return
+		}
+	}
 }
@@ -0,0 +1,56 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"Unbewohnte/gospa/pkg/saver"
+)
+
+// Entry point of "gospa merge"
+func runMerge(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	outputDir := flags.String("o", "", "Directory to put the combined collection into")
+
+	// flags may come before, after or between the inputs
+	var inputs []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if len(inputs) == 0 || *outputDir == "" {
+		fmt.Printf("Usage: gospa merge [saved page directory or .tar archive]... -o [combined collection directory]\n")
+		return
+	}
+
+	result, err := saver.Merge(*outputDir, inputs)
+	if err != nil {
+		fmt.Printf("Failed to merge: %s\n", err)
+		return
+	}
+
+	fmt.Printf(
+		"Merged %d page(s) into %s, %d duplicate file(s) (%s) stored once\n",
+		len(result.Pages), *outputDir, result.Deduplicated, formatSize(result.SavedBytes),
+	)
+}
@@ -0,0 +1,46 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Flags "gospa mirror" saves a site with, before the ones given to it, which take precedence.
+// Robots.txt is followed and links between saved pages are pointed at their local copies as with any -depth
+var mirrorPreset []string = []string{
+	"-depth", "50",
+	"-max-pages", "5000",
+	"-host-concurrency", "2",
+	"-delay", "250ms",
+	"-max-asset-size", "200m",
+}
+
+// Arguments of "gospa mirror [webpage URL] [FLAG]..." as the usual ones: the preset flags, the output
+// directory named after the site, the URL and the given flags. ok is false if there is no URL
+func mirrorArguments(args []string) (mirrorArgs []string, ok bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return nil, false
+	}
+
+	pageURL, err := url.Parse(strings.TrimSpace(args[0]))
+	if err != nil || pageURL.Hostname() == "" {
+		return nil, false
+	}
+
+	mirrorArgs = append(mirrorArgs, mirrorPreset...)
+	mirrorArgs = append(mirrorArgs, "-output", pageURL.Hostname(), "-url", args[0])
+
+	return append(mirrorArgs, args[1:]...), true
+}
@@ -0,0 +1,314 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Finds text the browser draws with too little contrast against its background, as WCAG 2 counts it:
+// 4.5:1 for normal text, 3:1 for large (24px, or 18.66px bold). Text over background images is skipped,
+// as its background cannot be told from the styles
+const contrastScript string = `(() => {
+	const parse = (value) => {
+		const match = value.match(/rgba?\(([^)]+)\)/);
+		if (!match) return null;
+		const parts = match[1].split(/[\s,\/]+/).filter(Boolean).map(parseFloat);
+		return {r: parts[0], g: parts[1], b: parts[2], a: parts.length > 3 ? parts[3] : 1};
+	};
+	const blend = (top, bottom) => ({
+		r: top.r * top.a + bottom.r * (1 - top.a),
+		g: top.g * top.a + bottom.g * (1 - top.a),
+		b: top.b * top.a + bottom.b * (1 - top.a),
+		a: 1,
+	});
+	const luminance = (color) => {
+		const channel = (value) => {
+			value /= 255;
+			return value <= 0.03928 ? value / 12.92 : Math.pow((value + 0.055) / 1.055, 2.4);
+		};
+		return 0.2126 * channel(color.r) + 0.7152 * channel(color.g) + 0.0722 * channel(color.b);
+	};
+	const background = (element) => {
+		const layers = [];
+		for (let node = element; node; node = node.parentElement) {
+			const style = getComputedStyle(node);
+			if (style.backgroundImage !== "none") return null;
+			const color = parse(style.backgroundColor);
+			if (color && color.a > 0) {
+				layers.push(color);
+				if (color.a >= 1) break;
+			}
+		}
+		let color = {r: 255, g: 255, b: 255, a: 1};
+		for (let index = layers.length - 1; index >= 0; index--) color = blend(layers[index], color);
+		return color;
+	};
+	const describe = (element) => {
+		let description = "<" + element.localName;
+		if (element.id) description += " id=\"" + element.id + "\"";
+		const className = element.getAttribute("class");
+		if (className) description += " class=\"" + className.slice(0, 60) + "\"";
+		return description + ">";
+	};
+
+	const hints = [];
+	for (const element of document.body ? document.body.querySelectorAll("*") : []) {
+		const text = Array.from(element.childNodes)
+			.filter((node) => node.nodeType === Node.TEXT_NODE)
+			.map((node) => node.textContent).join(" ").replace(/\s+/g, " ").trim();
+		if (!text || element.getClientRects().length === 0) continue;
+		const style = getComputedStyle(element);
+		if (style.visibility !== "visible") continue;
+
+		const back = background(element);
+		const fore = parse(style.color);
+		if (!back || !fore) continue;
+		const lighter = Math.max(luminance(blend(fore, back)), luminance(back));
+		const darker = Math.min(luminance(blend(fore, back)), luminance(back));
+		const ratio = (lighter + 0.05) / (darker + 0.05);
+		const size = parseFloat(style.fontSize);
+		const required = size >= 24 || (parseInt(style.fontWeight) >= 700 && size >= 18.66) ? 3 : 4.5;
+		if (ratio < required) {
+			hints.push({element: describe(element), text: text.slice(0, 60), ratio: ratio, required: required});
+		}
+	}
+	return hints;
+})()`
+
+// Text with too little contrast, as found by contrastScript
+type contrastHint struct {
+	Element  string  `json:"element"`
+	Text     string  `json:"text"`
+	Ratio    float64 `json:"ratio"`
+	Required float64 `json:"required"`
+}
+
+func (hint contrastHint) String() string {
+	return fmt.Sprintf("Low contrast %.2f:1 (%.1f:1 needed): %s \"%s\"", hint.Ratio, hint.Required, hint.Element, hint.Text)
+}
+
+// Get attribute's value and whether it is present at all
+func getAttribute(node *html.Node, key string) (string, bool) {
+	for _, attribute := range node.Attr {
+		if attribute.Key == key {
+			return attribute.Val, true
+		}
+	}
+
+	return "", false
+}
+
+// All text inside the node, whitespace collapsed
+func nodeText(node *html.Node) string {
+	var text strings.Builder
+
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			text.WriteString(node.Data)
+			text.WriteString(" ")
+		}
+		if node.Type == html.ElementNode && node.Data == "img" {
+			alt, _ := getAttribute(node, "alt")
+			text.WriteString(alt)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// Short human-readable description of an element for the report
+func describeElement(node *html.Node) string {
+	var description string = "<" + node.Data
+	for _, key := range []string{"id", "class", "src", "href", "name", "type"} {
+		if value, ok := getAttribute(node, key); ok && value != "" {
+			if len(value) > 60 {
+				value = value[:57] + "..."
+			}
+			description += fmt.Sprintf(" %s=\"%s\"", key, value)
+		}
+	}
+
+	return description + ">"
+}
+
+// Check the page for common accessibility problems and return a list of findings
+func auditAccessibility(pageBody []byte) ([]string, error) {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []string
+	var labelled map[string]bool = make(map[string]bool)
+	var inputs []*html.Node
+	var headings []*html.Node
+	var hasLang bool = false
+	var hasTitle bool = false
+
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "html":
+				if lang, ok := getAttribute(node, "lang"); ok && strings.TrimSpace(lang) != "" {
+					hasLang = true
+				}
+
+			case "title":
+				hasTitle = nodeText(node) != ""
+
+			case "img", "area":
+				if _, ok := getAttribute(node, "alt"); !ok {
+					if role, _ := getAttribute(node, "role"); role != "presentation" && role != "none" {
+						findings = append(findings, "Missing alt text: "+describeElement(node))
+					}
+				}
+
+			case "input":
+				inputType, _ := getAttribute(node, "type")
+				switch strings.ToLower(inputType) {
+				case "hidden", "submit", "reset", "button", "image":
+				default:
+					inputs = append(inputs, node)
+				}
+
+			case "select", "textarea":
+				inputs = append(inputs, node)
+
+			case "label":
+				if target, ok := getAttribute(node, "for"); ok {
+					labelled[target] = true
+				}
+
+			case "a":
+				if _, ok := getAttribute(node, "href"); ok {
+					label, _ := getAttribute(node, "aria-label")
+					title, _ := getAttribute(node, "title")
+					if nodeText(node) == "" && strings.TrimSpace(label) == "" && strings.TrimSpace(title) == "" {
+						findings = append(findings, "Link without text: "+describeElement(node))
+					}
+				}
+
+			case "button":
+				label, _ := getAttribute(node, "aria-label")
+				if nodeText(node) == "" && strings.TrimSpace(label) == "" {
+					findings = append(findings, "Button without text: "+describeElement(node))
+				}
+
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				headings = append(headings, node)
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(document)
+
+	if !hasLang {
+		findings = append(findings, "Document language is not declared (<html lang=\"...\">)")
+	}
+	if !hasTitle {
+		findings = append(findings, "Document has no title")
+	}
+
+	for _, input := range inputs {
+		id, _ := getAttribute(input, "id")
+		label, _ := getAttribute(input, "aria-label")
+		labelledBy, _ := getAttribute(input, "aria-labelledby")
+		title, _ := getAttribute(input, "title")
+
+		var wrapped bool = false
+		for parent := input.Parent; parent != nil; parent = parent.Parent {
+			if parent.Type == html.ElementNode && parent.Data == "label" {
+				wrapped = true
+				break
+			}
+		}
+
+		if !wrapped && !labelled[id] && label == "" && labelledBy == "" && title == "" {
+			findings = append(findings, "Form control without label: "+describeElement(input))
+		}
+	}
+
+	// Heading structure
+	var h1Count int = 0
+	var previousLevel int = 0
+	for _, heading := range headings {
+		level := int(heading.Data[1] - '0')
+		if level == 1 {
+			h1Count++
+		}
+		if previousLevel != 0 && level > previousLevel+1 {
+			findings = append(findings, fmt.Sprintf(
+				"Heading level skipped: h%d followed by h%d \"%s\"", previousLevel, level, nodeText(heading),
+			))
+		}
+		if nodeText(heading) == "" {
+			findings = append(findings, "Empty heading: "+describeElement(heading))
+		}
+		previousLevel = level
+	}
+	if len(headings) > 0 && h1Count == 0 {
+		findings = append(findings, "Document has no h1 heading")
+	}
+	if h1Count > 1 {
+		findings = append(findings, fmt.Sprintf("Document has %d h1 headings", h1Count))
+	}
+
+	return findings, nil
+}
+
+// Write accessibility report for the page into output, with contrast hints from rendering it if there are any.
+// Returns name of the written file
+func writeAccessibilityReport(pageBody []byte, from *url.URL, baseName string, out output, contrast []contrastHint) (string, error) {
+	findings, err := auditAccessibility(pageBody)
+	if err != nil {
+		return "", err
+	}
+	for _, hint := range contrast {
+		findings = append(findings, hint.String())
+	}
+
+	var reportName string = baseName + ".a11y.txt"
+	reportFile, err := out.Create(reportName)
+	if err != nil {
+		return "", err
+	}
+	defer reportFile.Close()
+
+	fmt.Fprintf(reportFile, "Accessibility report for %s\n\n", from.String())
+	if len(findings) == 0 {
+		fmt.Fprintf(reportFile, "No problems found\n")
+		return reportName, nil
+	}
+
+	for _, finding := range findings {
+		fmt.Fprintf(reportFile, "- %s\n", finding)
+	}
+	fmt.Fprintf(reportFile, "\n%d problem(s) found\n", len(findings))
+
+	return reportName, nil
+}
@@ -0,0 +1,206 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// What kind of page content the file is
+type AssetKind int
+
+const (
+	// Anything else: downloads, data files and files of unknown kind
+	AssetOther AssetKind = iota
+	// CSS, with the files it imports and refers to saved too
+	AssetStylesheet
+	// JavaScript, with the workers and modules it loads, or a WebAssembly module
+	AssetScript
+	// Web font
+	AssetFont
+	// Picture or icon
+	AssetImage
+	// Audio or video
+	AssetMedia
+	// Page of its own, like an iframe's or a PDF
+	AssetDocument
+)
+
+var assetKindsByExtension map[string]AssetKind = map[string]AssetKind{
+	".css":   AssetStylesheet,
+	".scss":  AssetStylesheet,
+	".js":    AssetScript,
+	".mjs":   AssetScript,
+	".wasm":  AssetScript,
+	".woff":  AssetFont,
+	".woff2": AssetFont,
+	".ttf":   AssetFont,
+	".otf":   AssetFont,
+	".eot":   AssetFont,
+	".png":   AssetImage,
+	".jpg":   AssetImage,
+	".jpeg":  AssetImage,
+	".gif":   AssetImage,
+	".webp":  AssetImage,
+	".avif":  AssetImage,
+	".svg":   AssetImage,
+	".ico":   AssetImage,
+	".bmp":   AssetImage,
+	".mp4":   AssetMedia,
+	".webm":  AssetMedia,
+	".ogg":   AssetMedia,
+	".ogv":   AssetMedia,
+	".mp3":   AssetMedia,
+	".wav":   AssetMedia,
+	".flac":  AssetMedia,
+	".m4a":   AssetMedia,
+	".mov":   AssetMedia,
+	".html":  AssetDocument,
+	".htm":   AssetDocument,
+	".xhtml": AssetDocument,
+	".pdf":   AssetDocument,
+}
+
+// Guess asset's kind judging by its path extension
+func ClassifyLink(link *url.URL) AssetKind {
+	kind, ok := assetKindsByExtension[strings.ToLower(path.Ext(link.Path))]
+	if !ok {
+		return AssetOther
+	}
+
+	return kind
+}
+
+// Whether the file at link is a WebAssembly module: a script, but a binary one with nothing to rewrite in it
+func isWebAssembly(link *url.URL, contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/wasm" || strings.ToLower(path.Ext(link.Path)) == ".wasm"
+}
+
+// Asset's kind judging by its media type, AssetOther if it does not tell
+func kindOfMediaType(mediaType string) AssetKind {
+	switch mediaType {
+	case "text/css":
+		return AssetStylesheet
+	case "text/javascript", "application/javascript", "application/x-javascript", "application/ecmascript", "application/wasm":
+		return AssetScript
+	case "text/html", "application/xhtml+xml", "application/pdf":
+		return AssetDocument
+	case "application/font-woff", "application/font-woff2", "application/vnd.ms-fontobject", "application/x-font-ttf":
+		return AssetFont
+	}
+
+	switch strings.SplitN(mediaType, "/", 2)[0] {
+	case "image":
+		return AssetImage
+	case "font":
+		return AssetFont
+	case "audio", "video":
+		return AssetMedia
+	}
+
+	return AssetOther
+}
+
+// Page file as gospa sees it
+type Asset struct {
+	URL  *url.URL
+	Kind AssetKind
+	// Media type from Content-Type if it is telling, guessed from extension otherwise. Empty if unknown
+	MIMEType string
+}
+
+// Classify the file at link. contentType is what the server responded with and may be empty.
+// Kind is judged by extension first, since servers mislabel files more often than sites misname them
+func Classify(link *url.URL, contentType string) Asset {
+	var asset Asset = Asset{
+		URL:      link,
+		Kind:     ClassifyLink(link),
+		MIMEType: mime.TypeByExtension(strings.ToLower(path.Ext(link.Path))),
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType != "application/octet-stream" && mediaType != "text/plain" {
+		asset.MIMEType = mediaType
+		if asset.Kind == AssetOther {
+			asset.Kind = kindOfMediaType(mediaType)
+		}
+	}
+
+	if asset.MIMEType != "" {
+		asset.MIMEType, _, _ = mime.ParseMediaType(asset.MIMEType)
+	}
+
+	return asset
+}
+
+// Name of the kind, as used in fetch priorities
+func (kind AssetKind) String() string {
+	for name, namedKind := range assetKindNames {
+		if namedKind == kind {
+			return name
+		}
+	}
+
+	return "other"
+}
+
+var assetKindNames map[string]AssetKind = map[string]AssetKind{
+	"css":      AssetStylesheet,
+	"script":   AssetScript,
+	"font":     AssetFont,
+	"image":    AssetImage,
+	"media":    AssetMedia,
+	"document": AssetDocument,
+	"other":    AssetOther,
+}
+
+// Render-critical assets first, heavy media last
+const DefaultPriority string = "css,font,script,image,document,other,media"
+
+// Parse comma-separated list of asset kinds into fetch priorities (lower is fetched earlier).
+// Kinds that are not mentioned go after the mentioned ones
+func parsePriority(priority string) (map[AssetKind]int, error) {
+	var priorities map[AssetKind]int = make(map[AssetKind]int)
+
+	for index, name := range strings.Split(priority, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		kind, ok := assetKindNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown asset kind \"%s\"", name)
+		}
+		if _, seen := priorities[kind]; !seen {
+			priorities[kind] = index
+		}
+	}
+
+	for _, kind := range assetKindNames {
+		if _, ok := priorities[kind]; !ok {
+			priorities[kind] = len(assetKindNames)
+		}
+	}
+
+	return priorities, nil
+}
+
+// Order links by priority of their kinds, keeping document order within the same kind
+func sortByPriority(links []*url.URL, priorities map[AssetKind]int) {
+	sort.SliceStable(links, func(i, j int) bool {
+		return priorities[ClassifyLink(links[i])] < priorities[ClassifyLink(links[j])]
+	})
+}
@@ -0,0 +1,100 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// How long a single page may take in the headless browser
+const browserTimeout time.Duration = time.Minute
+
+// Headless browser shared by all pages, which is started on first use
+type headlessBrowser struct {
+	// executable, found automatically if empty
+	path string
+	// proxy to browse through, if any
+	proxy string
+	// user agent to browse with, Chrome's own if empty
+	userAgent string
+	// ignore certificate errors
+	insecure bool
+
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+	err    error
+}
+
+// Context of the running browser
+func (browser *headlessBrowser) start() (context.Context, error) {
+	browser.once.Do(func() {
+		options := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+		if browser.path != "" {
+			options = append(options, chromedp.ExecPath(browser.path))
+		}
+		if browser.userAgent != "" {
+			options = append(options, chromedp.UserAgent(browser.userAgent))
+		}
+		if browser.insecure {
+			options = append(options, chromedp.Flag("ignore-certificate-errors", true))
+		}
+		if browser.proxy != "" {
+			// Chrome always resolves names through a SOCKS proxy and does not know socks5h
+			options = append(options, chromedp.ProxyServer(strings.Replace(browser.proxy, "socks5h://", "socks5://", 1)))
+		}
+
+		allocatorCtx, cancelAllocator := chromedp.NewExecAllocator(context.Background(), options...)
+		ctx, cancel := chromedp.NewContext(allocatorCtx)
+		browser.ctx = ctx
+		browser.cancel = func() {
+			cancel()
+			cancelAllocator()
+		}
+
+		// running nothing launches the browser, so that tabs open in it instead of new browsers
+		browser.err = chromedp.Run(ctx)
+	})
+
+	return browser.ctx, browser.err
+}
+
+// Open a new tab, which is closed when parent is done or the returned function is called
+func (browser *headlessBrowser) newTab(parent context.Context) (context.Context, context.CancelFunc, error) {
+	browserCtx, err := browser.start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tabCtx, cancelTab := chromedp.NewContext(browserCtx)
+	ctx, cancelTimeout := context.WithTimeout(tabCtx, browserTimeout)
+	stop := context.AfterFunc(parent, cancelTab)
+
+	return ctx, func() {
+		stop()
+		cancelTimeout()
+		cancelTab()
+	}, nil
+}
+
+// Shut the browser down if it has been started
+func (browser *headlessBrowser) close() {
+	if browser.cancel != nil {
+		browser.cancel()
+	}
+}
@@ -0,0 +1,142 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line of a CDXJ index of a WARC file: where replay tools such as pywb find the record of a URL
+type cdxjEntry struct {
+	// SURT form of the URL, which lines are sorted by
+	key string
+	// of the record, as yyyyMMddhhmmss
+	timestamp string
+	URL       string `json:"url"`
+	MIME      string `json:"mime"`
+	Status    string `json:"status,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	// of the record's gzip member, compressed
+	Length   string `json:"length"`
+	Offset   string `json:"offset"`
+	Filename string `json:"filename"`
+}
+
+// Sort-friendly URL key: scheme dropped, host labels reversed, "www" left out, lowercased
+// and query parameters in order, e.g. "com,example)/path?a=1&b=2" for http://www.example.com/Path?b=2&a=1
+func surtKey(link string) string {
+	parsedURL, err := url.Parse(link)
+	if err != nil || parsedURL.Host == "" {
+		return strings.ToLower(link)
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+	var labels []string = strings.Split(host, ".")
+	if net.ParseIP(host) == nil {
+		for left, right := 0, len(labels)-1; left < right; left, right = left+1, right-1 {
+			labels[left], labels[right] = labels[right], labels[left]
+		}
+	} else {
+		labels = []string{host}
+	}
+
+	var key strings.Builder
+	key.WriteString(strings.Join(labels, ","))
+	port := parsedURL.Port()
+	if port != "" && !(parsedURL.Scheme == "http" && port == "80") && !(parsedURL.Scheme == "https" && port == "443") {
+		key.WriteString(":" + port)
+	}
+	key.WriteString(")")
+
+	path := parsedURL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key.WriteString(strings.ToLower(path))
+
+	if parsedURL.RawQuery != "" {
+		var parameters []string = strings.Split(parsedURL.RawQuery, "&")
+		sort.Strings(parameters)
+		key.WriteString("?" + strings.ToLower(strings.Join(parameters, "&")))
+	}
+
+	return key.String()
+}
+
+// Index entry of a record of targetURI written at date, whose payload has given media type and digest
+func newCDXJEntry(targetURI string, date string, contentType string, status string, payloadDigest string) cdxjEntry {
+	var timestamp string = date
+	if parsed, err := time.Parse(time.RFC3339, date); err == nil {
+		timestamp = parsed.UTC().Format("20060102150405")
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "unk"
+	}
+
+	return cdxjEntry{
+		key:       surtKey(targetURI),
+		timestamp: timestamp,
+		URL:       targetURI,
+		MIME:      mediaType,
+		Status:    status,
+		Digest:    strings.TrimPrefix(payloadDigest, "sha1:"),
+	}
+}
+
+// CDXJ index of the records of the WARC file named filename, sorted for lookups
+func buildCDXJ(entries []cdxjEntry, filename string) []byte {
+	sort.SliceStable(entries, func(i int, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].timestamp < entries[j].timestamp
+	})
+
+	var index bytes.Buffer
+	for _, entry := range entries {
+		entry.Filename = filename
+		fields, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+
+		index.WriteString(entry.key + " " + entry.timestamp + " ")
+		index.Write(fields)
+		index.WriteString("\n")
+	}
+
+	return index.Bytes()
+}
+
+// Status code of an HTTP status line, such as "200" of "HTTP/1.1 200 OK"
+func statusLineCode(statusLine string) string {
+	fields := strings.Fields(statusLine)
+	if len(fields) < 2 {
+		return ""
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return ""
+	}
+
+	return fields[1]
+}
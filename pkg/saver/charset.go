@@ -0,0 +1,71 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"net/http"
+	"regexp"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Charset declaration of a <meta charset> or <meta http-equiv="Content-Type"> tag, value in the second group
+var metaCharsetRegexp *regexp.Regexp = regexp.MustCompile(`(?i)(<meta\b[^>]*?charset\s*=\s*["']?)([^"'\s;/>]+)`)
+
+// Tags a <meta charset> can go right after when the page has no <head> tag
+var htmlStartRegexp *regexp.Regexp = regexp.MustCompile(`(?i)^\s*(<!doctype[^>]*>\s*)?(<html(\s[^>]*)?>)?`)
+
+// Whether body with this Content-Type is a web page, going by its contents if there is no Content-Type
+func isHTMLPage(body []byte, contentType string) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	return isHTMLContentType(contentType)
+}
+
+// Convert a page into UTF-8 from the charset its byte order mark, Content-Type or <meta> declares,
+// or the one it looks like if nothing does, and make the page declare UTF-8 so that it opens right from disk
+func pageToUTF8(pageBody []byte, contentType string) []byte {
+	encoding, name, _ := charset.DetermineEncoding(pageBody, contentType)
+	if name != "utf-8" {
+		decoded, err := encoding.NewDecoder().Bytes(pageBody)
+		if err != nil {
+			return pageBody
+		}
+		pageBody = decoded
+	}
+
+	return declareUTF8(pageBody)
+}
+
+// Make the page's charset declarations say UTF-8, adding one if there are none
+func declareUTF8(pageBody []byte) []byte {
+	if metaCharsetRegexp.Match(pageBody) {
+		return metaCharsetRegexp.ReplaceAll(pageBody, []byte("${1}utf-8"))
+	}
+
+	const declaration string = `<meta charset="utf-8">`
+	location := headTagRegexp.FindIndex(pageBody)
+	if location == nil {
+		// after the doctype, so that the page is not thrown into quirks mode
+		location = htmlStartRegexp.FindIndex(pageBody)
+	}
+
+	var declared []byte = make([]byte, 0, len(pageBody)+len(declaration))
+	declared = append(declared, pageBody[:location[1]]...)
+	declared = append(declared, declaration...)
+	declared = append(declared, pageBody[location[1]:]...)
+
+	return declared
+}
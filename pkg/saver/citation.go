@@ -0,0 +1,230 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Everything needed to cite an archived page
+type citation struct {
+	Metadata   PageMetadata
+	URL        *url.URL
+	Accessed   time.Time
+	ArchivedAt string
+}
+
+func newCitation(pageBody []byte, from *url.URL, archivedAt string) *citation {
+	metadata := extractMetadata(pageBody)
+	if metadata.SiteName == "" {
+		metadata.SiteName = from.Host
+	}
+	if metadata.Title == "" {
+		metadata.Title = from.String()
+	}
+
+	return &citation{
+		Metadata:   metadata,
+		URL:        from,
+		Accessed:   time.Now(),
+		ArchivedAt: archivedAt,
+	}
+}
+
+var nonKeyCharRegexp *regexp.Regexp = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// Escape characters that have special meaning in BibTeX
+func escapeBibTeX(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\textbackslash{}`,
+		"{", `\{`,
+		"}", `\}`,
+		"&", `\&`,
+		"%", `\%`,
+		"$", `\$`,
+		"#", `\#`,
+		"_", `\_`,
+	)
+
+	return replacer.Replace(value)
+}
+
+// Render citation as a BibTeX @online entry
+func (c *citation) bibTeX() []byte {
+	key := strings.Trim(nonKeyCharRegexp.ReplaceAllString(c.URL.Host+"_"+c.Accessed.Format("2006"), "_"), "_")
+
+	var fields [][2]string = [][2]string{
+		{"title", c.Metadata.Title},
+		{"author", c.Metadata.Author},
+		{"organization", c.Metadata.SiteName},
+		{"url", c.URL.String()},
+		{"urldate", c.Accessed.Format("2006-01-02")},
+		{"date", c.Metadata.Published},
+		{"note", "Archived copy: " + c.ArchivedAt},
+	}
+
+	var entry strings.Builder
+	fmt.Fprintf(&entry, "@online{%s,\n", key)
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+
+		value := field[1]
+		if field[0] != "url" {
+			value = escapeBibTeX(value)
+		}
+		fmt.Fprintf(&entry, "  %s = {%s},\n", field[0], value)
+	}
+	entry.WriteString("}\n")
+
+	return []byte(entry.String())
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts,omitempty"`
+	Raw       string  `json:"raw,omitempty"`
+}
+
+type cslName struct {
+	Literal string `json:"literal"`
+}
+
+type cslItem struct {
+	ID              string    `json:"id"`
+	Type            string    `json:"type"`
+	Title           string    `json:"title"`
+	Author          []cslName `json:"author,omitempty"`
+	ContainerTitle  string    `json:"container-title,omitempty"`
+	URL             string    `json:"URL"`
+	Accessed        cslDate   `json:"accessed"`
+	Issued          *cslDate  `json:"issued,omitempty"`
+	Archive         string    `json:"archive,omitempty"`
+	ArchiveLocation string    `json:"archive_location,omitempty"`
+	Abstract        string    `json:"abstract,omitempty"`
+	Language        string    `json:"language,omitempty"`
+}
+
+// Render citation as CSL-JSON
+func (c *citation) cslJSON() ([]byte, error) {
+	item := cslItem{
+		ID:              c.URL.String(),
+		Type:            "webpage",
+		Title:           c.Metadata.Title,
+		ContainerTitle:  c.Metadata.SiteName,
+		URL:             c.URL.String(),
+		Accessed:        cslDate{DateParts: [][]int{{c.Accessed.Year(), int(c.Accessed.Month()), c.Accessed.Day()}}},
+		Archive:         "gospa",
+		ArchiveLocation: c.ArchivedAt,
+		Abstract:        c.Metadata.Description,
+		Language:        c.Metadata.Language,
+	}
+	if c.Metadata.Author != "" {
+		item.Author = []cslName{{Literal: c.Metadata.Author}}
+	}
+	if c.Metadata.Published != "" {
+		item.Issued = &cslDate{Raw: c.Metadata.Published}
+	}
+
+	return json.MarshalIndent([]cslItem{item}, "", "  ")
+}
+
+// RIS field value on one line, as RIS fields cannot span lines
+func risValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// Render citation as a RIS web page record with the snapshot at snapshotPath, relative to the record,
+// attached the way Zotero exports and imports attachments
+func (c *citation) ris(snapshotPath string) []byte {
+	var published string = c.Metadata.Published
+	if date, err := time.Parse(time.RFC3339, published); err == nil {
+		published = date.Format("2006/01/02")
+	} else if date, err := time.Parse("2006-01-02", published); err == nil {
+		published = date.Format("2006/01/02")
+	}
+
+	var fields [][2]string = [][2]string{
+		{"TY", "ELEC"},
+		{"TI", c.Metadata.Title},
+		{"AU", c.Metadata.Author},
+		{"T2", c.Metadata.SiteName},
+		{"AB", c.Metadata.Description},
+		{"DA", published},
+		{"LA", c.Metadata.Language},
+		{"UR", c.URL.String()},
+		{"Y2", c.Accessed.Format("2006/01/02/15:04:05")},
+		{"L1", snapshotPath},
+	}
+
+	var record strings.Builder
+	for _, field := range fields {
+		if value := risValue(field[1]); value != "" {
+			fmt.Fprintf(&record, "%s  - %s\r\n", field[0], value)
+		}
+	}
+	record.WriteString("ER  - \r\n")
+
+	return []byte(record.String())
+}
+
+// Write a RIS record of the page with its single-file snapshot at snapshotPath attached, which Zotero
+// imports as a web page item with the snapshot stored in it. Returns name of the written file
+func writeZoteroRecord(pageBody []byte, from *url.URL, baseName string, snapshotPath string, out output) (string, error) {
+	c := newCitation(pageBody, from, snapshotPath)
+
+	recordFile, err := out.Create(baseName + ".ris")
+	if err != nil {
+		return "", err
+	}
+	defer recordFile.Close()
+
+	_, err = recordFile.Write(c.ris(snapshotPath))
+	return baseName + ".ris", err
+}
+
+// Write citation in requested format ("bibtex" or "csl") into output. Returns name of the written file
+func writeCitation(format string, pageBody []byte, from *url.URL, baseName string, archivedAt string, out output) (string, error) {
+	c := newCitation(pageBody, from, archivedAt)
+
+	var contents []byte
+	var extension string
+	switch strings.ToLower(format) {
+	case "bibtex", "bib":
+		contents = c.bibTeX()
+		extension = ".bib"
+	case "csl", "csl-json":
+		var err error
+		contents, err = c.cslJSON()
+		if err != nil {
+			return "", err
+		}
+		extension = ".csl.json"
+	default:
+		return "", fmt.Errorf("unknown citation format \"%s\"", format)
+	}
+
+	citationFile, err := out.Create(baseName + extension)
+	if err != nil {
+		return "", err
+	}
+	defer citationFile.Close()
+
+	_, err = citationFile.Write(contents)
+	return baseName + extension, err
+}
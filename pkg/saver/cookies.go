@@ -0,0 +1,274 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of cookies.txt lines holding HttpOnly cookies
+const httpOnlyPrefix string = "#HttpOnly_"
+
+// A single stored cookie
+type jarCookie struct {
+	// without the leading dot
+	domain string
+	// sent to domain only, not its subdomains
+	hostOnly bool
+	path     string
+	secure   bool
+	httpOnly bool
+	// zero for session cookies
+	expires time.Time
+	name    string
+	value   string
+}
+
+func (cookie *jarCookie) expired(now time.Time) bool {
+	return !cookie.expires.IsZero() && !cookie.expires.After(now)
+}
+
+// Whether the cookie should be sent with a request for link
+func (cookie *jarCookie) matches(link *url.URL) bool {
+	host := strings.ToLower(link.Hostname())
+	if host != cookie.domain && (cookie.hostOnly || !strings.HasSuffix(host, "."+cookie.domain)) {
+		return false
+	}
+	if cookie.secure && link.Scheme != "https" {
+		return false
+	}
+
+	requestPath := link.EscapedPath()
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	if requestPath == cookie.path {
+		return true
+	}
+
+	return strings.HasPrefix(requestPath, cookie.path) &&
+		(strings.HasSuffix(cookie.path, "/") || requestPath[len(cookie.path)] == '/')
+}
+
+// Cookie jar that, unlike net/http/cookiejar, can be read from and written to a Netscape cookies.txt
+type cookieJar struct {
+	mutex   sync.Mutex
+	cookies []*jarCookie
+}
+
+// Put the cookie into the jar, replacing the one with the same domain, path and name
+func (jar *cookieJar) store(cookie *jarCookie) {
+	for index, stored := range jar.cookies {
+		if stored.domain == cookie.domain && stored.path == cookie.path && stored.name == cookie.name {
+			jar.cookies[index] = cookie
+			return
+		}
+	}
+
+	jar.cookies = append(jar.cookies, cookie)
+}
+
+// Remove the cookie with the same domain, path and name
+func (jar *cookieJar) remove(cookie *jarCookie) {
+	for index, stored := range jar.cookies {
+		if stored.domain == cookie.domain && stored.path == cookie.path && stored.name == cookie.name {
+			jar.cookies = append(jar.cookies[:index], jar.cookies[index+1:]...)
+			return
+		}
+	}
+}
+
+func (jar *cookieJar) SetCookies(link *url.URL, cookies []*http.Cookie) {
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+
+	host := strings.ToLower(link.Hostname())
+	now := time.Now()
+	for _, cookie := range cookies {
+		stored := &jarCookie{
+			domain:   host,
+			hostOnly: true,
+			path:     cookie.Path,
+			secure:   cookie.Secure,
+			httpOnly: cookie.HttpOnly,
+			name:     cookie.Name,
+			value:    cookie.Value,
+		}
+
+		if cookie.Domain != "" {
+			domain := strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				// not the server's to set
+				continue
+			}
+			stored.domain = domain
+			stored.hostOnly = false
+		}
+
+		if !strings.HasPrefix(stored.path, "/") {
+			// default path: directory of the request
+			stored.path = "/"
+			if index := strings.LastIndex(link.EscapedPath(), "/"); index > 0 {
+				stored.path = link.EscapedPath()[:index]
+			}
+		}
+
+		switch {
+		case cookie.MaxAge < 0:
+			stored.expires = now
+		case cookie.MaxAge > 0:
+			stored.expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			stored.expires = cookie.Expires
+		}
+
+		if stored.expired(now) {
+			jar.remove(stored)
+		} else {
+			jar.store(stored)
+		}
+	}
+}
+
+func (jar *cookieJar) Cookies(link *url.URL) []*http.Cookie {
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+
+	now := time.Now()
+	var matching []*jarCookie
+	for _, cookie := range jar.cookies {
+		if !cookie.expired(now) && cookie.matches(link) {
+			matching = append(matching, cookie)
+		}
+	}
+
+	// more specific paths first
+	sort.SliceStable(matching, func(i, j int) bool {
+		return len(matching[i].path) > len(matching[j].path)
+	})
+
+	var cookies []*http.Cookie
+	for _, cookie := range matching {
+		cookies = append(cookies, &http.Cookie{Name: cookie.name, Value: cookie.value})
+	}
+
+	return cookies
+}
+
+// Read cookies from a Netscape cookies.txt, as exported by browser extensions, curl and wget
+func loadCookieJar(path string) (*cookieJar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var jar *cookieJar = &cookieJar{}
+	scanner := bufio.NewScanner(file)
+	var lineNumber int = 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		var httpOnly bool = false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields", lineNumber)
+		}
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiration time \"%s\"", lineNumber, fields[4])
+		}
+
+		cookie := &jarCookie{
+			domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			hostOnly: strings.ToUpper(fields[1]) != "TRUE",
+			path:     fields[2],
+			secure:   strings.ToUpper(fields[3]) == "TRUE",
+			httpOnly: httpOnly,
+			name:     fields[5],
+			value:    strings.Join(fields[6:], "\t"),
+		}
+		if expiry > 0 {
+			cookie.expires = time.Unix(expiry, 0)
+		}
+		if !cookie.expired(time.Now()) {
+			jar.store(cookie)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return jar, nil
+}
+
+// Write every cookie that has not expired into a Netscape cookies.txt
+func (jar *cookieJar) write(path string) error {
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+
+	var contents strings.Builder
+	contents.WriteString("# Netscape HTTP Cookie File\n# Written by gospa\n\n")
+
+	now := time.Now()
+	for _, cookie := range jar.cookies {
+		if cookie.expired(now) {
+			continue
+		}
+
+		domain := cookie.domain
+		if !cookie.hostOnly {
+			domain = "." + domain
+		}
+		if cookie.httpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+
+		var expiry int64 = 0
+		if !cookie.expires.IsZero() {
+			expiry = cookie.expires.Unix()
+		}
+
+		fmt.Fprintf(&contents, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain,
+			strings.ToUpper(strconv.FormatBool(!cookie.hostOnly)),
+			cookie.path,
+			strings.ToUpper(strconv.FormatBool(cookie.secure)),
+			expiry,
+			cookie.name,
+			cookie.value,
+		)
+	}
+
+	// cookies are credentials
+	return os.WriteFile(path, []byte(contents.String()), 0600)
+}
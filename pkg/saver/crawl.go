@@ -0,0 +1,402 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Page waiting in the crawl frontier
+type crawlTarget struct {
+	url   *url.URL
+	depth int
+	// name the page is saved under, without extension
+	baseName string
+}
+
+// Identity of a page for the visited set: the URL without its fragment
+func crawlKey(link *url.URL) string {
+	var key url.URL = *link
+	key.Fragment = ""
+	key.RawFragment = ""
+
+	return key.String()
+}
+
+// Whether the link leads to a page the crawler should follow
+func isCrawlable(link *url.URL, start *url.URL, spanHosts bool) bool {
+	if !isFetchableScheme(link.Scheme) {
+		return false
+	}
+
+	if !spanHosts && link.Host != start.Host {
+		return false
+	}
+
+	// images, scripts and the like are not pages
+	kind := ClassifyLink(link)
+	return kind == AssetOther || kind == AssetDocument
+}
+
+// Whether response's Content-Type says it is a web page
+func isHTMLContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// Index of the frontier's page to fetch next: of the shallowest ones, the first from the host the fewest
+// pages have been fetched from, so that one big host does not hold up the others
+func nextCrawlTarget(frontier []crawlTarget, fetchedFrom map[string]int) int {
+	var next int = 0
+	for index := 1; index < len(frontier) && frontier[index].depth == frontier[0].depth; index++ {
+		if fetchedFrom[frontier[index].url.Host] < fetchedFrom[frontier[next].url.Host] {
+			next = index
+		}
+	}
+
+	return next
+}
+
+// Fetched page waiting to be saved until the pages it links to are settled, so that
+// its links are only pointed at local copies that really exist
+type pendingPage struct {
+	target  crawlTarget
+	pageURL *url.URL
+	body    []byte
+	// crawl keys of the pages of the crawl it links to
+	links []string
+	// place in the order pages were discovered in
+	order int
+}
+
+// Save start page and every page reachable from it within maxDepth links found by pageLinks, breadth-first,
+// taking turns between hosts within each level. Fetching stops after MaxPages pages or MaxTime, whichever comes first.
+// Links between saved pages are rewritten to point at local copies: a page is saved once every page it links to
+// has been fetched and saved or given up on, pages linking to one another are saved last, deepest first.
+// Pages waiting are held in memory up to MemoryThreshold bytes together. Past it, the longest waiting ones
+// are saved right away, with links to pages that have not been fetched yet left pointing online.
+// Pages are saved as coming from where their redirects ended, and the start page is named after it too.
+// Reports come in the order pages were discovered in, the start page first
+func (session *session) crawl(start *url.URL, maxDepth int, spanHosts bool, pageLinks func(pageBody []byte) []*url.URL, save func(pageURL *url.URL, baseName string, body []byte, start bool) (*PageReport, error)) []*PageReport {
+	var frontier []crawlTarget = []crawlTarget{{url: start, depth: 0, baseName: pageBaseName(start)}}
+	var visited map[string]string = map[string]string{
+		crawlKey(start): pageBaseName(start) + session.pageFileExtension(),
+	}
+	var usedNames map[string]bool = map[string]bool{
+		pageBaseName(start): true,
+	}
+	// pages robots.txt keeps gospa away from
+	var disallowed map[string]bool = make(map[string]bool)
+	// where the start page came from, which decides what other hosts are
+	var scope *url.URL = start
+
+	// queued pages that have not been fetched yet
+	var unfetched map[string]bool = map[string]bool{crawlKey(start): true}
+	// pages fetched as web pages and not failed to save -> their local file, the only ones links are pointed at
+	var localPages map[string]string = make(map[string]string)
+	var pending []*pendingPage
+	var pendingKeys map[string]bool = make(map[string]bool)
+	// bytes of pending pages' bodies
+	var pendingSize int64 = 0
+	var discovered int = 0
+	// host -> pages fetched from it
+	var fetchedFrom map[string]int = make(map[string]int)
+	started := time.Now()
+
+	type orderedReport struct {
+		order  int
+		report *PageReport
+	}
+	var reports []orderedReport
+
+	var saveOne = func(page *pendingPage) {
+		keys := []string{crawlKey(page.target.url), crawlKey(page.pageURL)}
+		for _, key := range keys {
+			delete(pendingKeys, key)
+		}
+		pendingSize -= int64(len(page.body))
+
+		body := page.body
+		if maxDepth > 0 {
+			body = rewriteNavigationLinks(body, page.pageURL, localPages)
+		}
+
+		report, err := save(page.pageURL, page.target.baseName, body, page.order == 0)
+		if err != nil {
+			session.warn("Failed to save page at %s: %s", page.pageURL.String(), err)
+			for _, key := range keys {
+				delete(localPages, key)
+			}
+			return
+		}
+		if crawlKey(page.pageURL) != crawlKey(page.target.url) {
+			report.RequestedURL = page.target.url.String()
+		}
+		reports = append(reports, orderedReport{order: page.order, report: report})
+	}
+
+	// whether every page the page links to is saved or given up on
+	var settled = func(page *pendingPage) bool {
+		for _, key := range page.links {
+			if unfetched[key] {
+				return false
+			}
+			if pendingKeys[key] && key != crawlKey(page.target.url) && key != crawlKey(page.pageURL) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// save pending pages that have become settled, until saving them settles no more
+	var saveSettled = func() {
+		for progress := true; progress; {
+			progress = false
+			var stillPending []*pendingPage
+			for _, page := range pending {
+				if settled(page) {
+					saveOne(page)
+					progress = true
+				} else {
+					stillPending = append(stillPending, page)
+				}
+			}
+			pending = stillPending
+		}
+	}
+
+	for len(frontier) > 0 && session.ctx.Err() == nil {
+		if session.options.MaxPages > 0 && discovered >= session.options.MaxPages {
+			session.warn("Page limit of %d reached, %d page(s) left unsaved", session.options.MaxPages, len(frontier))
+			break
+		}
+		if session.options.MaxTime > 0 && time.Since(started) >= session.options.MaxTime {
+			session.warn("Time limit of %s reached, %d page(s) left unsaved", session.options.MaxTime, len(frontier))
+			break
+		}
+
+		index := nextCrawlTarget(frontier, fetchedFrom)
+		target := frontier[index]
+		frontier = append(frontier[:index], frontier[index+1:]...)
+		delete(unfetched, crawlKey(target.url))
+		fetchedFrom[target.url.Host]++
+
+		page, ok := session.crawlPage(target, &scope, maxDepth, visited, usedNames, localPages)
+		if ok {
+			page.order = discovered
+			discovered++
+
+			if target.depth < maxDepth {
+				for _, link := range pageLinks(page.body) {
+					absoluteLink := page.pageURL.ResolveReference(link)
+					if !isCrawlable(absoluteLink, scope, spanHosts) {
+						continue
+					}
+
+					key := crawlKey(absoluteLink)
+					if _, seen := visited[key]; seen || disallowed[key] {
+						continue
+					}
+					if !session.robotsAllow(absoluteLink) {
+						session.warn("Not saving %s: disallowed by robots.txt", absoluteLink.String())
+						disallowed[key] = true
+						continue
+					}
+					// pages differing only in query, like ?page=2, would get the same name
+					baseName := pageBaseName(absoluteLink)
+					for number := 2; usedNames[baseName]; number++ {
+						baseName = fmt.Sprintf("%s_%d", pageBaseName(absoluteLink), number)
+					}
+					usedNames[baseName] = true
+
+					visited[key] = baseName + session.pageFileExtension()
+					unfetched[key] = true
+					frontier = append(frontier, crawlTarget{url: absoluteLink, depth: target.depth + 1, baseName: baseName})
+				}
+			}
+
+			if maxDepth > 0 {
+				for _, link := range pageLinks(page.body) {
+					key := crawlKey(page.pageURL.ResolveReference(link))
+					if _, ok := visited[key]; ok {
+						page.links = append(page.links, key)
+					}
+				}
+			}
+
+			pending = append(pending, page)
+			pendingKeys[crawlKey(target.url)] = true
+			pendingKeys[crawlKey(page.pageURL)] = true
+			pendingSize += int64(len(page.body))
+		}
+
+		saveSettled()
+
+		if pendingSize > session.options.MemoryThreshold {
+			for pendingSize > session.options.MemoryThreshold && len(pending) > 0 {
+				page := pending[0]
+				pending = pending[1:]
+				saveOne(page)
+			}
+			// pages that were waiting for them
+			saveSettled()
+		}
+	}
+
+	// what is left links to one another, or to pages never fetched if saving has been cancelled or stopped by a limit
+	sort.SliceStable(pending, func(i int, j int) bool {
+		return pending[i].target.depth > pending[j].target.depth
+	})
+	for _, page := range pending {
+		saveOne(page)
+	}
+
+	sort.Slice(reports, func(i int, j int) bool {
+		return reports[i].order < reports[j].order
+	})
+	var pageReports []*PageReport
+	for _, ordered := range reports {
+		pageReports = append(pageReports, ordered.report)
+	}
+
+	return pageReports
+}
+
+// Fetch a crawl target and prepare it for saving. ok is false if it is not to be saved:
+// fetching it failed, or it is not a web page although a link led to it
+func (session *session) crawlPage(target crawlTarget, scope **url.URL, maxDepth int, visited map[string]string, usedNames map[string]bool, localPages map[string]string) (page *pendingPage, ok bool) {
+	err := session.logInToSite(target.url)
+	if err != nil {
+		session.warn("Not saving %s: failed to log in to its site: %s", target.url.String(), err)
+		return nil, false
+	}
+
+	response, err := session.fetch(target.url.String())
+	if err != nil {
+		session.warn("Failed to GET %s: %s", target.url.String(), err)
+		return nil, false
+	}
+
+	pageURL := response.Request.URL
+	if crawlKey(pageURL) != crawlKey(target.url) {
+		if target.depth == 0 {
+			// nothing links to the start page yet, so its name can still change
+			delete(usedNames, target.baseName)
+			target.baseName = pageBaseName(pageURL)
+			usedNames[target.baseName] = true
+			visited[crawlKey(target.url)] = target.baseName + session.pageFileExtension()
+			*scope = pageURL
+		}
+		if _, seen := visited[crawlKey(pageURL)]; !seen {
+			visited[crawlKey(pageURL)] = visited[crawlKey(target.url)]
+		}
+	}
+
+	if target.depth > 0 && response.StatusCode >= 400 {
+		session.warn("Not saving %s: server responded with %s", target.url.String(), response.Status)
+		response.Body.Close()
+		return nil, false
+	}
+	if target.depth > 0 && !isHTMLContentType(response.Header.Get("Content-Type")) {
+		// not a page, links to it keep pointing to the original
+		response.Body.Close()
+		return nil, false
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		session.warn("Failed to read response from %s: %s", target.url.String(), err)
+		return nil, false
+	}
+	if isHTMLPage(body, response.Header.Get("Content-Type")) {
+		body = pageToUTF8(body, response.Header.Get("Content-Type"))
+	}
+
+	var script string
+	if siteRules := session.siteRulesFor(pageURL); siteRules != nil {
+		script = siteRules.script
+	}
+	mathPage := usesMathTypesetting(body)
+	if session.options.Render || script != "" || (session.options.Math && mathPage) {
+		rendered, loaded, contrast, err := session.renderPage(pageURL.String(), script)
+		if err != nil {
+			session.warn("Failed to render %s, saving it as served: %s", pageURL.String(), err)
+		} else {
+			// the browser hands the DOM over in UTF-8, whatever the page came in
+			body = declareUTF8(rendered)
+			session.renderLoaded[crawlKey(pageURL)] = loaded
+			session.renderContrast[crawlKey(pageURL)] = contrast
+			if mathPage && hasTypesetMath(body) {
+				body = dropMathScripts(body)
+			}
+		}
+	} else if mathPage {
+		session.warn("%s typesets its formulas with MathJax or KaTeX when opened, which may not work offline. Use -math to save them typeset", pageURL.String())
+	}
+
+	localPages[crawlKey(target.url)] = visited[crawlKey(target.url)]
+	if _, taken := localPages[crawlKey(pageURL)]; !taken {
+		localPages[crawlKey(pageURL)] = visited[crawlKey(pageURL)]
+	}
+
+	return &pendingPage{target: target, pageURL: pageURL, body: body}, true
+}
+
+// Point links to pages that are saved locally at their local copies
+func rewriteNavigationLinks(pageBody []byte, from *url.URL, localPages map[string]string) []byte {
+	return walkPageAttributes(pageBody, func(token *html.Token, attribute *html.Attribute) bool {
+		if !isNavigationAttribute(token, attribute.Key) {
+			return false
+		}
+
+		value := strings.TrimSpace(attribute.Val)
+		if value == "" || strings.HasPrefix(value, "#") {
+			return false
+		}
+
+		link, err := url.Parse(value)
+		if err != nil {
+			return false
+		}
+		absoluteLink := from.ResolveReference(link)
+
+		localPage, ok := localPages[crawlKey(absoluteLink)]
+		if !ok {
+			return false
+		}
+
+		attribute.Val = localReference(localPage)
+		if absoluteLink.Fragment != "" {
+			attribute.Val += "#" + absoluteLink.EscapedFragment()
+		}
+
+		return true
+	})
+}
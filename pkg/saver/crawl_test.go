@@ -0,0 +1,189 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCrawlLinksOnlySavedPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/about">About</a><a href="/report.pdf">Report</a><a href="/missing">Missing</a></body></html>`))
+	})
+	mux.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/">Home</a></body></html>`))
+	})
+	mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	pageSaver, err := New(Options{OutputDir: outputDir, Depth: 1, NoRobots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageSaver.Close()
+
+	result, err := pageSaver.Save(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pages) != 2 {
+		t.Fatalf("expected the start page and /about to be saved, got %d pages", len(result.Pages))
+	}
+
+	startPage, err := os.ReadFile(filepath.Join(outputDir, result.Pages[0].OutputPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every local link of the start page leads to a file that has been written
+	for _, link := range findLocalLinks(string(startPage)) {
+		if _, err := os.Stat(filepath.Join(outputDir, link)); err != nil {
+			t.Errorf("start page links to %s, which has not been saved", link)
+		}
+	}
+
+	for _, original := range []string{"/report.pdf", "/missing"} {
+		if !strings.Contains(string(startPage), `href="`+original+`"`) {
+			t.Errorf("link to %s should be left as it is", original)
+		}
+	}
+	if len(findLocalLinks(string(startPage))) != 1 {
+		t.Errorf("expected only the link to /about to point at a local copy")
+	}
+}
+
+// Targets of href="./..." links in the page
+func findLocalLinks(page string) []string {
+	var links []string
+	for _, part := range strings.Split(page, `href="./`)[1:] {
+		link, _, _ := strings.Cut(part, `"`)
+		links = append(links, link)
+	}
+
+	return links
+}
+
+func TestCrawlFlushesPendingPagesPastMemoryThreshold(t *testing.T) {
+	const pages int = 6
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		number, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if r.URL.Path != "/" && (err != nil || number < 1 || number >= pages) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><a href="/">Home</a><a href="/%d">Next</a><p>%s</p></body></html>`, number+1, strings.Repeat("text ", 200))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	pageSaver, err := New(Options{OutputDir: outputDir, Depth: uint(pages), NoRobots: true, MemoryThreshold: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageSaver.Close()
+
+	result, err := pageSaver.Save(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pages) != pages {
+		t.Fatalf("expected %d pages to be saved, got %d", pages, len(result.Pages))
+	}
+
+	var localLinks int = 0
+	for _, report := range result.Pages {
+		page, err := os.ReadFile(filepath.Join(outputDir, report.OutputPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// saved before the pages they link to were fetched, yet never pointing at a missing copy
+		for _, link := range findLocalLinks(string(page)) {
+			localLinks++
+			if _, err := os.Stat(filepath.Join(outputDir, link)); err != nil {
+				t.Errorf("%s links to %s, which has not been saved", report.OutputPath, link)
+			}
+		}
+	}
+	if localLinks == 0 {
+		t.Error("expected links back to the start page to point at its local copy")
+	}
+}
+
+func TestCrawlTakesTurnsBetweenHostsWithinPageLimit(t *testing.T) {
+	var page = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>page</body></html>`))
+	}
+	other := httptest.NewServer(http.HandlerFunc(page))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			page(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><a href="/a1">1</a><a href="/a2">2</a><a href="/a3">3</a><a href="%s/b1">4</a><a href="%s/b2">5</a></body></html>`, other.URL, other.URL)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	pageSaver, err := New(Options{OutputDir: outputDir, Depth: 1, SpanHosts: true, NoRobots: true, MaxPages: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageSaver.Close()
+
+	result, err := pageSaver.Save(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved []string
+	for _, report := range result.Pages {
+		saved = append(saved, report.URL)
+	}
+	// the other host has had nothing fetched yet, so its page comes first
+	var want []string = []string{server.URL + "/", other.URL + "/b1", server.URL + "/a1"}
+	if strings.Join(saved, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v to be saved, got %v", want, saved)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Smallest decoded data: URI worth moving into a separate file
+const minExplodedDataURISize int = 1024
+
+// Extensions for common media types, since mime.ExtensionsByType has no preference among several
+var preferredExtensions map[string]string = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"image/svg+xml":   ".svg",
+	"image/x-icon":    ".ico",
+	"text/css":        ".css",
+	"text/javascript": ".js",
+	"font/woff":       ".woff",
+	"font/woff2":      ".woff2",
+	"audio/mpeg":      ".mp3",
+	"video/mp4":       ".mp4",
+}
+
+// Decode data: URI into its media type and contents
+func decodeDataURI(uri string) (string, []byte, error) {
+	if !strings.HasPrefix(strings.ToLower(uri), "data:") {
+		return "", nil, fmt.Errorf("not a data URI")
+	}
+
+	header, data, found := strings.Cut(uri[len("data:"):], ",")
+	if !found {
+		return "", nil, fmt.Errorf("no comma in data URI")
+	}
+
+	var isBase64 bool = false
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		isBase64 = true
+		header = header[:len(header)-len(";base64")]
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if isBase64 {
+		contents, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+		if err != nil {
+			return "", nil, err
+		}
+
+		return mediaType, contents, nil
+	}
+
+	contents, err := url.PathUnescape(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mediaType, []byte(contents), nil
+}
+
+// File extension for the media type
+func extensionForMediaType(mediaType string) string {
+	if extension, ok := customExtensions.Load(mediaType); ok {
+		return extension.(string)
+	}
+	if extension, ok := preferredExtensions[mediaType]; ok {
+		return extension
+	}
+
+	extensions, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(extensions) == 0 {
+		return ".bin"
+	}
+
+	return extensions[0]
+}
+
+// Move big inline data: URIs of the page into separate files and point references to them
+func (downloader *assetDownloader) explodeDataURIs(pageBody []byte) []byte {
+	var written map[string]bool = make(map[string]bool)
+
+	return walkPageAttributes(pageBody, func(token *html.Token, attribute *html.Attribute) bool {
+		if !downloader.session.links.isAssetAttribute(token, attribute.Key) || !strings.HasPrefix(strings.ToLower(attribute.Val), "data:") {
+			return false
+		}
+
+		mediaType, contents, err := decodeDataURI(attribute.Val)
+		if err != nil || len(contents) < minExplodedDataURISize {
+			return false
+		}
+
+		digest := sha1.Sum(contents)
+		name := fmt.Sprintf("data-%x%s", digest[:8], extensionForMediaType(mediaType))
+		localPath := path.Join(downloader.filesDir, name)
+
+		if !written[name] {
+			outputFile, err := downloader.out.Create(localPath)
+			if err != nil {
+				return false
+			}
+			_, err = outputFile.Write(contents)
+			outputFile.Close()
+			if err != nil {
+				return false
+			}
+			written[name] = true
+
+			downloader.record(AssetOutcome{
+				URL:         "data:" + mediaType,
+				LocalPath:   localPath,
+				Kind:        ClassifyLink(&url.URL{Path: name}),
+				ContentType: mediaType,
+				Status:      AssetSaved,
+				Size:        int64(len(contents)),
+			})
+		}
+
+		attribute.Val = localReference(localPath)
+
+		return true
+	})
+}
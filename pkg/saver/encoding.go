@@ -0,0 +1,105 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings gospa asks for and decodes itself
+const acceptEncoding string = "gzip, deflate, br"
+
+// Response body decoded from its content coding, closing the raw body underneath
+type decodedBody struct {
+	io.Reader
+	raw io.Closer
+}
+
+func (body *decodedBody) Close() error {
+	if closer, ok := body.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+
+	return body.raw.Close()
+}
+
+// Reader of content encoded with given coding, one of acceptEncoding's
+func decodingReader(coding string, encoded io.Reader) (io.Reader, error) {
+	switch coding {
+	case "br":
+		return brotli.NewReader(encoded), nil
+	case "deflate":
+		// meant to be zlib-wrapped, but some servers send raw deflate
+		buffered := bufio.NewReader(encoded)
+		header, err := buffered.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	default:
+		return gzip.NewReader(encoded)
+	}
+}
+
+// Decode response's body from the codings in its Content-Encoding, so that what is parsed and saved
+// is the content itself. Bodies with codings gospa does not know are left as they are
+func decodeContent(response *http.Response) error {
+	var codings []string
+	for _, value := range response.Header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			switch coding {
+			case "", "identity":
+			case "gzip", "x-gzip", "deflate", "br":
+				codings = append(codings, coding)
+			default:
+				return nil
+			}
+		}
+	}
+	if len(codings) == 0 || response.Request.Method == http.MethodHead || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	var reader io.Reader = response.Body
+	// codings are listed in the order they were applied
+	for index := len(codings) - 1; index >= 0; index-- {
+		decoder, err := decodingReader(codings[index], reader)
+		if err == io.EOF {
+			// nothing to decode
+			reader = strings.NewReader("")
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode %s content: %s", codings[index], err)
+		}
+		reader = decoder
+	}
+
+	response.Body = &decodedBody{Reader: reader, raw: response.Body}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+
+	return nil
+}
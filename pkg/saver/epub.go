@@ -0,0 +1,322 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Directory inside the book's content directory with the page's files
+const epubFilesDir string = "files"
+
+// Elements that have no place in an e-book
+var epubDroppedElements map[string]bool = map[string]bool{
+	"script": true, "noscript": true, "iframe": true, "embed": true, "object": true,
+}
+
+// Attribute names that are also valid XML names
+var xmlAttributeNameRegexp *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Turn the saved page into well-formed XHTML suitable for an EPUB content document
+func pageToXHTML(pageBody []byte, filesPrefix string) ([]byte, error) {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return nil, err
+	}
+
+	// reference of a saved file, pointed into the book's files directory
+	toBookPath := func(reference string) string {
+		if strings.HasPrefix(reference, filesPrefix) {
+			return epubFilesDir + "/" + strings.TrimPrefix(reference, filesPrefix)
+		}
+		return reference
+	}
+
+	var clean func(node *html.Node)
+	clean = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; {
+			next := child.NextSibling
+			if child.Type == html.DoctypeNode || child.Type == html.CommentNode ||
+				(child.Type == html.ElementNode && epubDroppedElements[child.Data]) {
+				node.RemoveChild(child)
+			} else {
+				clean(child)
+			}
+			child = next
+		}
+
+		if node.Type != html.ElementNode {
+			return
+		}
+
+		var attributes []html.Attribute
+		for _, attribute := range node.Attr {
+			if attribute.Namespace != "" || !xmlAttributeNameRegexp.MatchString(attribute.Key) ||
+				strings.HasPrefix(attribute.Key, "on") || attribute.Key == "xmlns" {
+				continue
+			}
+			if isSrcsetAttribute(&html.Token{Data: node.Data}, attribute.Key) {
+				candidates := parseSrcset(attribute.Val)
+				for index := range candidates {
+					candidates[index].URL = toBookPath(candidates[index].URL)
+				}
+				attribute.Val = serializeSrcset(candidates)
+			} else {
+				attribute.Val = toBookPath(attribute.Val)
+			}
+			attributes = append(attributes, attribute)
+		}
+		if node.Data == "html" {
+			attributes = append(attributes, html.Attribute{Key: "xmlns", Val: "http://www.w3.org/1999/xhtml"})
+		}
+		node.Attr = attributes
+	}
+	clean(document)
+
+	var xhtml bytes.Buffer
+	xhtml.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE html>\n")
+	err = html.Render(&xhtml, document)
+	if err != nil {
+		return nil, err
+	}
+
+	return xhtml.Bytes(), nil
+}
+
+// Escape text for XML
+func xmlEscape(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+
+	return escaped.String()
+}
+
+// Package saved page files into an EPUB 3 book (with an NCX table of contents for older readers) written into book
+func buildEPUB(book io.Writer, files *memoryOutput, report *PageReport, from *url.URL, filesDir string, metadata PageMetadata) error {
+	pageFile, err := files.read(report.OutputPath)
+	if err != nil {
+		return err
+	}
+	page, err := pageToXHTML(pageFile, localReference(filesDir)+"/")
+	if err != nil {
+		return err
+	}
+
+	var contentTypes map[string]string = make(map[string]string)
+	for _, asset := range report.Assets {
+		if asset.Status == AssetSaved && asset.ContentType != "" {
+			contentTypes[asset.LocalPath] = asset.ContentType
+		}
+	}
+
+	title := metadata.Title
+	if title == "" {
+		title = from.String()
+	}
+	language := metadata.Language
+	if language == "" {
+		language = "en"
+	}
+
+	archive := zip.NewWriter(book)
+	now := time.Now()
+
+	// must come first and uncompressed
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: now})
+	if err != nil {
+		return err
+	}
+	mimetype.Write([]byte("application/epub+zip"))
+
+	write := func(name string, contents []byte) error {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(contents)
+		return err
+	}
+	copyFile := func(name string, filePath string) error {
+		contents, err := files.open(filePath)
+		if err != nil {
+			return err
+		}
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, contents)
+		return err
+	}
+
+	err = write("META-INF/container.xml", []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+	if err != nil {
+		return err
+	}
+
+	var manifest bytes.Buffer
+	fmt.Fprintf(&manifest, "    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	fmt.Fprintf(&manifest, "    <item id=\"ncx\" href=\"toc.ncx\" media-type=\"application/x-dtbncx+xml\"/>\n")
+	fmt.Fprintf(&manifest, "    <item id=\"page\" href=\"page.xhtml\" media-type=\"application/xhtml+xml\"/>\n")
+
+	for index, name := range files.names() {
+		if name == report.OutputPath {
+			continue
+		}
+
+		mediaType, ok := contentTypes[name]
+		if !ok {
+			mediaType, err = files.detectMediaType(name)
+			if err != nil {
+				return err
+			}
+		}
+		mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+		if mediaType == "text/javascript" || mediaType == "application/javascript" {
+			// scripts are dropped from the page
+			continue
+		}
+
+		href := epubFilesDir + "/" + strings.TrimPrefix(name, filesDir+"/")
+		err = copyFile("OEBPS/"+href, name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&manifest, "    <item id=\"file%d\" href=\"%s\" media-type=\"%s\"/>\n", index, xmlEscape(escapePath(href)), xmlEscape(mediaType))
+	}
+
+	var creator string
+	if metadata.Author != "" {
+		creator = fmt.Sprintf("    <dc:creator>%s</dc:creator>\n", xmlEscape(metadata.Author))
+	}
+
+	err = write("OEBPS/content.opf", []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+    <dc:source>%s</dc:source>
+%s    <meta property="dcterms:modified">%s</meta>
+  </metadata>
+  <manifest>
+%s  </manifest>
+  <spine toc="ncx">
+    <itemref idref="page"/>
+  </spine>
+</package>
+`,
+		xmlEscape(from.String()),
+		xmlEscape(title),
+		xmlEscape(language),
+		xmlEscape(from.String()),
+		creator,
+		now.UTC().Format("2006-01-02T15:04:05Z"),
+		manifest.String(),
+	)))
+	if err != nil {
+		return err
+	}
+
+	err = write("OEBPS/toc.ncx", []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="%s"/>
+  </head>
+  <docTitle><text>%s</text></docTitle>
+  <navMap>
+    <navPoint id="page" playOrder="1">
+      <navLabel><text>%s</text></navLabel>
+      <content src="page.xhtml"/>
+    </navPoint>
+  </navMap>
+</ncx>
+`, xmlEscape(from.String()), xmlEscape(title), xmlEscape(title))))
+	if err != nil {
+		return err
+	}
+
+	err = write("OEBPS/nav.xhtml", []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body>
+  <nav epub:type="toc">
+    <ol><li><a href="page.xhtml">%s</a></li></ol>
+  </nav>
+</body>
+</html>
+`, xmlEscape(title), xmlEscape(title))))
+	if err != nil {
+		return err
+	}
+
+	err = write("OEBPS/page.xhtml", page)
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// Save page as an .epub book with its images, stylesheets and fonts
+func (session *session) saveEPUBPage(pageBody []byte, out output, from *url.URL, baseName string) (*PageReport, error) {
+	memory := newMemoryOutput(session.options.MemoryThreshold)
+	defer memory.Close()
+	report, err := session.savePage(pageBody, memory, from, baseName)
+	if err != nil {
+		return nil, err
+	}
+
+	outfile, err := out.Create(baseName + ".epub")
+	if err != nil {
+		return nil, err
+	}
+
+	book := &countingWriter{Writer: outfile}
+	err = buildEPUB(book, memory, report, from, baseName+"_files", extractMetadata(pageBody))
+	if err != nil {
+		outfile.Abort()
+		return nil, err
+	}
+	err = outfile.Close()
+	if err != nil {
+		return nil, err
+	}
+	report.OutputPath = baseName + ".epub"
+	report.Size = book.written
+
+	for index := range report.Assets {
+		if report.Assets[index].Status == AssetSaved {
+			report.Assets[index].LocalPath = "(embedded)"
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,41 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageToXHTMLRewritesEverySrcsetCandidate(t *testing.T) {
+	page := `<html><body><picture>` +
+		`<source srcset="./page_files/a.webp 1x, ./page_files/b.webp 2x">` +
+		`<img src="./page_files/a.png" srcset="./page_files/a.png 480w, https://example.com/c.png 800w, ./page_files/b.png 1200w">` +
+		`</picture><link rel="preload" imagesrcset="./page_files/a.png 1x,./page_files/b.png 2x"></body></html>`
+
+	xhtml, err := pageToXHTML([]byte(page), "./page_files/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`srcset="files/a.webp 1x, files/b.webp 2x"`,
+		`src="files/a.png"`,
+		`srcset="files/a.png 480w, https://example.com/c.png 800w, files/b.png 1200w"`,
+		`imagesrcset="files/a.png 1x, files/b.png 2x"`,
+	} {
+		if !strings.Contains(string(xhtml), want) {
+			t.Errorf("expected %s in\n%s", want, xhtml)
+		}
+	}
+}
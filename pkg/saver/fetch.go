@@ -0,0 +1,439 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Biggest image to download in lite mode
+const liteMaxImageSize int64 = 200 * 1024
+
+// Send a GET request for link with all configured headers
+func (session *session) fetch(link string) (*http.Response, error) {
+	return session.fetchWithHeaders(link, nil)
+}
+
+// Send a GET request for link with all configured headers plus extra ones, which take precedence.
+// Network errors, 5xx and 429 responses are retried with backoff, or after as long as Retry-After says,
+// unless the host's circuit breaker is open
+func (session *session) fetchWithHeaders(link string, extraHeaders http.Header) (*http.Response, error) {
+	link, extraHeaders, err := session.hooks.preFetch(session.ctx, link, extraHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(session.ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var attempt uint = 0
+	for {
+		if !session.breakers.allow(request.URL.Host) {
+			return nil, errHostUnavailable
+		}
+
+		err = session.pacer.wait(session.ctx, request.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := session.fetchWithTimeout(request, extraHeaders)
+		failed := err != nil || isRetryableStatus(response.StatusCode)
+		if !failed {
+			session.breakers.succeeded(request.URL.Host)
+			_, err = session.hooks.run(session.ctx, hookInput{
+				Event:   hookPostFetch,
+				URL:     response.Request.URL.String(),
+				Headers: hookHeaders(response.Header),
+				Status:  response.StatusCode,
+			})
+			if err != nil {
+				session.warn("%s", err)
+			}
+
+			return response, nil
+		}
+		if session.ctx.Err() != nil {
+			// not the host's fault
+			return response, err
+		}
+		var refused *redirectError
+		if errors.As(err, &refused) {
+			return nil, err
+		}
+		session.breakers.failed(request.URL.Host)
+
+		if attempt >= session.options.Retries {
+			return response, err
+		}
+		delay := retryBackoff(attempt)
+		if wait, ok := retryAfter(response); ok {
+			delay = wait
+		}
+		if response != nil {
+			// let the connection be reused
+			io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+			response.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-session.ctx.Done():
+			return nil, session.ctx.Err()
+		}
+		attempt++
+	}
+}
+
+// Returned when the server does not respond, or stops sending the body, for longer than the request timeout
+var errRequestTimedOut error = errors.New("request timed out")
+
+// Response body that gives up on its request once it stalls for longer than timeout
+type stallGuardBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (body *stallGuardBody) Read(buffer []byte) (int, error) {
+	read, err := body.ReadCloser.Read(buffer)
+	if err != nil && context.Cause(body.ctx) == errRequestTimedOut {
+		return read, errRequestTimedOut
+	}
+	body.timer.Reset(body.timeout)
+
+	return read, err
+}
+
+func (body *stallGuardBody) Close() error {
+	body.timer.Stop()
+	err := body.ReadCloser.Close()
+	body.cancel(nil)
+
+	return err
+}
+
+// Make a single request, given up on with errRequestTimedOut if it stalls for longer than the request timeout
+func (session *session) fetchWithTimeout(request *http.Request, extraHeaders http.Header) (*http.Response, error) {
+	timeout := session.options.Timeout
+	if isOnionHost(request.URL.Hostname()) {
+		// Tor circuits are slow to build and slow to send through
+		timeout *= onionTimeoutFactor
+	}
+	if timeout <= 0 {
+		return session.fetchOnce(request, extraHeaders)
+	}
+
+	ctx, cancel := context.WithCancelCause(request.Context())
+	timer := time.AfterFunc(timeout, func() {
+		cancel(errRequestTimedOut)
+	})
+
+	response, err := session.fetchOnce(request.Clone(ctx), extraHeaders)
+	if err != nil {
+		timer.Stop()
+		if context.Cause(ctx) == errRequestTimedOut {
+			err = errRequestTimedOut
+		}
+		cancel(nil)
+
+		return response, err
+	}
+
+	timer.Reset(timeout)
+	response.Body = &stallGuardBody{
+		ReadCloser: response.Body,
+		ctx:        ctx,
+		cancel:     cancel,
+		timer:      timer,
+		timeout:    timeout,
+	}
+
+	return response, nil
+}
+
+// Make a single request, recording it if asked to
+func (session *session) fetchOnce(request *http.Request, extraHeaders http.Header) (*http.Response, error) {
+	started := time.Now()
+
+	if isFTPScheme(request.URL.Scheme) {
+		response, err := fetchFTP(request.Context(), request.URL)
+		if err == nil {
+			response.Body = session.bandwidth.wrap(request.Context(), response.Body)
+		}
+		if session.har != nil {
+			if err != nil {
+				session.har.recordFailure(request, started, err)
+			} else {
+				session.har.record(request, response, started)
+			}
+		}
+		if err == nil && session.warc != nil {
+			session.warc.recordResource(response)
+		}
+
+		return response, err
+	}
+
+	if session.options.Lite {
+		request.Header.Set("Save-Data", "on")
+		request.Header.Set("Accept", "image/avif,image/webp,text/html,text/css,*/*;q=0.8")
+	}
+
+	if userAgent := session.userAgents.pick(); userAgent != "" {
+		request.Header.Set("User-Agent", userAgent)
+	}
+	if request.URL.Host == session.authHost {
+		if user, password, ok := strings.Cut(session.options.BasicAuth, ":"); ok {
+			request.SetBasicAuth(user, password)
+		}
+		if session.options.BearerToken != "" {
+			request.Header.Set("Authorization", "Bearer "+session.options.BearerToken)
+		}
+	}
+	for key, values := range session.options.Headers {
+		request.Header[key] = values
+	}
+	if siteRules := session.siteRulesFor(request.URL); siteRules != nil {
+		for key, values := range siteRules.headers {
+			request.Header[key] = values
+		}
+	}
+	for key, values := range extraHeaders {
+		request.Header[key] = values
+	}
+	if request.Header.Get("Accept-Encoding") == "" && !session.options.Compat {
+		// asked for here rather than by the transport, which would only decode gzip
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	if host := request.Header.Get("Host"); host != "" {
+		// Go sends Host from the request itself, not from its headers
+		request.Host = host
+	}
+
+	if session.options.Compat {
+		// some embedded servers ignore "Connection: close" unless asked explicitly on each request
+		request.Close = true
+	}
+
+	if session.warc != nil {
+		// for redirects to be recorded on the way
+		request = request.WithContext(context.WithValue(request.Context(), warcContextKey{}, session.warc))
+	}
+
+	response, err := session.client.Do(request)
+	if err == nil {
+		response.Body = session.bandwidth.wrap(request.Context(), response.Body)
+	}
+	if session.har != nil {
+		if err != nil {
+			session.har.recordFailure(request, started, err)
+		} else {
+			session.har.record(response.Request, response, started)
+		}
+	}
+	if err == nil && session.warc != nil {
+		// as it came over the wire, encoded
+		err = session.warc.recordHTTP(response)
+		if err != nil {
+			response.Body.Close()
+		}
+	}
+	if err == nil {
+		err = decodeContent(response)
+		if err != nil {
+			response.Body.Close()
+		}
+	}
+
+	return response, err
+}
+
+// Client for all requests. Connections are kept alive and reused, with enough idle ones
+// per host for every download worker to pick up its next file without a new handshake.
+// Savers with another number of workers get a copy with their own number of idle connections
+var sharedClient *http.Client = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   DefaultConcurrency,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	CheckRedirect: defaultRedirectPolicy.check,
+}
+
+// Client for ancient or embedded-device servers that choke on default behavior:
+// HTTP/1.1 only, no keep-alive, no transparent gzip, old TLS versions and renegotiation allowed
+var compatClient *http.Client = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                  http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:      false,
+		TLSNextProto:           make(map[string]func(string, *tls.Conn) http.RoundTripper),
+		DisableKeepAlives:      true,
+		DisableCompression:     true,
+		MaxResponseHeaderBytes: 1 << 20,
+		ResponseHeaderTimeout:  time.Minute,
+		TLSClientConfig: &tls.Config{
+			MinVersion:    tls.VersionTLS10,
+			Renegotiation: tls.RenegotiateFreelyAsClient,
+		},
+	},
+	CheckRedirect: defaultRedirectPolicy.check,
+}
+
+// How many times longer requests to onion services may take
+const onionTimeoutFactor time.Duration = 3
+
+// Whether host is a Tor onion service
+func isOnionHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".onion")
+}
+
+// Connection settings that need a transport of their own instead of the shared one
+type transportOptions struct {
+	proxyURL *url.URL
+	// skip certificate verification
+	insecure bool
+	// trusted certificate authorities, system ones if nil
+	rootCAs *x509.CertPool
+	// certificate to present to servers that ask for one
+	clientCertificate *tls.Certificate
+	// idle connections kept per host, if not as many as DefaultConcurrency
+	idlePerHost int
+}
+
+// Which redirects requests follow
+type redirectPolicy struct {
+	// most redirects to follow, none if negative
+	max int
+	// only follow redirects to the host that was asked
+	sameHost bool
+}
+
+// What sharedClient and compatClient do
+var defaultRedirectPolicy redirectPolicy = redirectPolicy{max: DefaultMaxRedirects}
+
+// Request that went against the redirect policy. Not retried, as it would only happen again
+type redirectError struct {
+	message string
+}
+
+func (err *redirectError) Error() string {
+	return err.message
+}
+
+// Check redirect to request, made after via, against the policy
+func (policy redirectPolicy) check(request *http.Request, via []*http.Request) error {
+	if writer, ok := request.Context().Value(warcContextKey{}).(*warcWriter); ok && request.Response != nil {
+		writer.recordRedirect(request.Response)
+	}
+
+	if policy.max < 0 {
+		return &redirectError{"redirects are not followed"}
+	}
+	if len(via) > policy.max {
+		return &redirectError{fmt.Sprintf("stopped after %d redirects", policy.max)}
+	}
+	if policy.sameHost && request.URL.Host != via[0].URL.Host {
+		return &redirectError{"refusing to follow redirect to another host"}
+	}
+
+	return nil
+}
+
+// Client for the given settings: sharedClient or compatClient, with its transport changed
+// as transport says, redirects followed as redirects say and cookies kept in jar if they are set
+func newClient(compat bool, transport transportOptions, redirects redirectPolicy, jar http.CookieJar) *http.Client {
+	var client *http.Client = sharedClient
+	if compat {
+		client = compatClient
+	}
+	if transport == (transportOptions{}) && redirects == defaultRedirectPolicy && jar == nil {
+		return client
+	}
+
+	var roundTripper http.RoundTripper = client.Transport
+	if transport != (transportOptions{}) {
+		changed := client.Transport.(*http.Transport).Clone()
+		if transport.proxyURL != nil {
+			changed.Proxy = http.ProxyURL(transport.proxyURL)
+		}
+
+		var tlsConfig *tls.Config = &tls.Config{}
+		if changed.TLSClientConfig != nil {
+			tlsConfig = changed.TLSClientConfig.Clone()
+		}
+		tlsConfig.InsecureSkipVerify = transport.insecure
+		if transport.rootCAs != nil {
+			tlsConfig.RootCAs = transport.rootCAs
+		}
+		if transport.clientCertificate != nil {
+			tlsConfig.Certificates = []tls.Certificate{*transport.clientCertificate}
+		}
+		changed.TLSClientConfig = tlsConfig
+
+		if transport.idlePerHost > 0 {
+			changed.MaxIdleConnsPerHost = transport.idlePerHost
+			changed.MaxIdleConns = max(changed.MaxIdleConns, transport.idlePerHost)
+		}
+
+		roundTripper = changed
+	}
+
+	return &http.Client{Transport: roundTripper, CheckRedirect: redirects.check, Jar: jar}
+}
+
+// Load PEM certificates from path and trust them in addition to the system ones
+func loadCACertificates(path string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// Whether asset should not be downloaded in lite mode at all
+func skippedInLiteMode(kind AssetKind) bool {
+	return kind == AssetFont || kind == AssetMedia
+}
@@ -0,0 +1,80 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Address of the raw file shown by a GitHub or GitLab file page, or nil if the page is not one.
+// The web UI around a file is heavy and, on GitLab, built by scripts, while the raw file is the thing itself
+func rawSourceURL(pageURL *url.URL, pageBody []byte) *url.URL {
+	host := strings.ToLower(pageURL.Hostname())
+	segments := strings.Split(strings.Trim(pageURL.Path, "/"), "/")
+
+	switch {
+	case host == "github.com" || host == "www.github.com":
+		// /owner/repo/blob/ref/path -> raw.githubusercontent.com/owner/repo/ref/path
+		if len(segments) < 5 || segments[2] != "blob" {
+			return nil
+		}
+		return &url.URL{
+			Scheme: "https",
+			Host:   "raw.githubusercontent.com",
+			Path:   "/" + path.Join(append(segments[:2:2], segments[3:]...)...),
+		}
+
+	case host == "gitlab.com" || bytes.Contains(pageBody, []byte(`content="GitLab"`)):
+		// /group/project/-/blob/ref/path -> /group/project/-/raw/ref/path
+		if !strings.Contains(pageURL.Path, "/-/blob/") {
+			return nil
+		}
+		rawURL := *pageURL
+		rawURL.Path = strings.Replace(pageURL.Path, "/-/blob/", "/-/raw/", 1)
+		rawURL.RawPath = ""
+		rawURL.RawQuery = ""
+		rawURL.Fragment = ""
+		return &rawURL
+	}
+
+	return nil
+}
+
+// Download the raw file next to the saved page. Returns name of the written file
+func (session *session) writeRawSource(rawURL *url.URL, baseName string) (string, error) {
+	response, err := session.fetch(rawURL.String())
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s responded with %s", rawURL.String(), response.Status)
+	}
+
+	var sourceName string = baseName + ".raw" + path.Ext(rawURL.Path)
+	sourceFile, err := session.out.Create(sourceName)
+	if err != nil {
+		return "", err
+	}
+	defer sourceFile.Close()
+
+	_, err = io.Copy(sourceFile, response.Body)
+	return sourceName, err
+}
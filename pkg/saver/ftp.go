@@ -0,0 +1,178 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// Whether link is served over FTP or FTPS (explicit TLS)
+func isFTPScheme(scheme string) bool {
+	return scheme == "ftp" || scheme == "ftps"
+}
+
+// Whether links with this scheme can be downloaded at all
+func isFetchableScheme(scheme string) bool {
+	return scheme == "http" || scheme == "https" || isFTPScheme(scheme)
+}
+
+// Retrieved FTP file that closes the control connection together with the data one
+type ftpBody struct {
+	io.ReadCloser
+	conn *ftp.ServerConn
+}
+
+func (body *ftpBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.conn.Quit()
+
+	return err
+}
+
+// Connect and log in to the FTP server of the link. Anonymous login is used unless the link has credentials
+func dialFTP(ctx context.Context, link *url.URL) (*ftp.ServerConn, error) {
+	host := link.Host
+	if link.Port() == "" {
+		host = net.JoinHostPort(link.Hostname(), "21")
+	}
+
+	options := []ftp.DialOption{ftp.DialWithTimeout(30 * time.Second), ftp.DialWithContext(ctx)}
+	if link.Scheme == "ftps" {
+		options = append(options, ftp.DialWithExplicitTLS(&tls.Config{ServerName: link.Hostname()}))
+	}
+
+	conn, err := ftp.Dial(host, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var user string = "anonymous"
+	var password string = "anonymous"
+	if link.User != nil {
+		user = link.User.Username()
+		password, _ = link.User.Password()
+	}
+
+	err = conn.Login(user, password)
+	if err != nil {
+		conn.Quit()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Render directory listing as a page, so it can be saved and crawled like any other
+func ftpListingPage(link *url.URL, entries []*ftp.Entry) []byte {
+	var page bytes.Buffer
+
+	title := html.EscapeString(link.Redacted())
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Index of %s</title></head><body>\n", title)
+	fmt.Fprintf(&page, "<h1>Index of %s</h1>\n<ul>\n", title)
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		var name string = entry.Name
+		if entry.Type == ftp.EntryTypeFolder {
+			name += "/"
+		}
+
+		entryLink := link.ResolveReference(&url.URL{Path: "./" + name})
+		fmt.Fprintf(&page, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(entryLink.String()), html.EscapeString(name))
+	}
+	page.WriteString("</ul>\n</body></html>\n")
+
+	return page.Bytes()
+}
+
+// Download a file or list a directory over FTP, wrapped into an HTTP response for the rest of the pipeline
+func fetchFTP(ctx context.Context, link *url.URL) (*http.Response, error) {
+	conn, err := dialFTP(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := link.Path
+	if filePath == "" {
+		filePath = "/"
+	}
+
+	response := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     make(http.Header),
+		Request:    &http.Request{Method: http.MethodGet, URL: link},
+	}
+
+	if !strings.HasSuffix(filePath, "/") {
+		// the control connection is busy during the transfer, so ask for the size beforehand
+		size, sizeErr := conn.FileSize(filePath)
+
+		retrieved, err := conn.Retr(filePath)
+		if err == nil {
+			response.ContentLength = -1
+			if sizeErr == nil {
+				response.ContentLength = size
+				response.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+			}
+
+			contentType := mime.TypeByExtension(path.Ext(filePath))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			response.Header.Set("Content-Type", contentType)
+			response.Body = &ftpBody{ReadCloser: retrieved, conn: conn}
+
+			return response, nil
+		}
+		// might be a directory without a trailing slash
+	}
+
+	entries, err := conn.List(filePath)
+	conn.Quit()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(link.Path, "/") {
+		var directoryLink url.URL = *link
+		directoryLink.Path += "/"
+		link = &directoryLink
+	}
+
+	page := ftpListingPage(link, entries)
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.ContentLength = int64(len(page))
+	response.Body = io.NopCloser(bytes.NewReader(page))
+
+	return response, nil
+}
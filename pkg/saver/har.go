@@ -0,0 +1,294 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAR 1.2 structures, only the parts gospa can fill in
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Error       string         `json:"_error,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+// Collects every request made while saving
+type harRecorder struct {
+	mutex   sync.Mutex
+	entries []harEntry
+	// keep values of credentialHeaders instead of redacting them
+	keepCredentials bool
+	// applied to URLs and header values, if set
+	rules *redactionRules
+}
+
+// Value with redaction rules applied
+func (recorder *harRecorder) redact(value string) string {
+	if recorder.rules == nil {
+		return value
+	}
+
+	return recorder.rules.applyString(value)
+}
+
+// Headers with passwords, tokens and session cookies, redacted unless asked otherwise
+var credentialHeaders []string = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// What values of redacted headers are replaced with
+const harRedacted string = "[redacted]"
+
+func (recorder *harRecorder) headers(header http.Header) []harNameValue {
+	var headers []harNameValue = []harNameValue{}
+	for name, values := range header {
+		redact := !recorder.keepCredentials && slices.Contains(credentialHeaders, http.CanonicalHeaderKey(name))
+		for _, value := range values {
+			if redact {
+				value = harRedacted
+			}
+			headers = append(headers, harNameValue{Name: name, Value: recorder.redact(value)})
+		}
+	}
+
+	return headers
+}
+
+// Request as it went out over httpVersion
+func (recorder *harRecorder) request(request *http.Request, httpVersion string) harRequest {
+	var query []harNameValue = []harNameValue{}
+	for name, values := range request.URL.Query() {
+		for _, value := range values {
+			query = append(query, harNameValue{Name: name, Value: recorder.redact(value)})
+		}
+	}
+
+	return harRequest{
+		Method:      request.Method,
+		URL:         recorder.redact(request.URL.String()),
+		HTTPVersion: httpVersion,
+		Headers:     recorder.headers(request.Header),
+		QueryString: query,
+		Cookies:     []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+func (recorder *harRecorder) add(entry harEntry) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.entries = append(recorder.entries, entry)
+}
+
+// Record a request that got no response at all
+func (recorder *harRecorder) recordFailure(request *http.Request, started time.Time, err error) {
+	recorder.add(harEntry{
+		StartedDateTime: started,
+		Time:            milliseconds(time.Since(started)),
+		// the version that would have been spoken is not known without a response
+		Request: recorder.request(request, request.Proto),
+		Response: harResponse{
+			Headers:     []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+			Error:       recorder.redact(err.Error()),
+		},
+		Timings: harTimings{Wait: milliseconds(time.Since(started))},
+	})
+}
+
+// Response body that completes its HAR entry once it has been read and closed
+type harBody struct {
+	io.ReadCloser
+	recorder *harRecorder
+	entry    harEntry
+	started  time.Time
+	received time.Time
+	size     int64
+}
+
+func (body *harBody) Read(buffer []byte) (int, error) {
+	read, err := body.ReadCloser.Read(buffer)
+	body.size += int64(read)
+
+	return read, err
+}
+
+func (body *harBody) Close() error {
+	body.entry.Response.Content.Size = body.size
+	body.entry.Response.BodySize = body.size
+	body.entry.Timings.Receive = milliseconds(time.Since(body.received))
+	body.entry.Time = milliseconds(time.Since(body.started))
+	body.recorder.add(body.entry)
+
+	return body.ReadCloser.Close()
+}
+
+// Start recording the exchange. The entry is complete when the response body gets closed
+func (recorder *harRecorder) record(request *http.Request, response *http.Response, started time.Time) {
+	// "200 OK" -> "OK"
+	var statusText string = strings.TrimSpace(strings.TrimPrefix(response.Status, strconv.Itoa(response.StatusCode)))
+
+	response.Body = &harBody{
+		ReadCloser: response.Body,
+		recorder:   recorder,
+		started:    started,
+		received:   time.Now(),
+		entry: harEntry{
+			StartedDateTime: started,
+			Request:         recorder.request(request, response.Proto),
+			Response: harResponse{
+				Status:      response.StatusCode,
+				StatusText:  statusText,
+				HTTPVersion: response.Proto,
+				Headers:     recorder.headers(response.Header),
+				Cookies:     []harNameValue{},
+				Content:     harContent{MimeType: response.Header.Get("Content-Type")},
+				RedirectURL: recorder.redact(response.Header.Get("Location")),
+				HeadersSize: -1,
+			},
+			Timings: harTimings{Wait: milliseconds(time.Since(started))},
+		},
+	}
+}
+
+// Write all recorded entries as HAR, in the order their requests were made
+func (recorder *harRecorder) encode(writer io.Writer) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	var har struct {
+		Log struct {
+			Version string `json:"version"`
+			Creator struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"creator"`
+			Pages   []struct{} `json:"pages"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	har.Log.Version = "1.2"
+	har.Log.Creator.Name = "Gospa"
+	har.Log.Creator.Version = VERSION
+	har.Log.Pages = []struct{}{}
+	har.Log.Entries = recorder.entries
+	if har.Log.Entries == nil {
+		har.Log.Entries = []harEntry{}
+	}
+	// entries are added as their responses finish
+	sort.SliceStable(har.Log.Entries, func(i, j int) bool {
+		return har.Log.Entries[i].StartedDateTime.Before(har.Log.Entries[j].StartedDateTime)
+	})
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(har)
+}
+
+// Write all recorded entries into a HAR file at harPath
+func (recorder *harRecorder) write(harPath string) error {
+	err := os.MkdirAll(filepath.Dir(harPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	harFile, err := os.Create(harPath)
+	if err != nil {
+		return err
+	}
+
+	err = recorder.encode(harFile)
+	if err != nil {
+		harFile.Close()
+		return err
+	}
+
+	return harFile.Close()
+}
+
+// Write all recorded entries into a HAR file named name in out
+func (recorder *harRecorder) writeInto(out output, name string) error {
+	harFile, err := out.Create(name)
+	if err != nil {
+		return err
+	}
+
+	err = recorder.encode(harFile)
+	if err != nil {
+		harFile.Abort()
+		return err
+	}
+
+	return harFile.Close()
+}
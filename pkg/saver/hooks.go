@@ -0,0 +1,171 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Lifecycle points hook scripts can be invoked at, named after the scripts
+const (
+	// before every request. The script may print {"url": ..., "headers": {...}} to change the request
+	hookPreFetch string = "pre-fetch"
+	// after every successful response
+	hookPostFetch string = "post-fetch"
+	// after every saved page
+	hookPostSave string = "post-save"
+)
+
+// What a hook script gets as JSON on stdin
+type hookInput struct {
+	Event      string            `json:"event"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Status     int               `json:"status,omitempty"`
+	OutputPath string            `json:"output_path,omitempty"`
+}
+
+// What a pre-fetch hook script may print as JSON to stdout. Empty fields leave the request as is
+type hookOutput struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// Executable scripts found in the hooks directory: event -> script path
+type hooks map[string]string
+
+// Find hook scripts in dir. Scripts are named after their event, with or without an extension
+func loadHooks(dir string) (hooks, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found hooks = make(hooks)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		event := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		switch event {
+		case hookPreFetch, hookPostFetch, hookPostSave:
+		default:
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&0111 == 0 {
+			return nil, fmt.Errorf("hook script %s is not executable", entry.Name())
+		}
+		if _, ok := found[event]; ok {
+			return nil, fmt.Errorf("more than one %s hook script", event)
+		}
+
+		found[event] = filepath.Join(dir, entry.Name())
+	}
+
+	return found, nil
+}
+
+// Flatten headers for a hook script
+func hookHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+
+	var flat map[string]string = make(map[string]string, len(header))
+	for key := range header {
+		flat[key] = header.Get(key)
+	}
+
+	return flat
+}
+
+// Run the script for input's event, if there is one, and return what it printed
+func (found hooks) run(ctx context.Context, input hookInput) ([]byte, error) {
+	scriptPath, ok := found[input.Event]
+	if !ok {
+		return nil, nil
+	}
+
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	command := exec.CommandContext(ctx, scriptPath)
+	command.Stdin = bytes.NewReader(stdin)
+	command.Stdout = &stdout
+	command.Stderr = os.Stderr
+
+	err = command.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s hook failed: %s", input.Event, err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Let the pre-fetch hook change the link and headers of a request about to be made
+func (found hooks) preFetch(ctx context.Context, link string, headers http.Header) (string, http.Header, error) {
+	if _, ok := found[hookPreFetch]; !ok {
+		return link, headers, nil
+	}
+
+	printed, err := found.run(ctx, hookInput{
+		Event:   hookPreFetch,
+		URL:     link,
+		Headers: hookHeaders(headers),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(bytes.TrimSpace(printed)) == 0 {
+		return link, headers, nil
+	}
+
+	var output hookOutput
+	err = json.Unmarshal(printed, &output)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s hook printed invalid JSON: %s", hookPreFetch, err)
+	}
+
+	if output.URL != "" {
+		link = output.URL
+	}
+	if len(output.Headers) > 0 {
+		merged := headers.Clone()
+		if merged == nil {
+			merged = make(http.Header)
+		}
+		for key, value := range output.Headers {
+			merged.Set(key, value)
+		}
+		headers = merged
+	}
+
+	return link, headers, nil
+}
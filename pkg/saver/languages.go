@@ -0,0 +1,191 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// Language version of a page to be saved alongside it
+type languageVariant struct {
+	language string
+	url      *url.URL
+	// no dedicated URL for the language, ask for it with Accept-Language instead
+	negotiated bool
+	baseName   string
+}
+
+// Split comma-separated language list
+func parseLanguages(languages string) []string {
+	var parsed []string
+	for _, language := range strings.Split(languages, ",") {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if language != "" {
+			parsed = append(parsed, language)
+		}
+	}
+
+	return parsed
+}
+
+// hreflang alternates declared on the page: language -> absolute URL
+func findHreflangAlternates(pageBody []byte, from *url.URL) map[string]*url.URL {
+	var alternates map[string]*url.URL = make(map[string]*url.URL)
+
+	walkPageAttributes(pageBody, func(token *nethtml.Token, attribute *nethtml.Attribute) bool {
+		if token.Data != "link" || attribute.Key != "href" {
+			return false
+		}
+
+		var isAlternate bool = false
+		var hreflang string
+		for _, other := range token.Attr {
+			switch other.Key {
+			case "rel":
+				for _, rel := range strings.Fields(strings.ToLower(other.Val)) {
+					if rel == "alternate" {
+						isAlternate = true
+					}
+				}
+			case "hreflang":
+				hreflang = strings.ToLower(strings.TrimSpace(other.Val))
+			}
+		}
+		if !isAlternate || hreflang == "" {
+			return false
+		}
+
+		link, err := url.Parse(strings.TrimSpace(attribute.Val))
+		if err != nil {
+			return false
+		}
+		alternates[hreflang] = from.ResolveReference(link)
+
+		return false
+	})
+
+	return alternates
+}
+
+// Decide where each requested language version of the page comes from
+func findLanguageVariants(pageBody []byte, from *url.URL, baseName string, languages []string) []languageVariant {
+	alternates := findHreflangAlternates(pageBody, from)
+
+	var variants []languageVariant
+	for _, language := range languages {
+		variant := languageVariant{
+			language: language,
+			baseName: safeFileName(baseName+"."+language, maxPageBaseNameLength+len(language)+1),
+		}
+
+		alternate, ok := alternates[strings.ToLower(language)]
+		if ok {
+			variant.url = alternate
+		} else {
+			variant.url = from
+			variant.negotiated = true
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return variants
+}
+
+// Get the variant's page contents
+func (session *session) fetchLanguageVariant(variant *languageVariant) ([]byte, error) {
+	var headers http.Header = nil
+	if variant.negotiated {
+		headers = http.Header{"Accept-Language": {variant.language}}
+	}
+
+	response, err := session.fetchWithHeaders(variant.url.String(), headers)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageToUTF8(body, response.Header.Get("Content-Type")), nil
+}
+
+var bodyTagRegexp *regexp.Regexp = regexp.MustCompile(`(?i)<body(\s[^>]*)?>`)
+
+// Point hreflang alternates at saved language versions and add a visible switcher between them.
+// localFiles maps language (or "default" for the original page) to the saved file
+func addLanguageCrossLinks(pageBody []byte, current string, languages []string, localFiles map[string]string) []byte {
+	pageBody = walkPageAttributes(pageBody, func(token *nethtml.Token, attribute *nethtml.Attribute) bool {
+		if token.Data != "link" || attribute.Key != "href" {
+			return false
+		}
+
+		for _, other := range token.Attr {
+			if other.Key != "hreflang" {
+				continue
+			}
+
+			localFile, ok := localFiles[strings.ToLower(strings.TrimSpace(other.Val))]
+			if ok {
+				attribute.Val = localReference(localFile)
+				return true
+			}
+		}
+
+		return false
+	})
+
+	var switcher bytes.Buffer
+	switcher.WriteString(`<nav class="gospa-languages" style="font:14px sans-serif;padding:4px 8px;background:#eee">Saved languages: `)
+	for index, language := range append([]string{"default"}, languages...) {
+		if index > 0 {
+			switcher.WriteString(" | ")
+		}
+
+		if language == current {
+			fmt.Fprintf(&switcher, "<b>%s</b>", html.EscapeString(language))
+		} else {
+			fmt.Fprintf(&switcher, `<a href="%s">%s</a>`, html.EscapeString(localReference(localFiles[language])), html.EscapeString(language))
+		}
+	}
+	switcher.WriteString("</nav>")
+
+	return insertAtBodyStart(pageBody, switcher.Bytes())
+}
+
+// Put markup at the very top of the page's body
+func insertAtBodyStart(pageBody []byte, markup []byte) []byte {
+	location := bodyTagRegexp.FindIndex(pageBody)
+	if location == nil {
+		return append(markup, pageBody...)
+	}
+
+	var inserted []byte = make([]byte, 0, len(pageBody)+len(markup))
+	inserted = append(inserted, pageBody[:location[1]]...)
+	inserted = append(inserted, markup...)
+	inserted = append(inserted, pageBody[location[1]:]...)
+
+	return inserted
+}
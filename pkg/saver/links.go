@@ -0,0 +1,279 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Attributes of elements that reference page's file contents
+var assetAttributes map[string][]string = map[string][]string{
+	"img":    {"src"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"embed":  {"src"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"source": {"src"},
+	"track":  {"src"},
+	"input":  {"src"},
+}
+
+// <link rel="..."> values which point to files the page needs
+var assetLinkRels map[string]bool = map[string]bool{
+	"stylesheet":       true,
+	"icon":             true,
+	"apple-touch-icon": true,
+	"preload":          true,
+	"modulepreload":    true,
+}
+
+// Which page references count as files the page is made of
+type linkRules struct {
+	// <link rel=alternate> resources are too
+	alternates bool
+	// which srcset candidates to download
+	srcsetMode string
+}
+
+// Whether attribute of the element references a file the page is made of
+func (rules linkRules) isAssetAttribute(token *html.Token, key string) bool {
+	if token.Data == "link" {
+		if key != "href" {
+			return false
+		}
+
+		for _, attribute := range token.Attr {
+			if attribute.Key != "rel" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(attribute.Val)) {
+				if assetLinkRels[rel] || (rel == "alternate" && rules.alternates) {
+					return true
+				}
+			}
+		}
+
+		// no telling rel, judge by extension
+		for _, attribute := range token.Attr {
+			if attribute.Key == "href" {
+				switch strings.ToLower(path.Ext(strings.SplitN(attribute.Val, "?", 2)[0])) {
+				case ".css", ".scss", ".js", ".mjs":
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	for _, assetKey := range assetAttributes[token.Data] {
+		if key == assetKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether attribute of the element is a navigational link to another page
+func isNavigationAttribute(token *html.Token, key string) bool {
+	return (token.Data == "a" || token.Data == "area") && key == "href"
+}
+
+// Go through every attribute of every element on the page. visit may change the attribute's
+// value and must then return true; such elements are re-serialized, everything else is kept byte-for-byte
+func walkPageAttributes(pageBody []byte, visit func(token *html.Token, attribute *html.Attribute) bool) []byte {
+	var output bytes.Buffer
+	tokenizer := html.NewTokenizer(bytes.NewReader(pageBody))
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return pageBody
+			}
+			break
+		}
+
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			output.Write(tokenizer.Raw())
+			continue
+		}
+
+		raw := append([]byte(nil), tokenizer.Raw()...)
+		token := tokenizer.Token()
+
+		var changed bool = false
+		for index := range token.Attr {
+			if visit(&token, &token.Attr[index]) {
+				changed = true
+			}
+		}
+
+		if changed {
+			output.WriteString(token.String())
+		} else {
+			output.Write(raw)
+		}
+	}
+
+	return output.Bytes()
+}
+
+// Raw URL values the attribute references if it is one of interest
+type attributeValues func(token *html.Token, attribute *html.Attribute) []string
+
+// Attribute's whole value if matches accepts the attribute
+func singleValue(matches func(token *html.Token, key string) bool) attributeValues {
+	return func(token *html.Token, attribute *html.Attribute) []string {
+		if !matches(token, attribute.Key) {
+			return nil
+		}
+
+		return []string{attribute.Val}
+	}
+}
+
+// Same as values, but srcset candidates (chosen according to srcset mode) are included too
+func (rules linkRules) withSrcset(values attributeValues) attributeValues {
+	return func(token *html.Token, attribute *html.Attribute) []string {
+		if !isSrcsetAttribute(token, attribute.Key) {
+			return values(token, attribute)
+		}
+
+		var urls []string
+		for _, candidate := range selectSrcsetCandidates(parseSrcset(attribute.Val), rules.srcsetMode) {
+			urls = append(urls, candidate.URL)
+		}
+
+		return urls
+	}
+}
+
+// Collect URLs referenced by page's attributes
+func collectPageURLs(pageBody []byte, values attributeValues) []*url.URL {
+	var urls []*url.URL
+
+	walkPageAttributes(pageBody, func(token *html.Token, attribute *html.Attribute) bool {
+		for _, value := range values(token, attribute) {
+			value = strings.TrimSpace(value)
+			if value == "" || strings.HasPrefix(value, "#") || strings.HasPrefix(value, "data:") ||
+				strings.HasPrefix(strings.ToLower(value), "javascript:") {
+				continue
+			}
+
+			parsedURL, err := url.Parse(value)
+			if err != nil {
+				continue
+			}
+			urls = append(urls, parsedURL)
+		}
+
+		return false
+	})
+
+	return urls
+}
+
+// Find all links on page that are specified in <a> tag
+func findPageLinks(pageBody []byte) []*url.URL {
+	return collectPageURLs(pageBody, singleValue(isNavigationAttribute))
+}
+
+// Find links to the next page of a paginated thread or listing: <link rel="next"> and <a rel="next">
+func findNextPageLinks(pageBody []byte) []*url.URL {
+	return collectPageURLs(pageBody, singleValue(func(token *html.Token, key string) bool {
+		if (token.Data != "a" && token.Data != "link") || key != "href" {
+			return false
+		}
+
+		for _, attribute := range token.Attr {
+			if attribute.Key != "rel" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(attribute.Val)) {
+				if rel == "next" {
+					return true
+				}
+			}
+		}
+
+		return false
+	}))
+}
+
+// Find all links to files embedded by elements with src-like attributes (img, script, video, etc.)
+func (rules linkRules) findPageSrcLinks(pageBody []byte) []*url.URL {
+	return collectPageURLs(pageBody, rules.withSrcset(singleValue(func(token *html.Token, key string) bool {
+		return token.Data != "link" && rules.isAssetAttribute(token, key)
+	})))
+}
+
+// Find all links to files the page is made of: stylesheets, scripts, images, media
+func (rules linkRules) findPageFileContentURLs(pageBody []byte) []*url.URL {
+	return collectPageURLs(pageBody, rules.withSrcset(singleValue(rules.isAssetAttribute)))
+}
+
+// Local replacement for a raw URL value if there is one
+func lookupReplacement(value string, replacements map[string]string) (string, bool) {
+	parsedURL, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return "", false
+	}
+
+	replacement, ok := replacements[parsedURL.String()]
+	return replacement, ok
+}
+
+// Replace asset links on the page with ones from replacements, keyed by original URL
+func (rules linkRules) rewriteAssetLinks(pageBody []byte, replacements map[string]string) []byte {
+	return walkPageAttributes(pageBody, func(token *html.Token, attribute *html.Attribute) bool {
+		if isSrcsetAttribute(token, attribute.Key) {
+			var rewritten []srcsetCandidate
+			for _, candidate := range parseSrcset(attribute.Val) {
+				replacement, ok := lookupReplacement(candidate.URL, replacements)
+				if ok {
+					candidate.URL = replacement
+					rewritten = append(rewritten, candidate)
+				} else if rules.srcsetMode == SrcsetAll {
+					rewritten = append(rewritten, candidate)
+				}
+			}
+			if len(rewritten) == 0 {
+				return false
+			}
+			attribute.Val = serializeSrcset(rewritten)
+
+			return true
+		}
+
+		if !rules.isAssetAttribute(token, attribute.Key) {
+			return false
+		}
+
+		replacement, ok := lookupReplacement(attribute.Val, replacements)
+		if !ok {
+			return false
+		}
+		attribute.Val = replacement
+
+		return true
+	})
+}
@@ -0,0 +1,300 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// How to tell that logging in worked
+type loginCheck struct {
+	// "status", "redirect" or "selector"; empty accepts any response below 400
+	kind  string
+	value string
+}
+
+// Parse a login check: status:CODE, redirect:TEXT (the URL ended up at contains TEXT) or selector:SELECTOR
+// (the resulting page has a matching element, e.g. selector:a.logout)
+func parseLoginCheck(check string) (loginCheck, error) {
+	if strings.TrimSpace(check) == "" {
+		return loginCheck{}, nil
+	}
+
+	kind, value, found := strings.Cut(check, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	value = strings.TrimSpace(value)
+	if !found || value == "" {
+		return loginCheck{}, fmt.Errorf("expected status:CODE, redirect:TEXT or selector:SELECTOR")
+	}
+
+	switch kind {
+	case "status":
+		if _, err := strconv.Atoi(value); err != nil {
+			return loginCheck{}, fmt.Errorf("invalid status \"%s\"", value)
+		}
+	case "selector":
+		if _, _, _, err := parseSelector(value); err != nil {
+			return loginCheck{}, err
+		}
+	case "redirect":
+	default:
+		return loginCheck{}, fmt.Errorf("unknown check \"%s\"", kind)
+	}
+
+	return loginCheck{kind: kind, value: value}, nil
+}
+
+// Whether the response to the login form says logging in worked
+func (check loginCheck) passed(response *http.Response, body []byte) bool {
+	switch check.kind {
+	case "status":
+		return strconv.Itoa(response.StatusCode) == check.value
+	case "redirect":
+		return strings.Contains(response.Request.URL.String(), check.value)
+	case "selector":
+		document, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return false
+		}
+		tag, id, class, _ := parseSelector(check.value)
+		return findElement(document, tag, id, class) != nil
+	default:
+		return response.StatusCode < 400
+	}
+}
+
+// Login form of the page: the first form with a password field, or the first form if none has one
+func findLoginForm(document *html.Node) *html.Node {
+	var forms []*html.Node
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "form" {
+			forms = append(forms, node)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(document)
+
+	for _, form := range forms {
+		if hasPasswordField(form) {
+			return form
+		}
+	}
+	if len(forms) > 0 {
+		return forms[0]
+	}
+
+	return nil
+}
+
+// Whether there is a password input inside node
+func hasPasswordField(node *html.Node) bool {
+	if inputType, _ := getAttribute(node, "type"); node.Type == html.ElementNode && node.Data == "input" && strings.EqualFold(inputType, "password") {
+		return true
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if hasPasswordField(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Values the form would submit as it is: hidden fields such as CSRF tokens, prefilled and checked ones
+// and the first named submit button
+func formValues(form *html.Node) url.Values {
+	var values url.Values = make(url.Values)
+	var submitted bool = false
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			name, _ := getAttribute(node, "name")
+			value, _ := getAttribute(node, "value")
+			_, checked := getAttribute(node, "checked")
+			inputType, _ := getAttribute(node, "type")
+
+			switch {
+			case name == "":
+			case node.Data == "input":
+				switch strings.ToLower(inputType) {
+				case "submit", "image":
+					if !submitted {
+						values.Add(name, value)
+						submitted = true
+					}
+				case "button", "reset", "file":
+				case "checkbox", "radio":
+					if checked {
+						if value == "" {
+							value = "on"
+						}
+						values.Add(name, value)
+					}
+				default:
+					values.Add(name, value)
+				}
+			case node.Data == "button" && (inputType == "" || strings.EqualFold(inputType, "submit")):
+				if !submitted {
+					values.Add(name, value)
+					submitted = true
+				}
+			case node.Data == "textarea":
+				values.Add(name, rawText(node))
+			case node.Data == "select":
+				var selected string
+				var first bool = true
+				for option := node.FirstChild; option != nil; option = option.NextSibling {
+					if option.Type != html.ElementNode || option.Data != "option" {
+						continue
+					}
+					optionValue, ok := getAttribute(option, "value")
+					if !ok {
+						optionValue = nodeText(option)
+					}
+					if _, isSelected := getAttribute(option, "selected"); isSelected || first {
+						selected = optionValue
+						first = false
+					}
+				}
+				values.Add(name, selected)
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(form)
+
+	return values
+}
+
+// One step of logging in: a form filled in and submitted
+type loginStep struct {
+	// page with the form; the page the previous step ended up at if empty
+	url    string
+	fields url.Values
+	check  loginCheck
+}
+
+// Fill in the login form with the configured fields and submit it, keeping session cookies in the jar
+func (session *session) logIn() error {
+	return session.runLoginSteps([]loginStep{{
+		url:    session.options.LoginURL,
+		fields: session.options.LoginFields,
+		check:  session.loginCheck,
+	}})
+}
+
+// Go through the steps of logging in one after another, keeping session cookies in the jar
+func (session *session) runLoginSteps(steps []loginStep) error {
+	var page []byte
+	var pageURL *url.URL
+	for index, step := range steps {
+		var err error
+		page, pageURL, err = session.submitLoginStep(step, page, pageURL)
+		if err != nil && len(steps) > 1 {
+			return fmt.Errorf("step %d: %s", index+1, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fill in the login form of the step's page, or of page from pageURL if it has none, and submit it.
+// Returns the page it ended up at
+func (session *session) submitLoginStep(step loginStep, page []byte, pageURL *url.URL) ([]byte, *url.URL, error) {
+	if step.url != "" {
+		response, err := session.fetch(step.url)
+		if err != nil {
+			return nil, nil, err
+		}
+		page, err = io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if response.StatusCode >= 400 {
+			return nil, nil, fmt.Errorf("login page responded with %s", response.Status)
+		}
+		pageURL = response.Request.URL
+	}
+
+	document, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, nil, err
+	}
+	form := findLoginForm(document)
+	if form == nil {
+		return nil, nil, fmt.Errorf("no form on the login page")
+	}
+
+	values := formValues(form)
+	for name, fieldValues := range step.fields {
+		values[name] = fieldValues
+	}
+
+	action, _ := getAttribute(form, "action")
+	actionURL, err := url.Parse(strings.TrimSpace(action))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid form action: %s", err)
+	}
+	actionURL = pageURL.ResolveReference(actionURL)
+
+	var request *http.Request
+	if method, _ := getAttribute(form, "method"); strings.EqualFold(method, http.MethodGet) {
+		actionURL.RawQuery = values.Encode()
+		request, err = http.NewRequestWithContext(session.ctx, http.MethodGet, actionURL.String(), nil)
+	} else {
+		request, err = http.NewRequestWithContext(session.ctx, http.MethodPost, actionURL.String(), strings.NewReader(values.Encode()))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			request.Header.Set("Referer", pageURL.String())
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// not retried: submitting a form twice may do harm
+	response, err := session.fetchOnce(request, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !step.check.passed(response, result) {
+		return nil, nil, fmt.Errorf("login check failed (ended up at %s with %s)", response.Request.URL.String(), response.Status)
+	}
+
+	return result, response.Request.URL, nil
+}
@@ -0,0 +1,410 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose contents never make it into Markdown
+var markdownSkippedElements map[string]bool = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true, "template": true,
+	"iframe": true, "svg": true, "canvas": true, "button": true, "input": true,
+	"select": true, "textarea": true, "form": true,
+}
+
+// Characters that would otherwise be taken for Markdown syntax
+var markdownEscaper *strings.Replacer = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`,
+)
+
+var (
+	markdownWhitespaceRegexp *regexp.Regexp = regexp.MustCompile(`\s+`)
+	markdownBlankLinesRegexp *regexp.Regexp = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+\n`)
+	markdownListBreaksRegexp *regexp.Regexp = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+)
+
+// Converts a saved page into Markdown. Readability matters more than fidelity
+type markdownConverter struct {
+	from     *url.URL
+	filesDir string
+	// what the leading "./" of references to files is replaced with, so they lead to where the files end up
+	filesPrefix string
+	// files from the files directory the Markdown refers to
+	usedFiles map[string]bool
+}
+
+// Where a link or image should point in Markdown
+func (converter *markdownConverter) target(reference string) string {
+	reference = strings.TrimSpace(reference)
+	if reference == "" || strings.HasPrefix(reference, "#") {
+		return reference
+	}
+
+	if strings.HasPrefix(reference, localReference(converter.filesDir)+"/") {
+		filePath, err := url.PathUnescape(strings.TrimPrefix(reference, "./"))
+		if err == nil {
+			converter.usedFiles[filePath] = true
+		}
+		return converter.filesPrefix + strings.TrimPrefix(reference, "./")
+	}
+
+	if strings.HasPrefix(reference, "./") && strings.HasSuffix(strings.SplitN(reference, "#", 2)[0], ".md") {
+		// another saved page
+		return reference
+	}
+
+	link, err := url.Parse(reference)
+	if err != nil {
+		return reference
+	}
+
+	return converter.from.ResolveReference(link).String()
+}
+
+// Text of the node with all whitespace kept
+func rawText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(rawText(child))
+	}
+
+	return text.String()
+}
+
+// Indent every line but the first
+func indentFollowingLines(text string, indent string) string {
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
+
+// Markdown of node's children
+func (converter *markdownConverter) children(node *html.Node) string {
+	var markdown strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		markdown.WriteString(converter.convert(child))
+	}
+
+	return markdown.String()
+}
+
+// Markdown of the node itself
+func (converter *markdownConverter) convert(node *html.Node) string {
+	switch node.Type {
+	case html.TextNode:
+		return markdownEscaper.Replace(markdownWhitespaceRegexp.ReplaceAllString(node.Data, " "))
+	case html.DocumentNode:
+		return converter.children(node)
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	if markdownSkippedElements[node.Data] {
+		return ""
+	}
+
+	switch node.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(node.Data[1] - '0')
+		return "\n\n" + strings.Repeat("#", level) + " " + strings.TrimSpace(converter.children(node)) + "\n\n"
+
+	case "p", "div", "section", "article", "main", "header", "footer", "aside", "nav", "figure", "dl":
+		return "\n\n" + strings.TrimSpace(converter.children(node)) + "\n\n"
+
+	case "dt", "dd", "figcaption", "caption":
+		return "\n" + strings.TrimSpace(converter.children(node)) + "\n"
+
+	case "table":
+		return "\n\n" + converter.table(node) + "\n\n"
+
+	case "br":
+		return "  \n"
+
+	case "hr":
+		return "\n\n---\n\n"
+
+	case "strong", "b":
+		return emphasize(converter.children(node), "**")
+
+	case "em", "i":
+		return emphasize(converter.children(node), "*")
+
+	case "code", "kbd", "samp":
+		text := rawText(node)
+		if strings.Contains(text, "`") {
+			return "`` " + text + " ``"
+		}
+		return "`" + text + "`"
+
+	case "pre":
+		return "\n\n```\n" + strings.Trim(rawText(node), "\n") + "\n```\n\n"
+
+	case "blockquote":
+		text := strings.TrimSpace(converter.children(node))
+		text = markdownBlankLinesRegexp.ReplaceAllString(text, "\n\n")
+		return "\n\n> " + strings.ReplaceAll(text, "\n", "\n> ") + "\n\n"
+
+	case "ul", "ol":
+		var items strings.Builder
+		var number int = 1
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || child.Data != "li" {
+				continue
+			}
+
+			var marker string = "- "
+			if node.Data == "ol" {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+
+			text := strings.TrimSpace(converter.children(child))
+			text = markdownListBreaksRegexp.ReplaceAllString(text, "\n")
+			items.WriteString(marker + indentFollowingLines(text, strings.Repeat(" ", len(marker))) + "\n")
+		}
+		return "\n\n" + items.String() + "\n"
+
+	case "a":
+		text := strings.TrimSpace(converter.children(node))
+		href, _ := getAttribute(node, "href")
+		href = converter.target(href)
+		if href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return text
+		}
+		if text == "" {
+			text = markdownEscaper.Replace(href)
+		}
+		return "[" + text + "](" + markdownLinkTarget(href) + ")"
+
+	case "img":
+		src, _ := getAttribute(node, "src")
+		if src == "" {
+			return ""
+		}
+		alt, _ := getAttribute(node, "alt")
+		alt = markdownEscaper.Replace(strings.TrimSpace(alt))
+		return "![" + alt + "](" + markdownLinkTarget(converter.target(src)) + ")"
+	}
+
+	return converter.children(node)
+}
+
+// Wrap text into emphasis markers, keeping surrounding whitespace outside of them
+func emphasize(text string, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+
+	return leading + marker + trimmed + marker + trailing
+}
+
+// Table as a pipe table, first row being the header
+func (converter *markdownConverter) table(node *html.Node) string {
+	var rows [][]string
+	var collect func(node *html.Node)
+	collect = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+
+			switch child.Data {
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			case "tr":
+				var row []string
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						text := markdownListBreaksRegexp.ReplaceAllString(strings.TrimSpace(converter.children(cell)), " ")
+						row = append(row, strings.ReplaceAll(strings.ReplaceAll(text, "\n", " "), "|", `\|`))
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	collect(node)
+
+	var columns int = 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	var table strings.Builder
+	for index, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		table.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if index == 0 {
+			table.WriteString(strings.Repeat("| --- ", columns) + "|\n")
+		}
+	}
+
+	return table.String()
+}
+
+// Link destination that survives spaces and parentheses
+func markdownLinkTarget(target string) string {
+	if strings.ContainsAny(target, " ()") {
+		return "<" + target + ">"
+	}
+
+	return target
+}
+
+// Convert saved page into Markdown, with references to files from filesDir starting with filesPrefix
+// instead of "./". Returns it together with the files it uses
+func pageToMarkdown(pageBody []byte, from *url.URL, filesDir string, filesPrefix string) (string, map[string]bool, error) {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return "", nil, err
+	}
+
+	converter := &markdownConverter{
+		from:        from,
+		filesDir:    filesDir,
+		filesPrefix: filesPrefix,
+		usedFiles:   make(map[string]bool),
+	}
+
+	markdown := converter.convert(document)
+
+	var lines []string
+	var inCodeBlock bool = false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCodeBlock = !inCodeBlock
+		}
+
+		switch {
+		case inCodeBlock:
+		case strings.TrimSpace(line) == "":
+			line = ""
+		default:
+			if !strings.HasSuffix(line, "  ") {
+				line = strings.TrimRight(line, " \t")
+			}
+			if strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "  ") {
+				// stray space left from whitespace between elements; deeper indentation is nesting
+				line = line[1:]
+			}
+		}
+		lines = append(lines, line)
+	}
+	markdown = markdownBlankLinesRegexp.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	title := extractMetadata(pageBody).Title
+	markdown = strings.TrimSpace(markdown)
+	if title != "" && !strings.HasPrefix(markdown, "# ") {
+		markdown = "# " + markdownEscaper.Replace(title) + "\n\n" + markdown
+	}
+
+	return markdown + "\n", converter.usedFiles, nil
+}
+
+// Save page as a Markdown file with the images it shows next to it
+func (session *session) saveMarkdownPage(pageBody []byte, out output, from *url.URL, baseName string) (*PageReport, error) {
+	return session.saveMarkdown(pageBody, out, from, baseName, baseName+".md", "", "")
+}
+
+// Save page as a Markdown file at notePath, starting with header, with the images it shows in attachmentsDir
+func (session *session) saveMarkdown(pageBody []byte, out output, from *url.URL, baseName string, notePath string, attachmentsDir string, header string) (*PageReport, error) {
+	memory := newMemoryOutput(session.options.MemoryThreshold)
+	defer memory.Close()
+	report, err := session.savePage(pageBody, memory, from, baseName)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := memory.read(report.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// attachments as seen from the note: "." when they are next to it
+	attachmentsFromNote, err := filepath.Rel(path.Dir(notePath), path.Join(".", attachmentsDir))
+	if err != nil {
+		return nil, err
+	}
+	markdown, usedFiles, err := pageToMarkdown(page, from, baseName+"_files", escapePath(filepath.ToSlash(attachmentsFromNote))+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var writeFile = func(filePath string, contents []byte) error {
+		outfile, err := out.Create(filePath)
+		if err != nil {
+			return err
+		}
+		defer outfile.Close()
+
+		_, err = outfile.Write(contents)
+		return err
+	}
+
+	report.OutputPath = notePath
+	err = writeFile(report.OutputPath, []byte(header+markdown))
+	if err != nil {
+		return nil, err
+	}
+	report.Size = int64(len(header) + len(markdown))
+
+	for filePath := range usedFiles {
+		if memory.size(filePath) < 0 {
+			continue
+		}
+
+		_, err = memory.copyTo(out, filePath, path.Join(attachmentsDir, filePath))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for index := range report.Assets {
+		asset := &report.Assets[index]
+		if asset.Status != AssetSaved {
+			continue
+		}
+		if !usedFiles[asset.LocalPath] {
+			asset.Status = AssetSkipped
+			asset.Reason = "not used by Markdown"
+			asset.Size = 0
+			continue
+		}
+		asset.LocalPath = path.Join(attachmentsDir, asset.LocalPath)
+	}
+
+	return report, nil
+}
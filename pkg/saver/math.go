@@ -0,0 +1,64 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// <script> element with its attributes and code
+var mathScriptRegexp *regexp.Regexp = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script>`)
+
+// What marks a script element as typesetting math: its src, type or code
+var mathScriptMarkers *regexp.Regexp = regexp.MustCompile(`(?i)mathjax|katex|renderMathInElement`)
+
+// Elements MathJax (2 and 3) and KaTeX put typeset formulas into
+var typesetMathMarkers [][]byte = [][]byte{
+	[]byte("<mjx-container"), []byte(`class="MathJax`), []byte(`class="katex`),
+}
+
+// Whether the page typesets formulas with MathJax or KaTeX when it is opened
+func usesMathTypesetting(pageBody []byte) bool {
+	for _, match := range mathScriptRegexp.FindAllSubmatch(pageBody, -1) {
+		if mathScriptMarkers.Match(match[1]) || mathScriptMarkers.Match(match[2]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether the page holds formulas typeset by MathJax or KaTeX
+func hasTypesetMath(pageBody []byte) bool {
+	for _, marker := range typesetMathMarkers {
+		if bytes.Contains(pageBody, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Remove MathJax and KaTeX scripts and their configuration from a page with formulas typeset already,
+// which would otherwise try to load their parts from the network and typeset them once more.
+// Their stylesheets stay, typeset formulas need them
+func dropMathScripts(pageBody []byte) []byte {
+	return mathScriptRegexp.ReplaceAllFunc(pageBody, func(element []byte) []byte {
+		match := mathScriptRegexp.FindSubmatch(element)
+		if mathScriptMarkers.Match(match[1]) || mathScriptMarkers.Match(match[2]) {
+			return nil
+		}
+		return element
+	})
+}
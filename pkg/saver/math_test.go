@@ -0,0 +1,44 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDropMathScripts(t *testing.T) {
+	page := `<html><head>` +
+		`<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/katex/dist/katex.min.css">` +
+		`<script src="https://cdn.jsdelivr.net/npm/katex/dist/katex.min.js"></script>` +
+		`<script>window.MathJax = {tex: {inlineMath: [["$", "$"]]}};</script>` +
+		`<script type="text/x-mathjax-config">MathJax.Hub.Config({});</script>` +
+		`<script>document.addEventListener("DOMContentLoaded", () => renderMathInElement(document.body));</script>` +
+		`<script src="./app.js"></script>` +
+		`</head><body><span class="katex">x</span></body></html>`
+
+	if !usesMathTypesetting([]byte(page)) || !hasTypesetMath([]byte(page)) {
+		t.Fatal("math typesetting is not recognized")
+	}
+
+	dropped := string(dropMathScripts([]byte(page)))
+	if strings.Count(dropped, "<script") != 1 || !strings.Contains(dropped, `<script src="./app.js"></script>`) {
+		t.Errorf("expected only the page's own script to stay, got\n%s", dropped)
+	}
+	if !strings.Contains(dropped, "katex.min.css") {
+		t.Errorf("stylesheet of typeset formulas is gone:\n%s", dropped)
+	}
+	if usesMathTypesetting([]byte(dropped)) {
+		t.Errorf("math typesetting is still there:\n%s", dropped)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Media types of extensions that the standard library, and systems without
+// a mime.types file, do not know. Registered unless the system knows better
+var builtinMediaTypes map[string]string = map[string]string{
+	".apng":        "image/apng",
+	".jxl":         "image/jxl",
+	".heic":        "image/heic",
+	".ico":         "image/x-icon",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".mp3":         "audio/mpeg",
+	".ogg":         "audio/ogg",
+	".opus":        "audio/opus",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+	".m3u8":        "application/vnd.apple.mpegurl",
+	".wasm":        "application/wasm",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+}
+
+func init() {
+	for extension, mediaType := range builtinMediaTypes {
+		if mime.TypeByExtension(extension) == "" {
+			mime.AddExtensionType(extension, mediaType)
+		}
+	}
+}
+
+// Extensions files of a media type get, as set in a media types file, overriding preferredExtensions
+var customExtensions sync.Map
+
+// A media type as written in the media types file
+type mediaTypeEntry struct {
+	Type       string   `yaml:"type"`
+	Extensions []string `yaml:"extensions"`
+}
+
+type mediaTypesConfig struct {
+	Types []mediaTypeEntry `yaml:"types"`
+}
+
+// Read extension to media type mappings from a YAML file and use them from now on, in place
+// of what the system says. The first extension of a type is the one its files are named with
+func loadMediaTypes(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config mediaTypesConfig
+	err = yaml.Unmarshal(contents, &config)
+	if err != nil {
+		return fmt.Errorf("failed to parse media types: %s", err)
+	}
+
+	for index, entry := range config.Types {
+		mediaType, _, err := mime.ParseMediaType(entry.Type)
+		if err != nil {
+			return fmt.Errorf("type %d has invalid media type \"%s\"", index, entry.Type)
+		}
+		if len(entry.Extensions) == 0 {
+			return fmt.Errorf("type %d (%s) has no extensions", index, mediaType)
+		}
+
+		for _, extension := range entry.Extensions {
+			err = mime.AddExtensionType(normalizeExtension(extension), entry.Type)
+			if err != nil {
+				return fmt.Errorf("type %d (%s): %s", index, mediaType, err)
+			}
+		}
+		customExtensions.Store(mediaType, normalizeExtension(entry.Extensions[0]))
+	}
+
+	return nil
+}
+
+// Extension in lower case with its leading dot, however it was written
+func normalizeExtension(extension string) string {
+	extension = strings.ToLower(strings.TrimSpace(extension))
+	if !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+
+	return extension
+}
@@ -0,0 +1,599 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Name of the page listing every page of a merged collection
+const mergedIndexName string = "index.html"
+
+// Marks index pages written by Merge, so that merging merged collections does not list old indexes
+const mergedIndexGenerator string = `<meta name="generator" content="gospa merge">`
+
+// Extensions of saved pages, which own the files directory named after them
+var mergedPageExtensions []string = []string{".html", ".md", ".mht", ".epub"}
+
+// A file of a saved collection, read from source when written
+type savedFile struct {
+	// file the contents are in
+	source string
+	// whether source is a copy of the merge's own, which may be moved into the collection
+	staged bool
+	size   int64
+	hash   [sha256.Size]byte
+}
+
+// Copy reader into writer, returning the hash of what has been copied
+func copyHashing(writer io.Writer, reader io.Reader) (int64, [sha256.Size]byte, error) {
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(writer, hasher), reader)
+
+	var hash [sha256.Size]byte
+	copy(hash[:], hasher.Sum(nil))
+	return size, hash, err
+}
+
+// Hash contents of the file at filePath
+func hashFile(filePath string) (int64, [sha256.Size]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, [sha256.Size]byte{}, err
+	}
+	defer file.Close()
+
+	return copyHashing(io.Discard, file)
+}
+
+// Whether the file at filePath is an index page written by Merge
+func isMergedIndex(filePath string) (bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// the marker comes right after the charset
+	head, err := io.ReadAll(io.LimitReader(file, 1024))
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Contains(head, []byte(mergedIndexGenerator)), nil
+}
+
+// Find every file of a saved page directory or a .tar archive: slash-separated relative path -> file.
+// Archive entries are streamed into stagingDir
+func readSavedFiles(inputPath string, stagingDir string) (map[string]savedFile, error) {
+	var files map[string]savedFile = make(map[string]savedFile)
+
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.IsDir():
+		err = filepath.WalkDir(inputPath, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(inputPath, filePath)
+			if err != nil {
+				return err
+			}
+
+			size, hash, err := hashFile(filePath)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(relPath)] = savedFile{source: filePath, size: size, hash: hash}
+
+			return nil
+		})
+
+	case strings.HasSuffix(inputPath, ".age"):
+		return nil, fmt.Errorf("encrypted archives must be decrypted before merging")
+
+	case strings.HasSuffix(inputPath, ".tar"):
+		file, err := os.Open(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		tarReader := tar.NewReader(file)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag != tar.TypeReg {
+				// links included, so that none can point outside the collection
+				continue
+			}
+			name := path.Clean(header.Name)
+			if !isLocalPath(name) {
+				return nil, fmt.Errorf("unsafe path \"%s\" in archive", header.Name)
+			}
+
+			staged, err := stageFile(stagingDir, tarReader)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = staged
+		}
+
+	default:
+		return nil, fmt.Errorf("not a saved page directory or a .tar archive")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// indexes of previously merged collections are regenerated, not merged
+	if index, ok := files[mergedIndexName]; ok {
+		merged, err := isMergedIndex(index.source)
+		if err != nil {
+			return nil, err
+		}
+		if merged {
+			delete(files, mergedIndexName)
+		}
+	}
+
+	return files, nil
+}
+
+// Stream contents into a new file in stagingDir
+func stageFile(stagingDir string, contents io.Reader) (savedFile, error) {
+	file, err := os.CreateTemp(stagingDir, "file-*")
+	if err != nil {
+		return savedFile{}, err
+	}
+	defer file.Close()
+
+	size, hash, err := copyHashing(file, contents)
+	if err != nil {
+		return savedFile{}, err
+	}
+
+	return savedFile{source: file.Name(), staged: true, size: size, hash: hash}, file.Close()
+}
+
+// Error if relPath inside root, or a directory on the way to it, is a symbolic link already,
+// which would lead writing there somewhere else
+func checkNoSymlinks(root string, relPath string) error {
+	current := root
+	for _, element := range strings.Split(relPath, "/") {
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write through symbolic link %s", current)
+		}
+	}
+
+	return nil
+}
+
+// Page base name if the top-level file is a saved page
+func savedPageBaseName(name string) (string, bool) {
+	for _, extension := range mergedPageExtensions {
+		if strings.HasSuffix(name, extension) {
+			return strings.TrimSuffix(name, extension), true
+		}
+	}
+
+	return "", false
+}
+
+// Files that have to stay together: a page with its files directory, or a file on its own
+type mergeUnit struct {
+	// top-level file the unit is named after
+	name string
+	// relative path -> file
+	files map[string]savedFile
+}
+
+// Group saved files into units
+func groupSavedFiles(files map[string]savedFile) []mergeUnit {
+	var units map[string]*mergeUnit = make(map[string]*mergeUnit)
+	var filesDirs map[string]string = make(map[string]string)
+
+	for relPath := range files {
+		if strings.Contains(relPath, "/") {
+			continue
+		}
+
+		units[relPath] = &mergeUnit{name: relPath, files: map[string]savedFile{relPath: files[relPath]}}
+		if baseName, ok := savedPageBaseName(relPath); ok {
+			filesDirs[baseName+"_files"] = relPath
+		}
+	}
+
+	for relPath, file := range files {
+		if !strings.Contains(relPath, "/") {
+			continue
+		}
+
+		page, ok := filesDirs[strings.SplitN(relPath, "/", 2)[0]]
+		if !ok {
+			// files of a page that is not there
+			units[relPath] = &mergeUnit{name: relPath, files: map[string]savedFile{relPath: file}}
+			continue
+		}
+		units[page].files[relPath] = file
+	}
+
+	var names []string
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var grouped []mergeUnit
+	for _, name := range names {
+		grouped = append(grouped, *units[name])
+	}
+
+	return grouped
+}
+
+// Same unit under the page name with a number added, its files directory renamed and referenced accordingly.
+// The rewritten page is put into stagingDir
+func renumberUnit(unit mergeUnit, number int, stagingDir string) (mergeUnit, error) {
+	baseName, isPage := savedPageBaseName(unit.name)
+	if !isPage {
+		extension := path.Ext(unit.name)
+		renamed := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(unit.name, extension), number, extension)
+		return mergeUnit{name: renamed, files: map[string]savedFile{renamed: unit.files[unit.name]}}, nil
+	}
+
+	newBaseName := fmt.Sprintf("%s.%d", baseName, number)
+	oldFilesDir := baseName + "_files/"
+	newFilesDir := newBaseName + "_files/"
+
+	var renamed mergeUnit = mergeUnit{
+		name:  newBaseName + strings.TrimPrefix(unit.name, baseName),
+		files: make(map[string]savedFile),
+	}
+	for relPath, file := range unit.files {
+		if relPath != unit.name {
+			renamed.files[newFilesDir+strings.TrimPrefix(relPath, oldFilesDir)] = file
+			continue
+		}
+
+		// only the page itself is read whole, to point it at the renamed directory
+		contents, err := os.ReadFile(file.source)
+		if err != nil {
+			return unit, err
+		}
+		contents = bytes.ReplaceAll(contents, []byte(localReference(oldFilesDir)), []byte(localReference(newFilesDir)))
+
+		renamed.files[renamed.name], err = stageFile(stagingDir, bytes.NewReader(contents))
+		if err != nil {
+			return unit, err
+		}
+	}
+
+	return renamed, nil
+}
+
+// What Merge did
+type MergeResult struct {
+	// Pages of the combined collection, relative to its directory
+	Pages []string
+	// Files that were already there with the same contents, or were hard-linked to an identical file
+	Deduplicated int
+	// Bytes not written thanks to that
+	SavedBytes int64
+}
+
+// Combine saved page directories and .tar archives into one collection in outputDir and write an
+// index.html linking to every page in it, including those listed by an index of an earlier merge there.
+// Identical files are stored once: exact duplicates are skipped and the same contents under other names
+// are hard-linked. Pages saved under the same name with different contents, here or already in outputDir,
+// are kept side by side with a number added to the name; nothing there is overwritten
+func Merge(outputDir string, inputs []string) (*MergeResult, error) {
+	err := prepareOutputDir(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("output directory %s is not usable: %s", outputDir, err)
+	}
+
+	previousPages, err := readMergedIndex(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index of %s: %s", outputDir, err)
+	}
+
+	stagingDir, err := os.MkdirTemp(outputDir, ".gospa-merge-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	var result MergeResult
+	// relative path -> hash of what is there, written now or found in outputDir
+	var written map[string][sha256.Size]byte = make(map[string][sha256.Size]byte)
+	// contents hash -> relative path it is stored at
+	var stored map[[sha256.Size]byte]string = make(map[[sha256.Size]byte]string)
+	var pages map[string]bool = make(map[string]bool)
+
+	// hash of what is at relPath already, if anything
+	var existing = func(relPath string) ([sha256.Size]byte, bool, error) {
+		if hash, ok := written[relPath]; ok {
+			return hash, true, nil
+		}
+
+		filePath, err := joinOutputPath(outputDir, relPath)
+		if err == nil {
+			err = checkNoSymlinks(outputDir, relPath)
+		}
+		if err != nil {
+			return [sha256.Size]byte{}, false, err
+		}
+
+		info, err := os.Lstat(filePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return [sha256.Size]byte{}, false, nil
+		}
+		if err != nil {
+			return [sha256.Size]byte{}, false, err
+		}
+		if !info.Mode().IsRegular() {
+			// a directory or something else that no file can be deduplicated against
+			return [sha256.Size]byte{}, true, nil
+		}
+
+		_, hash, err := hashFile(filePath)
+		if err != nil {
+			return hash, false, err
+		}
+		written[relPath] = hash
+		if _, ok := stored[hash]; !ok {
+			stored[hash] = relPath
+		}
+
+		return hash, true, nil
+	}
+
+	// whether the unit may be written as is: every file is either new or already there with the same contents
+	var fits = func(unit mergeUnit) (bool, error) {
+		if unit.name == mergedIndexName {
+			return false, nil
+		}
+
+		for relPath, file := range unit.files {
+			hash, ok, err := existing(relPath)
+			if err != nil {
+				return false, err
+			}
+			if ok && hash != file.hash {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	for _, input := range inputs {
+		files, err := readSavedFiles(input, stagingDir)
+		if err != nil {
+			return &result, fmt.Errorf("failed to read %s: %s", input, err)
+		}
+
+		for _, unit := range groupSavedFiles(files) {
+			for number := 2; ; number++ {
+				ok, err := fits(unit)
+				if err != nil {
+					return &result, err
+				}
+				if ok {
+					break
+				}
+
+				unit, err = renumberUnit(unit, number, stagingDir)
+				if err != nil {
+					return &result, err
+				}
+			}
+
+			for relPath, file := range unit.files {
+				if _, ok := written[relPath]; ok {
+					result.Deduplicated++
+					result.SavedBytes += file.size
+					continue
+				}
+
+				filePath, _ := joinOutputPath(outputDir, relPath)
+				err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+				if err != nil {
+					return &result, err
+				}
+
+				same, ok := stored[file.hash]
+				if ok && os.Link(filepath.Join(outputDir, filepath.FromSlash(same)), filePath) == nil {
+					result.Deduplicated++
+					result.SavedBytes += file.size
+				} else {
+					err = placeFile(file, filePath)
+					if err != nil {
+						return &result, err
+					}
+					if !ok {
+						stored[file.hash] = relPath
+					}
+				}
+				written[relPath] = file.hash
+			}
+
+			if _, isPage := savedPageBaseName(unit.name); isPage && !pages[unit.name] {
+				pages[unit.name] = true
+				result.Pages = append(result.Pages, unit.name)
+			}
+		}
+	}
+	sort.Strings(result.Pages)
+
+	var listed []string = result.Pages
+	for _, page := range previousPages {
+		if !pages[page] {
+			listed = append(listed, page)
+		}
+	}
+	sort.Strings(listed)
+
+	err = writeMergedIndex(outputDir, listed)
+	if err != nil {
+		return &result, fmt.Errorf("failed to write index: %s", err)
+	}
+
+	return &result, nil
+}
+
+// Put file at filePath, which must not exist yet
+func placeFile(file savedFile, filePath string) error {
+	if file.staged && os.Link(file.source, filePath) == nil {
+		return nil
+	}
+
+	source, err := os.Open(file.source)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		destination.Close()
+		os.Remove(filePath)
+		return err
+	}
+
+	return destination.Close()
+}
+
+// Pages listed by the index of an earlier merge into outputDir that are still there. An index.html
+// written by something else is refused, as the new index would replace it
+func readMergedIndex(outputDir string) ([]string, error) {
+	indexPath := filepath.Join(outputDir, mergedIndexName)
+	merged, err := isMergedIndex(indexPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !merged {
+		return nil, fmt.Errorf("%s has not been written by merge", mergedIndexName)
+	}
+
+	index, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+
+	var pages []string
+	tokenizer := html.NewTokenizer(index)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() == io.EOF {
+				return pages, nil
+			}
+			return nil, tokenizer.Err()
+		}
+		if tokenType != html.StartTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+		if token.Data != "a" {
+			continue
+		}
+		for _, attribute := range token.Attr {
+			if attribute.Key != "href" {
+				continue
+			}
+
+			link, err := url.Parse(attribute.Val)
+			if err != nil {
+				continue
+			}
+			page := strings.TrimPrefix(link.Path, "./")
+			if _, isPage := savedPageBaseName(page); !isPage || !isLocalPath(page) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(page))); err == nil {
+				pages = append(pages, page)
+			}
+		}
+	}
+}
+
+// Write index page linking every page of the collection, titled where the title is known
+func writeMergedIndex(outputDir string, pages []string) error {
+	var index bytes.Buffer
+	index.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n" + mergedIndexGenerator + "\n<title>Saved pages</title>\n</head>\n<body>\n<h1>Saved pages</h1>\n<ul>\n")
+
+	for _, page := range pages {
+		var title string = page
+		if strings.HasSuffix(page, ".html") {
+			contents, err := os.ReadFile(filepath.Join(outputDir, page))
+			if err == nil {
+				if pageTitle := extractMetadata(contents).Title; pageTitle != "" {
+					title = pageTitle
+				}
+			}
+		}
+
+		var link url.URL = url.URL{Path: "./" + page}
+		fmt.Fprintf(&index, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link.String()), html.EscapeString(title))
+	}
+	index.WriteString("</ul>\n</body>\n</html>\n")
+
+	return os.WriteFile(filepath.Join(outputDir, mergedIndexName), index.Bytes(), 0644)
+}
@@ -0,0 +1,304 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"archive/tar"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Entry of a test archive
+type testTarEntry struct {
+	name     string
+	contents string
+	// tar.TypeReg unless set
+	typeflag byte
+	linkname string
+}
+
+func writeTestTar(t *testing.T, archivePath string, entries []testTarEntry) {
+	t.Helper()
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tarWriter := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.contents)),
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+		}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		} else {
+			header.Size = 0
+		}
+
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			_, err = tarWriter.Write([]byte(entry.contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeMaliciousArchives(t *testing.T) {
+	var tests []struct {
+		name    string
+		entries []testTarEntry
+		// called with the merge root and a directory outside of it before merging
+		prepare func(t *testing.T, root string, outside string)
+		wantErr bool
+	} = []struct {
+		name    string
+		entries []testTarEntry
+		prepare func(t *testing.T, root string, outside string)
+		wantErr bool
+	}{
+		{
+			name: "dot-dot entries",
+			entries: []testTarEntry{
+				{name: "page.html", contents: "<html></html>"},
+				{name: "../outside/evil.txt", contents: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dot-dot inside files directory",
+			entries: []testTarEntry{
+				{name: "page.html", contents: "<html></html>"},
+				{name: "page_files/../../outside/evil.txt", contents: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "absolute entries",
+			entries: []testTarEntry{
+				{name: "/outside/evil.txt", contents: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "drive letter entries",
+			entries: []testTarEntry{
+				{name: "C:/outside/evil.txt", contents: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "backslash entries",
+			entries: []testTarEntry{
+				{name: `..\outside\evil.txt`, contents: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink entry followed by a file through it",
+			entries: []testTarEntry{
+				{name: "page.html", contents: "<html></html>"},
+				{name: "page_files", typeflag: tar.TypeSymlink, linkname: "../outside"},
+				{name: "page_files/evil.txt", contents: "evil"},
+			},
+		},
+		{
+			name: "hardlink entry",
+			entries: []testTarEntry{
+				{name: "page.html", contents: "<html></html>"},
+				{name: "page_files/evil.txt", typeflag: tar.TypeLink, linkname: "../outside/secret.txt"},
+			},
+		},
+		{
+			name: "output directory reached through a symlink",
+			entries: []testTarEntry{
+				{name: "page.html", contents: "<html></html>"},
+				{name: "page_files/evil.txt", contents: "evil"},
+			},
+			prepare: func(t *testing.T, root string, outside string) {
+				err := os.MkdirAll(root, os.ModePerm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.Symlink(outside, filepath.Join(root, "page_files"))
+				if err != nil {
+					t.Skipf("cannot create symbolic links: %s", err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			root := filepath.Join(base, "root")
+			outside := filepath.Join(base, "outside")
+			err := os.MkdirAll(outside, os.ModePerm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.prepare != nil {
+				test.prepare(t, root, outside)
+			}
+
+			archivePath := filepath.Join(base, "input.tar")
+			writeTestTar(t, archivePath, test.entries)
+
+			_, err = Merge(root, []string{archivePath})
+			if test.wantErr && err == nil {
+				t.Errorf("expected merge to be refused")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			assertNothingOutside(t, base, outside)
+
+			if test.prepare == nil {
+				err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+					if err == nil && entry.Type()&fs.ModeSymlink != 0 {
+						t.Errorf("symbolic link written into the merge root: %s", filePath)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Fail if anything but the archive, the merge root and the empty outside directory is in base,
+// or if the absolute test path has been written
+func assertNothingOutside(t *testing.T, base string, outside string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "input.tar" && entry.Name() != "outside" && entry.Name() != "root" {
+			t.Errorf("written outside the merge root: %s", entry.Name())
+		}
+	}
+
+	outsideEntries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range outsideEntries {
+		t.Errorf("written outside the merge root: %s", filepath.Join(outside, entry.Name()))
+	}
+
+	if _, err := os.Lstat("/outside/evil.txt"); err == nil {
+		t.Errorf("written to absolute path /outside/evil.txt")
+	}
+}
+
+func TestMergeIntoExistingCollection(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+
+	first := filepath.Join(base, "first.tar")
+	writeTestTar(t, first, []testTarEntry{
+		{name: "page.html", contents: `<html><title>First</title><img src="./page_files/a.png"></html>`},
+		{name: "page_files/a.png", contents: "first image"},
+	})
+	_, err := Merge(root, []string{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file of nobody's collection, with the name of one in the next archive
+	err = os.WriteFile(filepath.Join(root, "notes.txt"), []byte("mine"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := filepath.Join(base, "second.tar")
+	writeTestTar(t, second, []testTarEntry{
+		{name: "page.html", contents: `<html><title>Second</title><img src="./page_files/a.png"></html>`},
+		{name: "page_files/a.png", contents: "second image"},
+		{name: "notes.txt", contents: "theirs"},
+		{name: "index.html", contents: "<html><title>Their index</title></html>"},
+	})
+	result, err := Merge(root, []string{second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wantFiles map[string]string = map[string]string{
+		"page.html":          `<html><title>First</title><img src="./page_files/a.png"></html>`,
+		"page_files/a.png":   "first image",
+		"page.2.html":        `<html><title>Second</title><img src="./page.2_files/a.png"></html>`,
+		"page.2_files/a.png": "second image",
+		"notes.txt":          "mine",
+		"notes.2.txt":        "theirs",
+		"index.2.html":       "<html><title>Their index</title></html>",
+	}
+	for relPath, want := range wantFiles {
+		contents, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(relPath)))
+		if err != nil {
+			t.Errorf("%s: %s", relPath, err)
+			continue
+		}
+		if string(contents) != want {
+			t.Errorf("%s: got %q, want %q", relPath, contents, want)
+		}
+	}
+
+	if len(result.Pages) != 2 {
+		t.Errorf("expected the two pages of the second archive, got %v", result.Pages)
+	}
+
+	index, err := os.ReadFile(filepath.Join(root, mergedIndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"First", "Second", "Their index"} {
+		if !strings.Contains(string(index), ">"+title+"<") {
+			t.Errorf("index does not list %q:\n%s", title, index)
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".gospa-merge-") {
+			t.Errorf("staging directory %s is left behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Descriptive information about a page
+type PageMetadata struct {
+	Title       string
+	Author      string
+	SiteName    string
+	Published   string
+	Description string
+	Language    string
+}
+
+// Collect page metadata from <title>, <html lang> and well-known <meta> tags
+func extractMetadata(pageBody []byte) PageMetadata {
+	var metadata PageMetadata
+	var ogTitle string
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(pageBody))
+	var inTitle bool = false
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = metadata.Title == ""
+			case "html":
+				for _, attribute := range token.Attr {
+					if attribute.Key == "lang" {
+						metadata.Language = strings.TrimSpace(attribute.Val)
+					}
+				}
+			case "meta":
+				var key, content string
+				for _, attribute := range token.Attr {
+					switch attribute.Key {
+					case "name", "property":
+						key = strings.ToLower(attribute.Val)
+					case "content":
+						content = strings.TrimSpace(attribute.Val)
+					}
+				}
+				if content == "" {
+					continue
+				}
+
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "author", "article:author", "dc.creator", "citation_author":
+					if metadata.Author == "" {
+						metadata.Author = content
+					}
+				case "og:site_name":
+					metadata.SiteName = content
+				case "article:published_time", "date", "dc.date", "citation_publication_date":
+					if metadata.Published == "" {
+						metadata.Published = content
+					}
+				case "description", "og:description":
+					if metadata.Description == "" {
+						metadata.Description = content
+					}
+				}
+			}
+
+		case html.TextToken:
+			if inTitle {
+				metadata.Title += string(tokenizer.Text())
+			}
+
+		case html.EndTagToken:
+			if inTitle {
+				inTitle = false
+			}
+		}
+	}
+
+	metadata.Title = strings.Join(strings.Fields(metadata.Title), " ")
+	if metadata.Title == "" {
+		metadata.Title = ogTitle
+	}
+
+	return metadata
+}
@@ -0,0 +1,190 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"net/url"
+	"time"
+)
+
+// Extension of saved page files
+func (saver *Saver) pageFileExtension() string {
+	switch saver.options.Format {
+	case FormatEPUB:
+		return ".epub"
+	case FormatMarkdown, FormatObsidian:
+		return ".md"
+	}
+	if saver.options.MHTML {
+		return ".mht"
+	}
+
+	return ".html"
+}
+
+// Writer that breaks what goes through it into lines of 76 characters, as MIME requires of base64
+type mimeLineWriter struct {
+	io.Writer
+	column int
+}
+
+func (writer *mimeLineWriter) Write(data []byte) (int, error) {
+	var written int = 0
+	for len(data) > 0 {
+		if writer.column == 76 {
+			_, err := writer.Writer.Write([]byte("\r\n"))
+			if err != nil {
+				return written, err
+			}
+			writer.column = 0
+		}
+
+		chunk := min(len(data), 76-writer.column)
+		wrote, err := writer.Writer.Write(data[:chunk])
+		written += wrote
+		writer.column += wrote
+		if err != nil {
+			return written, err
+		}
+		data = data[chunk:]
+	}
+
+	return written, nil
+}
+
+// Build an MHTML (multipart/related) archive out of saved page files and write it into archive.
+// Every file becomes a part located where the page expects to find it, relative to the page URL
+func buildMHTML(archive io.Writer, files *memoryOutput, report *PageReport, from *url.URL, title string) error {
+	var contentTypes map[string]string = make(map[string]string)
+	for _, asset := range report.Assets {
+		if asset.Status == AssetSaved && asset.ContentType != "" {
+			contentTypes[asset.LocalPath] = asset.ContentType
+		}
+	}
+
+	parts := multipart.NewWriter(archive)
+
+	fmt.Fprintf(archive, "From: <Saved by Gospa>\r\n")
+	fmt.Fprintf(archive, "Snapshot-Content-Location: %s\r\n", from.String())
+	fmt.Fprintf(archive, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(archive, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(archive, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(archive, "Content-Type: multipart/related;\r\n\ttype=\"text/html\";\r\n\tboundary=\"%s\"\r\n\r\n", parts.Boundary())
+
+	// the page itself goes first
+	pagePart, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+		"Content-Location":          {from.String()},
+	})
+	if err != nil {
+		return err
+	}
+	page, err := files.open(report.OutputPath)
+	if err != nil {
+		return err
+	}
+	encoder := quotedprintable.NewWriter(pagePart)
+	_, err = io.Copy(encoder, page)
+	if err != nil {
+		return err
+	}
+	encoder.Close()
+
+	for _, name := range files.names() {
+		if name == report.OutputPath {
+			continue
+		}
+
+		reference, err := url.Parse(localReference(name))
+		if err != nil {
+			return err
+		}
+		location := from.ResolveReference(reference)
+
+		contentType, ok := contentTypes[name]
+		if !ok {
+			contentType, err = files.detectMediaType(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Location":          {location.String()},
+		})
+		if err != nil {
+			return err
+		}
+		contents, err := files.open(name)
+		if err != nil {
+			return err
+		}
+		encoder := base64.NewEncoder(base64.StdEncoding, &mimeLineWriter{Writer: part})
+		_, err = io.Copy(encoder, contents)
+		if err != nil {
+			return err
+		}
+		err = encoder.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return parts.Close()
+}
+
+// Save page as one .mht file with all its files inside
+func (session *session) saveMHTMLPage(pageBody []byte, out output, from *url.URL, baseName string) (*PageReport, error) {
+	memory := newMemoryOutput(session.options.MemoryThreshold)
+	defer memory.Close()
+	report, err := session.savePage(pageBody, memory, from, baseName)
+	if err != nil {
+		return nil, err
+	}
+
+	outfile, err := out.Create(baseName + ".mht")
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &countingWriter{Writer: outfile}
+	err = buildMHTML(archive, memory, report, from, extractMetadata(pageBody).Title)
+	if err != nil {
+		outfile.Abort()
+		return nil, err
+	}
+	err = outfile.Close()
+	if err != nil {
+		return nil, err
+	}
+	report.OutputPath = baseName + ".mht"
+	report.Size = archive.written
+
+	for index := range report.Assets {
+		if report.Assets[index].Status == AssetSaved {
+			report.Assets[index].LocalPath = "(embedded)"
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,209 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// Longest file name most file systems allow, in bytes
+	maxFileNameLength int = 255
+	// Longest base name of a page, leaving room for what its output files append to it
+	// (_files, .original.html.age, language tags and such)
+	maxPageBaseNameLength int = maxFileNameLength - 64
+	// Longest page file name, leaving room for the suffix telling it apart from others named the same
+	maxAssetNameLength int = maxFileNameLength - 16
+)
+
+// Characters Windows does not allow in file names, and ones that would break references to the file
+const reservedFileNameCharacters string = `<>:"/\|?*#%`
+
+// Names Windows keeps for devices, with any extension
+var reservedDeviceNames map[string]bool = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Path of the link as it reads, percent-decoded, so that pages and files of non-English sites get readable names.
+// Escaped if decoding it does not give UTF-8, as with links in legacy charsets
+func readablePath(link *url.URL) string {
+	if !utf8.ValidString(link.Path) {
+		return link.EscapedPath()
+	}
+
+	return link.Path
+}
+
+// Query of the link as it reads, percent-decoded unless that does not give UTF-8. Empty if there is none
+func readableQuery(link *url.URL) string {
+	query, err := url.QueryUnescape(link.RawQuery)
+	if err != nil || !utf8.ValidString(query) {
+		return link.RawQuery
+	}
+
+	return query
+}
+
+// File name that can be written on Linux, macOS and Windows alike: in NFC, without characters and names
+// any of them reserve, and at most maxLength bytes long, too long names being cut and given a hash
+// of what they were to stay apart
+func safeFileName(name string, maxLength int) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, "_"))
+	name = strings.Map(func(char rune) rune {
+		if char < 0x20 || char == 0x7f || strings.ContainsRune(reservedFileNameCharacters, char) {
+			return '_'
+		}
+		return char
+	}, name)
+
+	// Windows drops them
+	name = strings.TrimRight(name, " .")
+	if name == "" {
+		name = "_"
+	}
+
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedDeviceNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = "_" + name
+	}
+
+	if len(name) <= maxLength {
+		return name
+	}
+
+	extension := path.Ext(name)
+	if len(extension) > 16 {
+		// not much of an extension
+		extension = ""
+	}
+	digest := sha1.Sum([]byte(name))
+	suffix := fmt.Sprintf("-%x%s", digest[:4], extension)
+
+	cut := maxLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+
+	return name[:cut] + suffix
+}
+
+// Extensions of server-side scripts, which say nothing about what they respond with
+var dynamicExtensions map[string]bool = map[string]bool{
+	".php": true, ".asp": true, ".aspx": true, ".ashx": true, ".jsp": true,
+	".cgi": true, ".pl": true, ".py": true, ".cfm": true, ".do": true, ".action": true,
+}
+
+// File name suggested by the server in Content-Disposition, empty if there is none or it is of no use
+func dispositionFileName(header http.Header) string {
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+
+	// mime takes filename* over filename, decoding it
+	name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+
+	return name
+}
+
+// Local file name for a page file once its response is known: the one suggested in Content-Disposition
+// if there is one, with an extension matching Content-Type appended if it has none the system knows
+// or only that of a server-side script, so that files from URLs like /api/image?id=5 open as what they are
+func responseFileName(name string, header http.Header) string {
+	if suggested := dispositionFileName(header); suggested != "" {
+		name = safeFileName(suggested, maxAssetNameLength)
+	}
+
+	extension := strings.ToLower(path.Ext(name))
+	if mime.TypeByExtension(extension) != "" && !dynamicExtensions[extension] {
+		return name
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType == "application/octet-stream" {
+		return name
+	}
+
+	extension = extensionForMediaType(mediaType)
+	if extension == ".bin" {
+		// nothing better to say about it
+		return name
+	}
+
+	return safeFileName(name+extension, maxAssetNameLength)
+}
+
+// Name with suffix inserted before its extension
+func withNameSuffix(name string, suffix string) string {
+	extension := path.Ext(name)
+	return strings.TrimSuffix(name, extension) + "-" + suffix + extension
+}
+
+// Take file name for owner, usually a page file URL. If it is taken by something else already,
+// the name gets a short hash of owner, so files named the same from different places are all kept.
+// Must be called with mutex held
+func (downloader *assetDownloader) claim(name string, owner string) string {
+	taker, ok := downloader.taken[strings.ToLower(name)]
+	if ok && taker != owner {
+		digest := sha1.Sum([]byte(owner))
+		hashed := withNameSuffix(name, fmt.Sprintf("%x", digest[:4]))
+		name = hashed
+		for number := 2; ; number++ {
+			taker, ok = downloader.taken[strings.ToLower(name)]
+			if !ok || taker == owner {
+				break
+			}
+			name = withNameSuffix(hashed, fmt.Sprint(number))
+		}
+	}
+
+	downloader.taken[strings.ToLower(name)] = owner
+	return name
+}
+
+// Give file name taken by owner back. Must be called with mutex held
+func (downloader *assetDownloader) unclaim(name string, owner string) {
+	if downloader.taken[strings.ToLower(name)] == owner {
+		delete(downloader.taken, strings.ToLower(name))
+	}
+}
+
+// relPath (slash-separated) with each of its segments escaped for use in a URL. Spaces, commas
+// and brackets are escaped too, as they would break srcset, CSS and Markdown references
+func escapePath(relPath string) string {
+	segments := strings.Split(relPath, "/")
+	for index := range segments {
+		segments[index] = url.PathEscape(segments[index])
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// Reference to the file at relPath in the output directory from a page in the output directory
+func localReference(relPath string) string {
+	return "./" + escapePath(relPath)
+}
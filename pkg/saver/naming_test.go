@@ -0,0 +1,102 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSafeFileName(t *testing.T) {
+	var tests []struct {
+		name  string
+		input string
+		want  string
+	} = []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain", input: "page.html", want: "page.html"},
+		{name: "dot-dot", input: "..", want: "_"},
+		{name: "dot", input: ".", want: "_"},
+		{name: "dot-dot path", input: "../../etc/passwd", want: ".._.._etc_passwd"},
+		{name: "absolute path", input: "/etc/passwd", want: "_etc_passwd"},
+		{name: "absolute windows path", input: `C:\Windows\system.ini`, want: "C__Windows_system.ini"},
+		{name: "UNC path", input: `\\server\share`, want: "__server_share"},
+		{name: "NUL", input: "a\x00b", want: "a_b"},
+		{name: "control characters", input: "a\tb\x7fc", want: "a_b_c"},
+		{name: "reserved characters", input: `a<b>c:d"e|f?g*h#i%j`, want: "a_b_c_d_e_f_g_h_i_j"},
+		{name: "trailing dots and spaces", input: "name. .", want: "name"},
+		{name: "empty", input: "", want: "_"},
+		{name: "invalid UTF-8", input: "a\xffb", want: "a_b"},
+		{name: "decomposed", input: "cafe\u0301", want: "caf\u00e9"},
+		{name: "device name", input: "CON", want: "_CON"},
+		{name: "device name with extension", input: "con.txt", want: "_con.txt"},
+		{name: "numbered device name", input: "LPT1.tar.gz", want: "_LPT1.tar.gz"},
+		{name: "device name with trailing space", input: "aux .html", want: "_aux .html"},
+		{name: "not a device name", input: "console.log", want: "console.log"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := safeFileName(test.input, maxFileNameLength)
+			if got != test.want {
+				t.Errorf("safeFileName(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSafeFileNameLength(t *testing.T) {
+	var tests []struct {
+		name  string
+		input string
+		// extension the shortened name keeps
+		extension string
+	} = []struct {
+		name      string
+		input     string
+		extension string
+	}{
+		{name: "ascii", input: strings.Repeat("a", 300) + ".html", extension: ".html"},
+		{name: "multibyte", input: strings.Repeat("ж", 200) + ".png", extension: ".png"},
+		{name: "long extension", input: "a." + strings.Repeat("b", 300), extension: ""},
+		{name: "exactly at the limit", input: strings.Repeat("c", maxFileNameLength), extension: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := safeFileName(test.input, maxFileNameLength)
+			if len(got) > maxFileNameLength {
+				t.Errorf("got %d bytes, want at most %d", len(got), maxFileNameLength)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("got invalid UTF-8 %q", got)
+			}
+			if len(test.input) <= maxFileNameLength && got != test.input {
+				t.Errorf("name within the limit changed to %q", got)
+			}
+			if !strings.HasSuffix(got, test.extension) {
+				t.Errorf("%q lost extension %q", got, test.extension)
+			}
+
+			// names differing past the cut stay apart
+			other := safeFileName(test.input+"x", maxFileNameLength)
+			if len(test.input) > maxFileNameLength && other == got {
+				t.Errorf("different names both became %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// Where notes and the files they show go in a vault, relative to it
+type vaultLayout struct {
+	notesDir       string
+	attachmentsDir string
+}
+
+// Settings of an Obsidian vault from its .obsidian/app.json that matter when adding notes
+type obsidianSettings struct {
+	AttachmentFolderPath string `json:"attachmentFolderPath"`
+}
+
+// Layout of the vault at vaultPath. Logseq graphs (with a logseq directory) keep notes in pages
+// and files in assets; Obsidian vaults keep notes in the root and files in the attachment folder
+// set in .obsidian/app.json, the root as well by default
+func readVaultLayout(vaultPath string) (vaultLayout, error) {
+	var layout vaultLayout
+
+	info, err := os.Stat(filepath.Join(vaultPath, "logseq"))
+	if err == nil && info.IsDir() {
+		layout.notesDir = "pages"
+		layout.attachmentsDir = "assets"
+		return layout, nil
+	}
+
+	contents, err := os.ReadFile(filepath.Join(vaultPath, ".obsidian", "app.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return layout, nil
+	}
+	if err != nil {
+		return layout, err
+	}
+
+	var settings obsidianSettings
+	err = json.Unmarshal(contents, &settings)
+	if err != nil {
+		return layout, fmt.Errorf("invalid .obsidian/app.json: %s", err)
+	}
+
+	// "/" is the root, "./" and "./folder" are relative to the note, which is in the root too
+	folder := strings.Trim(strings.TrimPrefix(strings.TrimSpace(settings.AttachmentFolderPath), "./"), "/")
+	if folder == "" || folder == "." {
+		return layout, nil
+	}
+	if !isLocalPath(folder) {
+		return layout, fmt.Errorf("attachment folder \"%s\" is outside the vault", settings.AttachmentFolderPath)
+	}
+	layout.attachmentsDir = folder
+
+	return layout, nil
+}
+
+// YAML frontmatter of a note, which Obsidian shows as its properties
+type noteFrontmatter struct {
+	Title     string   `yaml:"title,omitempty"`
+	Source    string   `yaml:"source"`
+	Author    string   `yaml:"author,omitempty"`
+	Published string   `yaml:"published,omitempty"`
+	Date      string   `yaml:"date"`
+	Tags      []string `yaml:"tags"`
+}
+
+// Tag made of a keyword: lowercase, with dashes instead of spaces and without characters tags cannot have.
+// Empty if nothing is left of it, or just a number, which is not a tag either
+func noteTag(keyword string) string {
+	var tag strings.Builder
+	for _, character := range strings.ToLower(strings.Join(strings.Fields(keyword), "-")) {
+		if unicode.IsLetter(character) || unicode.IsDigit(character) || strings.ContainsRune("-_/", character) {
+			tag.WriteRune(character)
+		}
+	}
+
+	if strings.IndexFunc(tag.String(), func(character rune) bool { return !unicode.IsDigit(character) }) < 0 {
+		return ""
+	}
+
+	return tag.String()
+}
+
+// Tags of the page from its keywords and article:tag <meta> tags
+func pageTags(pageBody []byte) []string {
+	var tags []string
+	var seen map[string]bool = make(map[string]bool)
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(pageBody))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+		if token.Data != "meta" {
+			continue
+		}
+
+		var key, content string
+		for _, attribute := range token.Attr {
+			switch attribute.Key {
+			case "name", "property":
+				key = strings.ToLower(attribute.Val)
+			case "content":
+				content = attribute.Val
+			}
+		}
+
+		var keywords []string
+		switch key {
+		case "keywords":
+			keywords = strings.Split(content, ",")
+		case "article:tag":
+			keywords = []string{content}
+		}
+		for _, keyword := range keywords {
+			tag := noteTag(keyword)
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// The article of the page alone, as a page titled as the original
+func buildArticlePage(pageBody []byte, from *url.URL) ([]byte, error) {
+	metadata := extractMetadata(pageBody)
+
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return nil, err
+	}
+
+	article := findArticle(document)
+	if article == nil {
+		return nil, fmt.Errorf("page has no body")
+	}
+	cleanArticle(article, from)
+
+	// the title is put above the article already
+	if heading := findElement(article, "h1", "", ""); heading != nil && nodeText(heading) == strings.TrimSpace(metadata.Title) {
+		heading.Parent.RemoveChild(heading)
+	}
+
+	content, err := renderArticle(article)
+	if err != nil {
+		return nil, err
+	}
+
+	var page bytes.Buffer
+	page.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&page, "<title>%s</title>\n</head>\n<body>\n", html.EscapeString(metadata.Title))
+	page.Write(content)
+	page.WriteString("\n</body>\n</html>\n")
+
+	return page.Bytes(), nil
+}
+
+// Save the article of the page as a Markdown note in the vault, with frontmatter describing it,
+// and the images it shows in the vault's attachment folder
+func (session *session) saveObsidianNote(pageBody []byte, out output, from *url.URL, baseName string) (*PageReport, error) {
+	article, err := buildArticlePage(pageBody, from)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := extractMetadata(pageBody)
+	header, err := yaml.Marshal(noteFrontmatter{
+		Title:     metadata.Title,
+		Source:    from.String(),
+		Author:    metadata.Author,
+		Published: metadata.Published,
+		Date:      time.Now().Format("2006-01-02"),
+		Tags:      pageTags(pageBody),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return session.saveMarkdown(
+		article, out, from, baseName,
+		path.Join(session.vault.notesDir, baseName+".md"), session.vault.attachmentsDir,
+		"---\n"+string(header)+"---\n\n",
+	)
+}
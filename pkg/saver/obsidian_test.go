@@ -0,0 +1,87 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadVaultLayout(t *testing.T) {
+	var tests []struct {
+		name     string
+		files    map[string]string
+		expected vaultLayout
+		wantErr  bool
+	} = []struct {
+		name     string
+		files    map[string]string
+		expected vaultLayout
+		wantErr  bool
+	}{
+		{"empty", nil, vaultLayout{}, false},
+		{"logseq", map[string]string{"logseq/config.edn": "{}"}, vaultLayout{notesDir: "pages", attachmentsDir: "assets"}, false},
+		{"obsidian default", map[string]string{".obsidian/app.json": "{}"}, vaultLayout{}, false},
+		{"obsidian root", map[string]string{".obsidian/app.json": `{"attachmentFolderPath": "/"}`}, vaultLayout{}, false},
+		{"obsidian next to note", map[string]string{".obsidian/app.json": `{"attachmentFolderPath": "./"}`}, vaultLayout{}, false},
+		{"obsidian folder", map[string]string{".obsidian/app.json": `{"attachmentFolderPath": "Files/Images"}`}, vaultLayout{attachmentsDir: "Files/Images"}, false},
+		{"obsidian subfolder", map[string]string{".obsidian/app.json": `{"attachmentFolderPath": "./attachments"}`}, vaultLayout{attachmentsDir: "attachments"}, false},
+		{"outside", map[string]string{".obsidian/app.json": `{"attachmentFolderPath": "../elsewhere"}`}, vaultLayout{}, true},
+		{"broken settings", map[string]string{".obsidian/app.json": `{`}, vaultLayout{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vault := t.TempDir()
+			for name, contents := range test.files {
+				err := os.MkdirAll(filepath.Dir(filepath.Join(vault, name)), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(vault, name), []byte(contents), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			layout, err := readVaultLayout(vault)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", layout)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, layout)
+			}
+		})
+	}
+}
+
+func TestPageTags(t *testing.T) {
+	page := `<html><head>` +
+		`<meta name="keywords" content="Web Archiving, go, 2024, web-archiving">` +
+		`<meta property="article:tag" content="Self Hosting!">` +
+		`</head><body></body></html>`
+
+	tags := pageTags([]byte(page))
+	expected := []string{"web-archiving", "go", "self-hosting"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+}
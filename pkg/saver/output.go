@@ -0,0 +1,430 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// File being written into an output
+type outputFile interface {
+	io.WriteCloser
+	// Give up on the file instead of closing it, leaving nothing of it in the output
+	Abort() error
+}
+
+// Destination for all files produced while saving a page
+type output interface {
+	// Create a new file at relPath relative to the output's root
+	Create(relPath string) (outputFile, error)
+	// Finish writing and release underlying resources
+	Close() error
+}
+
+// Plain directory on disk
+type dirOutput struct {
+	root string
+}
+
+func newDirOutput(root string) *dirOutput {
+	return &dirOutput{root: root}
+}
+
+// Whether relPath stays inside the directory it is relative to on any system: it is not absolute,
+// has no .. elements, drive letters, backslashes, which become separators on Windows, NUL bytes,
+// which cut it short, or elements Windows takes for devices
+func isLocalPath(relPath string) bool {
+	if !filepath.IsLocal(relPath) || strings.ContainsAny(relPath, "\\:\x00") {
+		return false
+	}
+
+	for _, element := range strings.Split(relPath, "/") {
+		stem, _, _ := strings.Cut(element, ".")
+		if reservedDeviceNames[strings.ToUpper(strings.TrimSpace(stem))] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Full path of relPath inside root, refusing paths that would lead out of it, whatever URLs they come from
+func joinOutputPath(root string, relPath string) (string, error) {
+	if !isLocalPath(relPath) {
+		return "", fmt.Errorf("refusing to write \"%s\" outside the output directory", relPath)
+	}
+
+	return filepath.Join(root, relPath), nil
+}
+
+// File in a plain directory, removed when aborted
+type dirFile struct {
+	*os.File
+}
+
+func (file dirFile) Abort() error {
+	file.File.Close()
+	return os.Remove(file.Name())
+}
+
+func (out *dirOutput) Create(relPath string) (outputFile, error) {
+	fullPath, err := joinOutputPath(out.root, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return dirFile{file}, nil
+}
+
+func (out *dirOutput) Close() error {
+	return nil
+}
+
+// Swallows everything written into it
+type discardOutput struct{}
+
+type discardFile struct {
+	io.Writer
+}
+
+func (discardFile) Close() error {
+	return nil
+}
+
+func (discardFile) Abort() error {
+	return nil
+}
+
+func (discardOutput) Create(relPath string) (outputFile, error) {
+	return discardFile{io.Discard}, nil
+}
+
+func (discardOutput) Close() error {
+	return nil
+}
+
+// Create output directory if it does not exist yet and make sure files can be written into it
+func prepareOutputDir(dirPath string) error {
+	err := os.MkdirAll(dirPath, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+
+	probe, err := os.CreateTemp(dirPath, ".gospa-write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %s", err)
+	}
+	probe.Close()
+
+	return os.Remove(probe.Name())
+}
+
+// Writer that counts the bytes written through it
+type countingWriter struct {
+	io.Writer
+	written int64
+}
+
+func (writer *countingWriter) Write(data []byte) (int, error) {
+	written, err := writer.Writer.Write(data)
+	writer.written += int64(written)
+
+	return written, err
+}
+
+// Tar stream. Each file is kept in a spillBuffer until closed, since tar headers need the size upfront
+type tarOutput struct {
+	mutex       sync.Mutex
+	tarWriter   *tar.Writer
+	underlying  io.WriteCloser
+	memoryLimit int64
+}
+
+func newTarOutput(underlying io.WriteCloser, memoryLimit int64) *tarOutput {
+	return &tarOutput{
+		tarWriter:   tar.NewWriter(underlying),
+		underlying:  underlying,
+		memoryLimit: memoryLimit,
+	}
+}
+
+type tarEntry struct {
+	spillBuffer
+	name   string
+	parent *tarOutput
+}
+
+// Contents kept in memory while they are small, in a temporary file once they grow past limit.
+// The temporary file is encrypted with a throwaway key, so that nothing lands on disk in the clear
+type spillBuffer struct {
+	buffer bytes.Buffer
+	// contents once they are too big for the buffer
+	spill       *os.File
+	spillWriter io.WriteCloser
+	spillKey    *age.X25519Identity
+	size        int64
+	limit       int64
+}
+
+// Move contents written so far into an encrypted temporary file
+func (buffer *spillBuffer) startSpilling() error {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+
+	spill, err := os.CreateTemp("", "gospa-spill-*")
+	if err != nil {
+		return err
+	}
+
+	spillWriter, err := age.Encrypt(spill, key.Recipient())
+	if err != nil {
+		spill.Close()
+		os.Remove(spill.Name())
+		return err
+	}
+
+	buffer.spill = spill
+	buffer.spillWriter = spillWriter
+	buffer.spillKey = key
+
+	_, err = buffer.spillWriter.Write(buffer.buffer.Bytes())
+	buffer.buffer = bytes.Buffer{}
+
+	return err
+}
+
+func (buffer *spillBuffer) Write(data []byte) (int, error) {
+	if buffer.spill == nil && buffer.size+int64(len(data)) > buffer.limit {
+		err := buffer.startSpilling()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var written int
+	var err error
+	if buffer.spill != nil {
+		written, err = buffer.spillWriter.Write(data)
+	} else {
+		written, err = buffer.buffer.Write(data)
+	}
+	buffer.size += int64(written)
+
+	return written, err
+}
+
+// Stop writing and read everything written so far, as many times as needed.
+// The buffer has to be discarded afterwards
+func (buffer *spillBuffer) contents() (io.Reader, error) {
+	if buffer.spill == nil {
+		return bytes.NewReader(buffer.buffer.Bytes()), nil
+	}
+
+	if buffer.spillWriter != nil {
+		err := buffer.spillWriter.Close()
+		if err != nil {
+			return nil, err
+		}
+		buffer.spillWriter = nil
+	}
+	_, err := buffer.spill.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return age.Decrypt(buffer.spill, buffer.spillKey)
+}
+
+// Drop the contents and remove the temporary file, if there is one
+func (buffer *spillBuffer) discard() error {
+	buffer.buffer = bytes.Buffer{}
+	if buffer.spill == nil {
+		return nil
+	}
+
+	if buffer.spillWriter != nil {
+		buffer.spillWriter.Close()
+	}
+	buffer.spill.Close()
+	err := os.Remove(buffer.spill.Name())
+	buffer.spill = nil
+
+	return err
+}
+
+func (entry *tarEntry) Close() error {
+	defer entry.discard()
+
+	contents, err := entry.contents()
+	if err != nil {
+		return err
+	}
+
+	entry.parent.mutex.Lock()
+	defer entry.parent.mutex.Unlock()
+
+	err = entry.parent.tarWriter.WriteHeader(&tar.Header{
+		Name:    entry.name,
+		Mode:    0644,
+		Size:    entry.size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry.parent.tarWriter, contents)
+	return err
+}
+
+// Drop the entry before it gets into the archive
+func (entry *tarEntry) Abort() error {
+	return entry.discard()
+}
+
+func (out *tarOutput) Create(relPath string) (outputFile, error) {
+	if !isLocalPath(relPath) {
+		return nil, fmt.Errorf("refusing to write \"%s\" outside the archive", relPath)
+	}
+
+	return &tarEntry{
+		spillBuffer: spillBuffer{limit: out.memoryLimit},
+		name:        filepath.ToSlash(relPath),
+		parent:      out,
+	}, nil
+}
+
+func (out *tarOutput) Close() error {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	err := out.tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return out.underlying.Close()
+}
+
+// Environment variable to take the passphrase from when encrypting with one
+const passphraseEnvVar string = "GOSPA_PASSPHRASE"
+
+// Parse encryption recipients: comma-separated age recipients (age1...) or "passphrase" to use GOSPA_PASSPHRASE
+func parseRecipients(value string) ([]age.Recipient, error) {
+	value = strings.TrimSpace(value)
+	if value == "passphrase" {
+		passphrase := os.Getenv(passphraseEnvVar)
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is not set", passphraseEnvVar)
+		}
+
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		return []age.Recipient{recipient}, nil
+	}
+
+	var recipients []age.Recipient
+	for _, recipientStr := range strings.Split(value, ",") {
+		recipientStr = strings.TrimSpace(recipientStr)
+		if recipientStr == "" {
+			continue
+		}
+
+		recipient, err := age.ParseX25519Recipient(recipientStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient \"%s\": %s", recipientStr, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients specified")
+	}
+
+	return recipients, nil
+}
+
+// age encryption writer that also closes the file underneath it
+type encryptedFile struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (encrypted *encryptedFile) Close() error {
+	err := encrypted.WriteCloser.Close()
+	if err != nil {
+		encrypted.file.Close()
+		return err
+	}
+
+	return encrypted.file.Close()
+}
+
+// Create a file at path which contents are encrypted for given recipients
+func createEncryptedFile(path string, recipients []age.Recipient) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptor, err := age.Encrypt(file, recipients...)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &encryptedFile{WriteCloser: encryptor, file: file}, nil
+}
+
+// Create an age-encrypted tar archive at path. Nothing is written to disk unencrypted
+func newEncryptedTarOutput(path string, recipients []age.Recipient, memoryLimit int64) (*tarOutput, error) {
+	file, err := createEncryptedFile(path, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTarOutput(file, memoryLimit), nil
+}
@@ -0,0 +1,59 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsLocalPath(t *testing.T) {
+	var tests []struct {
+		name    string
+		relPath string
+		want    bool
+	} = []struct {
+		name    string
+		relPath string
+		want    bool
+	}{
+		{name: "file", relPath: "page.html", want: true},
+		{name: "nested file", relPath: "page_files/img/a.png", want: true},
+		{name: "dot-dot inside", relPath: "page_files/../page.html", want: true},
+		{name: "dots in name", relPath: "..hidden..", want: true},
+		{name: "over-long name", relPath: strings.Repeat("a", 1000), want: true},
+		{name: "empty", relPath: "", want: false},
+		{name: "dot-dot", relPath: "..", want: false},
+		{name: "dot-dot prefix", relPath: "../etc/passwd", want: false},
+		{name: "dot-dot escaping", relPath: "page_files/../../etc/passwd", want: false},
+		{name: "absolute", relPath: "/etc/passwd", want: false},
+		{name: "windows drive", relPath: `C:\Windows\system.ini`, want: false},
+		{name: "windows drive relative", relPath: "C:system.ini", want: false},
+		{name: "backslash dot-dot", relPath: `..\..\etc\passwd`, want: false},
+		{name: "UNC", relPath: `\\server\share\file`, want: false},
+		{name: "NUL", relPath: "page.html\x00.png", want: false},
+		{name: "device name", relPath: "CON", want: false},
+		{name: "device name with extension", relPath: "page_files/nul.txt", want: false},
+		{name: "lowercase numbered device name", relPath: "com1.tar.gz", want: false},
+		{name: "not a device name", relPath: "page_files/console.log", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := isLocalPath(test.relPath)
+			if got != test.want {
+				t.Errorf("isLocalPath(%q) = %v, want %v", test.relPath, got, test.want)
+			}
+		})
+	}
+}
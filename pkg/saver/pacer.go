@@ -0,0 +1,88 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Spaces out requests to the same host, so that it is not hammered by concurrent downloads
+type hostPacer struct {
+	// least time between two requests to a host
+	interval time.Duration
+	// up to this much is randomly added to every interval
+	jitter time.Duration
+
+	mutex sync.Mutex
+	// host -> when the next request to it may be made
+	next map[string]time.Time
+	// host -> least time between two requests to it, if longer than interval
+	hostIntervals map[string]time.Duration
+}
+
+func newHostPacer(interval time.Duration, jitter time.Duration) *hostPacer {
+	return &hostPacer{
+		interval:      interval,
+		jitter:        jitter,
+		next:          make(map[string]time.Time),
+		hostIntervals: make(map[string]time.Duration),
+	}
+}
+
+// Keep at least interval between requests to the host from now on
+func (pacer *hostPacer) slowDown(host string, interval time.Duration) {
+	pacer.mutex.Lock()
+	defer pacer.mutex.Unlock()
+
+	if interval > pacer.hostIntervals[host] {
+		pacer.hostIntervals[host] = interval
+	}
+}
+
+// Wait until a request to the host may be made. Requests get their turns in the order they ask
+func (pacer *hostPacer) wait(ctx context.Context, host string) error {
+	pacer.mutex.Lock()
+	gap := pacer.interval
+	if hostInterval := pacer.hostIntervals[host]; hostInterval > gap {
+		gap = hostInterval
+	}
+	if gap <= 0 && pacer.jitter <= 0 {
+		pacer.mutex.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	turn := pacer.next[host]
+	if turn.Before(now) {
+		turn = now
+	}
+
+	if pacer.jitter > 0 {
+		gap += time.Duration(rand.Int63n(int64(pacer.jitter) + 1))
+	}
+	pacer.next[host] = turn.Add(gap)
+	pacer.mutex.Unlock()
+
+	timer := time.NewTimer(time.Until(turn))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Paper sizes by name, width and height in inches
+var pdfPageSizes map[string][2]float64 = map[string][2]float64{
+	"a3":      {11.69, 16.54},
+	"a4":      {8.27, 11.69},
+	"a5":      {5.83, 8.27},
+	"letter":  {8.5, 11},
+	"legal":   {8.5, 14},
+	"tabloid": {11, 17},
+}
+
+// Parse length like "10mm", "1.5cm" or "0.5in" into inches
+func parseLength(length string) (float64, error) {
+	length = strings.ToLower(strings.TrimSpace(length))
+
+	var perInch float64
+	switch {
+	case strings.HasSuffix(length, "mm"):
+		perInch = 25.4
+	case strings.HasSuffix(length, "cm"):
+		perInch = 2.54
+	case strings.HasSuffix(length, "in"):
+		perInch = 1
+	default:
+		return 0, fmt.Errorf("length \"%s\" has no unit (mm, cm or in)", length)
+	}
+
+	value, err := strconv.ParseFloat(length[:len(length)-2], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid length \"%s\"", length)
+	}
+
+	return value / perInch, nil
+}
+
+// Parse page size: a name (A3, A4, A5, letter, legal, tabloid) or WIDTHxHEIGHT with units, e.g. 210mmx297mm
+func parsePageSize(size string) (width float64, height float64, err error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	if dimensions, ok := pdfPageSizes[size]; ok {
+		return dimensions[0], dimensions[1], nil
+	}
+
+	widthStr, heightStr, found := strings.Cut(size, "x")
+	if !found {
+		return 0, 0, fmt.Errorf("unknown page size \"%s\"", size)
+	}
+
+	width, err = parseLength(widthStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err = parseLength(heightStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("page size must not be zero")
+	}
+
+	return width, height, nil
+}
+
+// PDF printing settings, sizes in inches
+type pdfOptions struct {
+	width  float64
+	height float64
+	margin float64
+}
+
+// Parse PDF page size and margin
+func parsePDFOptions(pageSize string, margin string) (pdfOptions, error) {
+	var options pdfOptions
+	var err error
+
+	options.width, options.height, err = parsePageSize(pageSize)
+	if err != nil {
+		return options, err
+	}
+
+	options.margin, err = parseLength(margin)
+	if err != nil {
+		return options, err
+	}
+
+	if 2*options.margin >= options.width || 2*options.margin >= options.height {
+		return options, fmt.Errorf("margins leave no room on the page")
+	}
+
+	return options, nil
+}
+
+// Waits for the images of a page put into the browser to load or fail
+const waitForImagesScript string = `Promise.all(Array.from(document.images).filter(image => !image.complete).map(image => new Promise(done => { image.onload = image.onerror = done })))`
+
+// Load the page in the headless browser and print it to PDF. If pageBody is given, it is printed
+// instead of what link serves, with its files still loaded from link
+func (session *session) printPageToPDF(link string, pageBody []byte) ([]byte, error) {
+	options := session.pdf
+
+	tab, closeTab, err := session.browser.newTab(session.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start headless browser: %s", err)
+	}
+	defer closeTab()
+
+	var load chromedp.Action = chromedp.Navigate(link)
+	if pageBody != nil {
+		// without scripts, which could bring back what has been taken out of the page
+		contents := string(staticPage(pageBody, link, ""))
+		load = chromedp.Tasks{
+			chromedp.Navigate("about:blank"),
+			chromedp.ActionFunc(func(ctx context.Context) error {
+				frameTree, err := page.GetFrameTree().Do(ctx)
+				if err != nil {
+					return err
+				}
+				return page.SetDocumentContent(frameTree.Frame.ID, contents).Do(ctx)
+			}),
+			chromedp.Evaluate(waitForImagesScript, nil, func(params *runtime.EvaluateParams) *runtime.EvaluateParams {
+				return params.WithAwaitPromise(true)
+			}),
+		}
+	}
+
+	var pdf []byte
+	err = chromedp.Run(tab,
+		network.Enable(),
+		session.browserHeaders(),
+		load,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			pdf, _, err = page.PrintToPDF().
+				WithPrintBackground(true).
+				WithPaperWidth(options.width).
+				WithPaperHeight(options.height).
+				WithMarginTop(options.margin).
+				WithMarginBottom(options.margin).
+				WithMarginLeft(options.margin).
+				WithMarginRight(options.margin).
+				Do(ctx)
+			return err
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pdf, nil
+}
+
+// Print the page to PDF and write it next to the saved page. Returns name of the written file.
+// pageBody is printed instead of the live page if given, as when the saved page has been redacted
+func (session *session) writePagePDF(link string, pageBody []byte, baseName string) (string, error) {
+	pdf, err := session.printPageToPDF(link, pageBody)
+	if err != nil {
+		return "", err
+	}
+
+	pdfFile, err := session.out.Create(baseName + ".pdf")
+	if err != nil {
+		return "", err
+	}
+	defer pdfFile.Close()
+
+	_, err = pdfFile.Write(pdf)
+
+	return baseName + ".pdf", err
+}
@@ -0,0 +1,112 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Style of print variants: one flowing column on white paper, without navigation, fixed widths
+// and floats, with the address of every outside link written after it
+const printStylesheet string = `@page{margin:2cm}
+html,body{background:#fff!important;color:#000!important}
+body{font:12pt/1.5 Georgia,serif;width:auto!important;max-width:none!important;margin:0!important;padding:0!important}
+body *{max-width:100%!important;float:none!important;position:static!important;box-shadow:none!important}
+div,section,article,main,header,table,form{width:auto!important;min-width:0!important}
+nav,aside,footer,iframe,video,audio,button,form,[role=navigation],[role=banner],[role=complementary]{display:none!important}
+img,svg{height:auto!important;page-break-inside:avoid}
+h1,h2,h3,h4,h5,h6{page-break-after:avoid}
+pre,code{white-space:pre-wrap!important;word-wrap:break-word}
+a{color:#000!important;text-decoration:underline}
+a[href^="http"]::after,a[href^="ftp"]::after{content:" (" attr(href) ")";font-size:90%;word-break:break-all}`
+
+// Page made for printing out of a saved one: scripts taken away, as they could lay it out again,
+// and printStylesheet put after the page's own styles
+func printVariant(pageBody []byte) []byte {
+	return staticPage(pageBody, "", printStylesheet)
+}
+
+// Page without scripts, with links resolved against base and stylesheet put after its own styles,
+// each left out if empty
+func staticPage(pageBody []byte, base string, stylesheet string) []byte {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return pageBody
+	}
+
+	var scripts []*html.Node
+	var head *html.Node
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch strings.ToLower(node.Data) {
+			case "script":
+				scripts = append(scripts, node)
+				return
+			case "head":
+				if head == nil {
+					head = node
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(document)
+
+	for _, script := range scripts {
+		script.Parent.RemoveChild(script)
+	}
+
+	if head != nil && base != "" {
+		head.InsertBefore(&html.Node{
+			Type: html.ElementNode,
+			Data: "base",
+			Attr: []html.Attribute{{Key: "href", Val: base}},
+		}, head.FirstChild)
+	}
+	if head != nil && stylesheet != "" {
+		style := &html.Node{Type: html.ElementNode, Data: "style"}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: stylesheet})
+		head.AppendChild(style)
+	}
+
+	var rendered bytes.Buffer
+	err = html.Render(&rendered, document)
+	if err != nil {
+		return pageBody
+	}
+
+	return rendered.Bytes()
+}
+
+// Write the print variant of a saved page next to it, returning its name
+func writePrintVariant(pageBody []byte, baseName string, out output) (string, error) {
+	var printName string = baseName + ".print.html"
+	printFile, err := out.Create(printName)
+	if err != nil {
+		return "", err
+	}
+	defer printFile.Close()
+
+	_, err = printFile.Write(printVariant(pageBody))
+	if err != nil {
+		return "", err
+	}
+
+	return printName, nil
+}
@@ -0,0 +1,253 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// What gospa knows about the markup of a popular platform, or what the user has told it about a site
+type siteProfile struct {
+	name string
+	// hosts (and their subdomains) the platform serves pages from
+	hosts []string
+	// strings in the page that give the platform away on custom domains
+	markers []string
+	// selector of the element holding the article, kept alone for Markdown and EPUB
+	content string
+	// attributes holding real image addresses until a script swaps them in: attribute -> src or srcset
+	lazyAttributes map[string]string
+	// sent with every request to the hosts
+	headers http.Header
+	// selectors of elements dropped from saved pages
+	remove []string
+	// JavaScript run in pages of the hosts before their DOM is taken. Such pages are always rendered
+	script string
+	// steps of logging in to the hosts before the first of their pages is fetched
+	login []loginStep
+	// logging in once for all saves
+	loginState *siteLoginState
+}
+
+// Built-in profiles, checked in order
+var siteProfiles []siteProfile = []siteProfile{
+	{
+		name:    "github",
+		hosts:   []string{"github.com"},
+		content: "article.markdown-body",
+	},
+	{
+		name:    "medium",
+		hosts:   []string{"medium.com"},
+		markers: []string{`content="com.medium.reader"`},
+		content: "article",
+	},
+	{
+		name:    "substack",
+		hosts:   []string{"substack.com"},
+		markers: []string{"substackcdn.com"},
+		content: "div.available-content",
+	},
+	{
+		name:    "mediawiki",
+		markers: []string{`<meta name="generator" content="MediaWiki`},
+		content: "div#mw-content-text",
+	},
+	{
+		name:    "discourse",
+		markers: []string{`<meta name="generator" content="Discourse`},
+		content: "div#main-outlet",
+	},
+	{
+		name:    "wordpress",
+		markers: []string{`<meta name="generator" content="WordPress`},
+		content: "div.entry-content",
+		lazyAttributes: map[string]string{
+			"data-lazy-src":    "src",
+			"data-lazy-srcset": "srcset",
+			"data-src":         "src",
+			"data-srcset":      "srcset",
+		},
+	},
+}
+
+// Whether host is one of the profile's hosts or their subdomain
+func (profile *siteProfile) servesHost(host string) bool {
+	for _, profileHost := range profile.hosts {
+		if host == profileHost || strings.HasSuffix(host, "."+profileHost) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Profile of the site serving the page among profiles, if it is a known one
+func findSiteProfile(profiles []siteProfile, pageURL *url.URL, pageBody []byte) *siteProfile {
+	host := strings.ToLower(pageURL.Hostname())
+	for index := range profiles {
+		profile := &profiles[index]
+		if profile.servesHost(host) {
+			return profile
+		}
+		for _, marker := range profile.markers {
+			if bytes.Contains(pageBody, []byte(marker)) {
+				return profile
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether element matches a selector parsed by parseSelector
+func elementMatches(node *html.Node, tag string, id string, class string) bool {
+	if node.Type != html.ElementNode || (tag != "" && node.Data != tag) {
+		return false
+	}
+	if id != "" {
+		value, _ := getAttribute(node, "id")
+		return value == id
+	}
+	if class != "" {
+		value, _ := getAttribute(node, "class")
+		for _, nodeClass := range strings.Fields(value) {
+			if nodeClass == class {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// First element in the tree matching the selector
+func findElement(node *html.Node, tag string, id string, class string) *html.Node {
+	if elementMatches(node, tag, id, class) {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, tag, id, class); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// Leave only the article in the page's body, keeping its head. The page is returned as is
+// if the profile does not know where the article is or it cannot be found
+func (profile *siteProfile) extractContent(pageBody []byte) []byte {
+	if profile.content == "" {
+		return pageBody
+	}
+
+	tag, id, class, err := parseSelector(profile.content)
+	if err != nil {
+		return pageBody
+	}
+
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return pageBody
+	}
+
+	content := findElement(document, tag, id, class)
+	body := findElement(document, "body", "", "")
+	if content == nil || body == nil {
+		return pageBody
+	}
+
+	content.Parent.RemoveChild(content)
+	for body.FirstChild != nil {
+		body.RemoveChild(body.FirstChild)
+	}
+	body.AppendChild(content)
+
+	var extracted bytes.Buffer
+	err = html.Render(&extracted, document)
+	if err != nil {
+		return pageBody
+	}
+
+	return extracted.Bytes()
+}
+
+// Put addresses of lazily loaded images where they are looked for, so that the images get saved
+func (profile *siteProfile) revealLazyImages(pageBody []byte) []byte {
+	if len(profile.lazyAttributes) == 0 {
+		return pageBody
+	}
+
+	var output bytes.Buffer
+	var changed bool = false
+	tokenizer := html.NewTokenizer(bytes.NewReader(pageBody))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			output.Write(tokenizer.Raw())
+			continue
+		}
+
+		token := tokenizer.Token()
+		if token.Data != "img" && token.Data != "source" {
+			output.Write(tokenizer.Raw())
+			continue
+		}
+
+		var revealed map[string]string = make(map[string]string)
+		for _, attribute := range token.Attr {
+			if target, ok := profile.lazyAttributes[attribute.Key]; ok && strings.TrimSpace(attribute.Val) != "" {
+				revealed[target] = attribute.Val
+			}
+		}
+		if len(revealed) == 0 {
+			output.Write(tokenizer.Raw())
+			continue
+		}
+
+		var attributes []html.Attribute
+		for _, attribute := range token.Attr {
+			if _, ok := revealed[attribute.Key]; ok {
+				continue
+			}
+			attributes = append(attributes, attribute)
+		}
+		for _, key := range []string{"src", "srcset"} {
+			if value, ok := revealed[key]; ok {
+				attributes = append(attributes, html.Attribute{Key: key, Val: value})
+			}
+		}
+		token.Attr = attributes
+
+		output.WriteString(token.String())
+		changed = true
+	}
+
+	if !changed || tokenizer.Err() != io.EOF {
+		return pageBody
+	}
+
+	return output.Bytes()
+}
@@ -0,0 +1,239 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Words an average reader gets through in a minute
+const readingWordsPerMinute int = 230
+
+// Elements of the article that are of no use when reading it
+var readerDroppedElements map[string]bool = map[string]bool{
+	"script": true, "style": true, "noscript": true, "iframe": true, "form": true, "button": true,
+	"input": true, "select": true, "textarea": true, "nav": true, "aside": true, "footer": true,
+	"template": true, "object": true, "embed": true, "link": true, "meta": true,
+}
+
+// Attributes kept on the article's elements; everything else, styling included, is dropped
+var readerKeptAttributes map[string]bool = map[string]bool{
+	"id": true, "href": true, "src": true, "srcset": true, "sizes": true, "alt": true, "title": true,
+	"colspan": true, "rowspan": true, "datetime": true, "lang": true, "dir": true, "start": true,
+	"controls": true, "poster": true, "type": true,
+}
+
+// Built-in stylesheet of reader pages
+const readerStylesheet string = `body{margin:0;background:#fbfaf7;color:#222;font:19px/1.6 Georgia,"Times New Roman",serif}
+article.gospa-reader{max-width:42em;margin:0 auto;padding:2em 1.2em 4em}
+.gospa-reader header h1{font-size:2em;line-height:1.2;margin:0 0 .3em}
+.gospa-reader-meta{color:#666;font:15px/1.4 sans-serif;margin:0 0 2em}
+.gospa-reader-meta a{color:inherit}
+.gospa-toc{font:15px/1.5 sans-serif;background:#f0eee8;padding:.8em 1.2em;margin:0 0 2em;border-radius:4px}
+.gospa-toc p{margin:0 0 .4em}
+.gospa-toc ul{margin:0;padding-left:1.2em}
+h1,h2,h3,h4{font-family:sans-serif;line-height:1.25}
+a{color:#1a5fb4}
+img,video{max-width:100%;height:auto}
+figure{margin:1.5em 0}
+figcaption{color:#666;font-size:.85em}
+pre{overflow:auto;background:#f0eee8;padding:.8em;font-size:.8em}
+code{font-size:.9em}
+blockquote{margin:1em 0;padding-left:1em;border-left:3px solid #ccc;color:#555}
+table{border-collapse:collapse}
+td,th{border:1px solid #ccc;padding:.3em .6em}
+@media(prefers-color-scheme:dark){body{background:#1c1c1c;color:#ddd}.gospa-toc,pre{background:#2a2a2a}a{color:#8ab4f8}}`
+
+// Text length of paragraphs directly inside node, a measure of how likely it is to hold the article
+func paragraphScore(node *html.Node) int {
+	var score int = 0
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "p" {
+			score += len(nodeText(child))
+		}
+	}
+
+	return score
+}
+
+// Element holding the article: <article>, <main>, or the block with the most paragraph text
+func findArticle(document *html.Node) *html.Node {
+	if article := findElement(document, "article", "", ""); article != nil {
+		return article
+	}
+	if main := findElement(document, "main", "", ""); main != nil {
+		return main
+	}
+
+	var best *html.Node = nil
+	var bestScore int = 0
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && (node.Data == "div" || node.Data == "section" || node.Data == "td" || node.Data == "body") {
+			if score := paragraphScore(node); score > bestScore {
+				best = node
+				bestScore = score
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(document)
+
+	if best == nil {
+		return findElement(document, "body", "", "")
+	}
+
+	return best
+}
+
+// Strip the article of scripts, forms, navigation and styling, and make its links and images absolute
+func cleanArticle(node *html.Node, from *url.URL) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.CommentNode || (child.Type == html.ElementNode && readerDroppedElements[child.Data]) {
+			node.RemoveChild(child)
+		} else {
+			cleanArticle(child, from)
+		}
+		child = next
+	}
+
+	if node.Type != html.ElementNode {
+		return
+	}
+
+	var attributes []html.Attribute
+	for _, attribute := range node.Attr {
+		if attribute.Namespace != "" || !readerKeptAttributes[attribute.Key] {
+			continue
+		}
+		switch attribute.Key {
+		case "href", "src", "poster":
+			if strings.HasPrefix(strings.TrimSpace(attribute.Val), "#") || strings.HasPrefix(strings.TrimSpace(attribute.Val), "data:") {
+				break
+			}
+			if link, err := url.Parse(strings.TrimSpace(attribute.Val)); err == nil {
+				// the page is read offline, its relative links would lead nowhere
+				attribute.Val = from.ResolveReference(link).String()
+			}
+		}
+		attributes = append(attributes, attribute)
+	}
+	node.Attr = attributes
+}
+
+// HTML of the article; of its contents only if it is the whole body
+func renderArticle(article *html.Node) ([]byte, error) {
+	var content bytes.Buffer
+	if article.Data != "body" {
+		err := html.Render(&content, article)
+		return content.Bytes(), err
+	}
+
+	for child := article.FirstChild; child != nil; child = child.NextSibling {
+		err := html.Render(&content, child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return content.Bytes(), nil
+}
+
+// Minutes it takes to read text, at least one
+func readingMinutes(text string) int {
+	words := len(strings.Fields(text))
+	return int(math.Max(1, math.Ceil(float64(words)/float64(readingWordsPerMinute))))
+}
+
+// Turn the page into a clean, readable one: just the article with a table of contents and estimated reading time
+func buildReaderPage(pageBody []byte, from *url.URL) ([]byte, error) {
+	metadata := extractMetadata(pageBody)
+	if metadata.Title == "" {
+		metadata.Title = from.String()
+	}
+
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return nil, err
+	}
+
+	article := findArticle(document)
+	if article == nil {
+		return nil, fmt.Errorf("page has no body")
+	}
+	cleanArticle(article, from)
+
+	// the title is shown above the article already
+	if heading := findElement(article, "h1", "", ""); heading != nil && nodeText(heading) == strings.TrimSpace(metadata.Title) {
+		heading.Parent.RemoveChild(heading)
+	}
+
+	toc := addHeadingAnchors(article, 3)
+
+	content, err := renderArticle(article)
+	if err != nil {
+		return nil, err
+	}
+
+	var details []string
+	for _, detail := range []string{metadata.SiteName, metadata.Author, metadata.Published} {
+		if detail != "" {
+			details = append(details, html.EscapeString(detail))
+		}
+	}
+	details = append(details, fmt.Sprintf("%d min read", readingMinutes(nodeText(article))))
+	details = append(details, fmt.Sprintf(`<a href="%s">Original</a>`, html.EscapeString(from.String())))
+
+	var page bytes.Buffer
+	page.WriteString("<!DOCTYPE html>\n")
+	if metadata.Language != "" {
+		fmt.Fprintf(&page, "<html lang=\"%s\">\n", html.EscapeString(metadata.Language))
+	} else {
+		page.WriteString("<html>\n")
+	}
+	page.WriteString("<head>\n<meta charset=\"utf-8\">\n<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&page, "<title>%s</title>\n", html.EscapeString(metadata.Title))
+	if metadata.Author != "" {
+		fmt.Fprintf(&page, "<meta name=\"author\" content=\"%s\">\n", html.EscapeString(metadata.Author))
+	}
+	fmt.Fprintf(&page, "<style>\n%s\n</style>\n</head>\n<body>\n<article class=\"gospa-reader\">\n", readerStylesheet)
+	fmt.Fprintf(&page, "<header><h1>%s</h1><p class=\"gospa-reader-meta\">%s</p></header>\n",
+		html.EscapeString(metadata.Title), strings.Join(details, " &middot; "))
+	if len(toc) > 1 {
+		page.WriteString(renderTOC(toc))
+		page.WriteString("\n")
+	}
+	page.Write(content)
+	page.WriteString("\n</article>\n</body>\n</html>\n")
+
+	return page.Bytes(), nil
+}
+
+// Save the page as a clean reader page, with the images of the article next to it
+func (session *session) saveReaderPage(pageBody []byte, out output, from *url.URL, baseName string) (*PageReport, error) {
+	readerPage, err := buildReaderPage(pageBody, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.savePage(readerPage, out, from, baseName)
+}
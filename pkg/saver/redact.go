@@ -0,0 +1,276 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultRedactionReplacement string = "[REDACTED]"
+
+// A single redaction rule as written in the rules file.
+// Either Pattern (regular expression) or Selector (tag, #id, .class, tag#id, tag.class) must be set
+type redactionRule struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Selector    string `yaml:"selector"`
+	Replacement string `yaml:"replacement"`
+
+	regexp *regexp.Regexp
+	tag    string
+	id     string
+	class  string
+}
+
+type redactionRules struct {
+	Rules []*redactionRule `yaml:"rules"`
+}
+
+// Parse simple CSS-like selector into tag, id and class parts
+func parseSelector(selector string) (tag string, id string, class string, err error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" || strings.ContainsAny(selector, " >+~[]:") {
+		return "", "", "", fmt.Errorf("unsupported selector \"%s\"", selector)
+	}
+
+	if index := strings.IndexAny(selector, "#."); index != -1 {
+		tag = selector[:index]
+		if selector[index] == '#' {
+			id = selector[index+1:]
+		} else {
+			class = selector[index+1:]
+		}
+	} else {
+		tag = selector
+	}
+
+	return strings.ToLower(tag), id, class, nil
+}
+
+// Read and compile redaction rules from a YAML file
+func loadRedactionRules(path string) (*redactionRules, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules redactionRules
+	err = yaml.Unmarshal(contents, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %s", err)
+	}
+
+	for index, rule := range rules.Rules {
+		if rule.Replacement == "" {
+			rule.Replacement = defaultRedactionReplacement
+		}
+
+		switch {
+		case rule.Pattern != "" && rule.Selector != "":
+			return nil, fmt.Errorf("rule %d has both pattern and selector set", index)
+		case rule.Pattern != "":
+			rule.regexp, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d has invalid pattern: %s", index, err)
+			}
+		case rule.Selector != "":
+			rule.tag, rule.id, rule.class, err = parseSelector(rule.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %s", index, err)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d has neither pattern nor selector set", index)
+		}
+	}
+
+	return &rules, nil
+}
+
+// Elements that never have contents or an end tag
+var voidElements map[string]bool = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// Check whether element's start tag token matches rule's selector
+func (rule *redactionRule) matches(token html.Token) bool {
+	if rule.tag != "" && token.Data != rule.tag {
+		return false
+	}
+
+	for _, attribute := range token.Attr {
+		switch {
+		case rule.id != "" && attribute.Key == "id" && attribute.Val == rule.id:
+			return true
+		case rule.class != "" && attribute.Key == "class":
+			for _, class := range strings.Fields(attribute.Val) {
+				if class == rule.class {
+					return true
+				}
+			}
+		}
+	}
+
+	return rule.id == "" && rule.class == ""
+}
+
+// Replace contents of elements matched by selector rules with their replacement text. Matched elements
+// keep only their bare start tag, attributes can give away as much as contents. One left unclosed
+// ends where its parent does, or with the page
+func redactElements(pageBody []byte, rules []*redactionRule) []byte {
+	var output bytes.Buffer
+	tokenizer := html.NewTokenizer(bytes.NewReader(pageBody))
+
+	// elements open inside the one being redacted, the redacted one first
+	var skipping []string
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// anything past an error that is not the end of the page is dropped: better lost than leaked
+			break
+		}
+		raw := tokenizer.Raw()
+
+		if len(skipping) > 0 {
+			switch tokenType {
+			case html.StartTagToken:
+				if name := tokenizer.Token().Data; !voidElements[name] {
+					skipping = append(skipping, name)
+				}
+			case html.EndTagToken:
+				// innermost open element of that name
+				var name string = tokenizer.Token().Data
+				var index int = len(skipping) - 1
+				for index >= 0 && skipping[index] != name {
+					index--
+				}
+				switch {
+				case index == -1:
+					// parent's end tag, the redacted element has been left unclosed
+					output.WriteString("</" + skipping[0] + ">")
+					output.Write(raw)
+					skipping = nil
+				case index == 0:
+					output.WriteString("</" + skipping[0] + ">")
+					skipping = nil
+				default:
+					skipping = skipping[:index]
+				}
+			}
+			continue
+		}
+
+		if tokenType == html.StartTagToken || tokenType == html.SelfClosingTagToken {
+			token := tokenizer.Token()
+			var redacted bool = false
+			for _, rule := range rules {
+				if !rule.matches(token) {
+					continue
+				}
+
+				if voidElements[token.Data] || tokenType == html.SelfClosingTagToken {
+					// nothing inside, mask the element itself
+					output.WriteString(html.EscapeString(rule.Replacement))
+				} else {
+					output.WriteString("<" + token.Data + ">")
+					output.WriteString(html.EscapeString(rule.Replacement))
+					skipping = []string{token.Data}
+				}
+				redacted = true
+				break
+			}
+			if redacted {
+				continue
+			}
+		}
+
+		output.Write(raw)
+	}
+
+	if len(skipping) > 0 {
+		output.WriteString("</" + skipping[0] + ">")
+	}
+
+	return output.Bytes()
+}
+
+// Apply all rules to page contents
+func (rules *redactionRules) apply(pageBody []byte) []byte {
+	var selectorRules []*redactionRule
+	for _, rule := range rules.Rules {
+		if rule.regexp != nil {
+			pageBody = rule.regexp.ReplaceAll(pageBody, []byte(rule.Replacement))
+		} else {
+			selectorRules = append(selectorRules, rule)
+		}
+	}
+
+	if len(selectorRules) > 0 {
+		pageBody = redactElements(pageBody, selectorRules)
+	}
+
+	return pageBody
+}
+
+// Apply pattern rules to text that is not a page: stylesheets, scripts, headers, URLs
+func (rules *redactionRules) applyText(text []byte) []byte {
+	for _, rule := range rules.Rules {
+		if rule.regexp != nil {
+			text = rule.regexp.ReplaceAll(text, []byte(rule.Replacement))
+		}
+	}
+
+	return text
+}
+
+func (rules *redactionRules) applyString(text string) string {
+	return string(rules.applyText([]byte(text)))
+}
+
+// Apply rules to a body of given Content-Type: all of them to pages, pattern rules to other text
+func (rules *redactionRules) applyBody(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		return rules.apply(body)
+	}
+
+	return rules.applyText(body)
+}
+
+// Whether Content-Type says it is text redaction rules can be applied to
+func isRedactableText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-javascript", "application/ecmascript",
+		"application/xml", "application/x-www-form-urlencoded":
+		return true
+	}
+
+	return false
+}
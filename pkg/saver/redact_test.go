@@ -0,0 +1,198 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Rules from YAML written into a temporary file
+func testRedactionRules(t *testing.T, contents string) *redactionRules {
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	err := os.WriteFile(rulesPath, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := loadRedactionRules(rulesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rules
+}
+
+func TestRedactionRules(t *testing.T) {
+	rules := testRedactionRules(t, `
+rules:
+  - selector: .secret
+  - selector: input#token
+    replacement: "***"
+  - pattern: '[a-z]+@example\.com'
+    replacement: "[email]"
+`)
+
+	for _, test := range []struct {
+		name     string
+		page     string
+		expected string
+	}{
+		{
+			name:     "attributes are dropped with contents",
+			page:     `<p>Hi</p><div class="secret" data-email="me@example.com" title="Me">Me <b>here</b></div><p>Bye</p>`,
+			expected: `<p>Hi</p><div>[REDACTED]</div><p>Bye</p>`,
+		},
+		{
+			name:     "nested elements of the same name",
+			page:     `<div class="secret"><div>a</div><div>b</div></div><div>c</div>`,
+			expected: `<div>[REDACTED]</div><div>c</div>`,
+		},
+		{
+			name:     "unclosed element ends with its parent",
+			page:     `<section><span class="secret">a<i>b</section><p>visible</p>`,
+			expected: `<section><span>[REDACTED]</span></section><p>visible</p>`,
+		},
+		{
+			name:     "unclosed element ends with the page",
+			page:     `<p>visible</p><div class="secret">a<p>b`,
+			expected: `<p>visible</p><div>[REDACTED]</div>`,
+		},
+		{
+			name:     "void element is replaced as a whole",
+			page:     `<form><input id="token" value="abc"><input id="name"></form>`,
+			expected: `<form>***<input id="name"></form>`,
+		},
+		{
+			name:     "self-closing element is replaced as a whole",
+			page:     `<svg><path class="secret" d="M0"/></svg>`,
+			expected: `<svg>[REDACTED]</svg>`,
+		},
+		{
+			name:     "patterns apply to attributes and text",
+			page:     `<a href="mailto:me@example.com">me@example.com</a>`,
+			expected: `<a href="mailto:[email]">[email]</a>`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			redacted := string(rules.apply([]byte(test.page)))
+			if redacted != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, redacted)
+			}
+		})
+	}
+}
+
+func TestRedactionAppliesToTextFiles(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><link rel="stylesheet" href="/style.css"><script src="/app.js"></script></head><body>me@example.com</body></html>`))
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("X-Author", "me@example.com")
+		w.Write([]byte(`/* by me@example.com */ body { color: red }`))
+	})
+	mux.HandleFunc("/app.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Header().Set("Content-Encoding", "gzip")
+		compressor := gzip.NewWriter(w)
+		compressor.Write([]byte(`var author = "me@example.com";`))
+		compressor.Close()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	err := os.WriteFile(rulesPath, []byte("rules:\n  - pattern: 'me@example\\.com'\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FormatHTML, FormatWARC} {
+		t.Run(format, func(t *testing.T) {
+			outputDir := t.TempDir()
+			harPath := filepath.Join(t.TempDir(), "requests.har")
+			pageSaver, err := New(Options{
+				OutputDir:      outputDir,
+				Format:         format,
+				NoRobots:       true,
+				RedactionRules: rulesPath,
+				HARPath:        harPath,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pageSaver.Close()
+
+			var pageURL string = server.URL + "/"
+			if format == FormatWARC {
+				// saved page files are named after it, records hold only what is in them
+				pageURL += "?contact=me@example.com"
+			}
+			_, err = pageSaver.Save(context.Background(), pageURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			harFile, err := os.ReadFile(harPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(harFile, []byte("me@example.com")) {
+				t.Error("HAR file is not redacted")
+			}
+
+			err = filepath.Walk(outputDir, func(filePath string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+
+				if strings.HasSuffix(filePath, ".warc.gz") {
+					var blocks []byte
+					for _, record := range readTestWARC(t, filePath) {
+						blocks = append(blocks, record.fields.Get("WARC-Target-URI")...)
+						blocks = append(blocks, record.block...)
+					}
+					if !bytes.Contains(blocks, []byte(`var author = "[REDACTED]";`)) {
+						t.Error("script is not in the WARC file decoded and redacted")
+					}
+					if bytes.Contains(blocks, []byte("me@example.com")) {
+						t.Errorf("%s is not redacted", filePath)
+					}
+					return nil
+				}
+
+				contents, err := os.ReadFile(filePath)
+				if err != nil {
+					return err
+				}
+				if bytes.Contains(contents, []byte("me@example.com")) {
+					t.Errorf("%s is not redacted", filePath)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
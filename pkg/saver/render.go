@@ -0,0 +1,163 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// How long the network has to stay quiet for the page to count as rendered
+const networkIdleTime time.Duration = 500 * time.Millisecond
+
+// Requests the page has in flight
+type networkActivity struct {
+	mutex        sync.Mutex
+	inFlight     map[network.RequestID]bool
+	lastActivity time.Time
+	// scripts and WebAssembly modules the page loaded, including ones its scripts
+	// asked for at runtime, which are nowhere to be found in the DOM
+	loaded []string
+}
+
+func (activity *networkActivity) listen(event interface{}) {
+	activity.mutex.Lock()
+	defer activity.mutex.Unlock()
+
+	switch event := event.(type) {
+	case *network.EventResponseReceived:
+		// worker scripts are not always reported as scripts, but they are served as ones
+		mimeType := event.Response.MimeType
+		if event.Type == network.ResourceTypeScript || strings.Contains(mimeType, "javascript") || mimeType == "application/wasm" {
+			activity.loaded = append(activity.loaded, event.Response.URL)
+		}
+		return
+	case *network.EventRequestWillBeSent:
+		activity.inFlight[event.RequestID] = true
+	case *network.EventLoadingFinished:
+		delete(activity.inFlight, event.RequestID)
+	case *network.EventLoadingFailed:
+		delete(activity.inFlight, event.RequestID)
+	default:
+		return
+	}
+	activity.lastActivity = time.Now()
+}
+
+func (activity *networkActivity) idle() bool {
+	activity.mutex.Lock()
+	defer activity.mutex.Unlock()
+
+	return len(activity.inFlight) == 0 && time.Since(activity.lastActivity) >= networkIdleTime
+}
+
+// Wait until the page has made no requests for a while
+func waitNetworkIdle(activity *networkActivity) chromedp.ActionFunc {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for !activity.idle() {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("page did not stop loading in time")
+			case <-ticker.C:
+			}
+		}
+
+		return nil
+	}
+}
+
+// Make the browser send configured headers with every request of the tab
+func (session *session) browserHeaders() chromedp.Action {
+	var headers network.Headers = make(network.Headers)
+	for key := range session.options.Headers {
+		headers[key] = session.options.Headers.Get(key)
+	}
+
+	return network.SetExtraHTTPHeaders(headers)
+}
+
+// Load the page in the headless browser, let its scripts run and return the resulting DOM
+// with the scripts and WebAssembly modules it loaded on the way.
+// Waits for network-idle, or for an element matching the wait selector if it is set.
+// script, if set, is run in the page then, and the page is given time to settle again.
+// Text with too little contrast is looked for as well if accessibility is checked
+func (session *session) renderPage(link string, script string) ([]byte, []*url.URL, []contrastHint, error) {
+	tab, closeTab, err := session.browser.newTab(session.ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to start headless browser: %s", err)
+	}
+	defer closeTab()
+
+	activity := &networkActivity{
+		inFlight:     make(map[network.RequestID]bool),
+		lastActivity: time.Now(),
+	}
+	chromedp.ListenTarget(tab, activity.listen)
+
+	var wait chromedp.Action = waitNetworkIdle(activity)
+	if session.options.RenderWaitFor != "" {
+		wait = chromedp.WaitReady(session.options.RenderWaitFor, chromedp.ByQuery)
+	}
+
+	var actions []chromedp.Action = []chromedp.Action{
+		network.Enable(),
+		session.browserHeaders(),
+		chromedp.Navigate(link),
+		wait,
+	}
+	if script != "" {
+		// wrapped so that the script may await, e.g. for elements it has clicked to load
+		actions = append(actions,
+			chromedp.Evaluate("(async () => {\n"+script+"\n})()", nil, func(params *runtime.EvaluateParams) *runtime.EvaluateParams {
+				return params.WithAwaitPromise(true)
+			}),
+			waitNetworkIdle(activity),
+		)
+	}
+
+	var contrast []contrastHint
+	if session.options.Accessibility {
+		actions = append(actions, chromedp.Evaluate(contrastScript, &contrast))
+	}
+
+	var dom string
+	actions = append(actions, chromedp.OuterHTML("html", &dom, chromedp.ByQuery))
+	err = chromedp.Run(tab, actions...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	activity.mutex.Lock()
+	defer activity.mutex.Unlock()
+
+	var loaded []*url.URL
+	for _, loadedURL := range activity.loaded {
+		parsedURL, err := url.Parse(loadedURL)
+		if err == nil && isFetchableScheme(parsedURL.Scheme) {
+			loaded = append(loaded, parsedURL)
+		}
+	}
+
+	return []byte("<!DOCTYPE html>\n" + dom), loaded, contrast, nil
+}
@@ -0,0 +1,187 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Whether a page file made it into the output
+type AssetStatus string
+
+const (
+	AssetSaved   AssetStatus = "saved"
+	AssetFailed  AssetStatus = "failed"
+	AssetSkipped AssetStatus = "skipped"
+)
+
+// What happened to a single page file
+type AssetOutcome struct {
+	URL       string
+	LocalPath string
+	Kind      AssetKind
+	// Content-Type the server responded with
+	ContentType string
+	// Media type the file turned out to be, see Classify
+	MIMEType string
+	Status   AssetStatus
+	Reason   string
+	Size     int64
+	Duration time.Duration
+}
+
+// What happened while saving a page
+type PageReport struct {
+	// Where the page came from, after redirects
+	URL string
+	// URL that was asked for, if it redirected elsewhere
+	RequestedURL string
+	// Relative to the output directory
+	OutputPath string
+	// Citation, accessibility report and PDF written for the page. Relative to the output
+	// directory, or paths inside the archive if the output is one
+	Extras   []string
+	Metadata PageMetadata
+	Size     int64
+	Started  time.Time
+	Duration time.Duration
+	Assets   []AssetOutcome
+}
+
+// Number of assets with given status
+func (report *PageReport) Count(status AssetStatus) int {
+	var count int = 0
+	for _, asset := range report.Assets {
+		if asset.Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Page size with all of its saved files
+func (report *PageReport) TotalSize() int64 {
+	var total int64 = report.Size
+	for _, asset := range report.Assets {
+		total += asset.Size
+	}
+
+	return total
+}
+
+// Page files that failed to download
+type AssetErrors []AssetOutcome
+
+// Number of failures per host followed by the reason of each one
+func (errs AssetErrors) Error() string {
+	var hosts []string
+	var perHost map[string]int = make(map[string]int)
+	for _, failure := range errs {
+		var host string = failure.URL
+		if link, err := url.Parse(failure.URL); err == nil {
+			host = link.Host
+		}
+		if perHost[host] == 0 {
+			hosts = append(hosts, host)
+		}
+		perHost[host]++
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "%d file(s) failed to download (", len(errs))
+	for index, host := range hosts {
+		if index > 0 {
+			message.WriteString(", ")
+		}
+		fmt.Fprintf(&message, "%s: %d", host, perHost[host])
+	}
+	message.WriteString(")")
+
+	for _, failure := range errs {
+		fmt.Fprintf(&message, "\n  - %s", failure.Reason)
+	}
+
+	return message.String()
+}
+
+// Failed page files as an AssetErrors, nil if everything has been downloaded or skipped on purpose
+func (report *PageReport) Err() error {
+	var failures AssetErrors
+	for _, asset := range report.Assets {
+		if asset.Status == AssetFailed {
+			failures = append(failures, asset)
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return failures
+}
+
+// What a Save call did
+type Result struct {
+	// Directory everything has been saved into
+	OutputDir string
+	// Saved pages, the requested one first
+	Pages []*PageReport
+	// HAR file, if one has been written. Its name in the encrypted archive when encrypting
+	HARPath string
+	// CDXJ index of the WARC file, if one has been written, relative to OutputDir
+	// (or its name in the encrypted archive when encrypting)
+	IndexPath string
+	Started   time.Time
+	Duration  time.Duration
+}
+
+// Absolute paths of saved pages' outputs, without duplicates when they share an archive
+func (result *Result) OutputPaths() []string {
+	var paths []string
+	var seen map[string]bool = make(map[string]bool)
+	for _, page := range result.Pages {
+		outputPath := filepath.Join(result.OutputDir, page.OutputPath)
+		if seen[outputPath] {
+			continue
+		}
+		seen[outputPath] = true
+		paths = append(paths, outputPath)
+	}
+
+	return paths
+}
+
+// Size of all saved pages with their files
+func (result *Result) TotalSize() int64 {
+	var total int64 = 0
+	for _, page := range result.Pages {
+		total += page.TotalSize()
+	}
+
+	return total
+}
+
+// Number of assets with given status across all pages
+func (result *Result) Count(status AssetStatus) int {
+	var count int = 0
+	for _, page := range result.Pages {
+		count += page.Count(status)
+	}
+
+	return count
+}
@@ -0,0 +1,152 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Delay before the first retry, doubled with every next one
+	retryBaseDelay time.Duration = 500 * time.Millisecond
+	// Longest Retry-After gospa is willing to wait for
+	maxRetryAfter time.Duration = 2 * time.Minute
+	// Failures within breakerWindow that make gospa give up on a host
+	breakerThreshold int           = 5
+	breakerWindow    time.Duration = 30 * time.Second
+	// How long to leave a host alone before trying it again
+	breakerCooldown time.Duration = time.Minute
+)
+
+// Returned instead of making a request to a host that keeps failing
+var errHostUnavailable error = errors.New("host keeps failing, not trying it for now")
+
+// Whether a response with this status is worth another try
+func isRetryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// Delay before retry number attempt (starting with 0). Randomized between half and full delay,
+// so that downloads failing together do not retry in lockstep
+func retryBackoff(attempt uint) time.Duration {
+	if attempt > 6 {
+		attempt = 6
+	}
+
+	delay := retryBaseDelay << attempt
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Delay the server asked for in Retry-After of a 429 or 503 response, capped at maxRetryAfter
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	if response == nil || (response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(response.Header.Get("Retry-After"))
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+
+	return delay, true
+}
+
+// Recent failures of a single host
+type hostBreaker struct {
+	failures  []time.Time
+	openUntil time.Time
+}
+
+// Per-host circuit breakers. A host that fails breakerThreshold times within breakerWindow
+// is not contacted for breakerCooldown, after which a single request is let through to probe it
+type breakerSet struct {
+	mutex sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+func newBreakerSet() *breakerSet {
+	return &breakerSet{hosts: make(map[string]*hostBreaker)}
+}
+
+// Whether a request to the host may be made now
+func (breakers *breakerSet) allow(host string) bool {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	breaker, ok := breakers.hosts[host]
+	if !ok || breaker.openUntil.IsZero() {
+		return true
+	}
+
+	if time.Now().Before(breaker.openUntil) {
+		return false
+	}
+
+	// half-open: let this one through, another failure opens the breaker right away
+	breaker.openUntil = time.Time{}
+	breaker.failures = breaker.failures[:0]
+	for index := 0; index < breakerThreshold-1; index++ {
+		breaker.failures = append(breaker.failures, time.Now())
+	}
+
+	return true
+}
+
+func (breakers *breakerSet) failed(host string) {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	breaker, ok := breakers.hosts[host]
+	if !ok {
+		breaker = &hostBreaker{}
+		breakers.hosts[host] = breaker
+	}
+
+	now := time.Now()
+	var recent []time.Time
+	for _, failure := range breaker.failures {
+		if now.Sub(failure) < breakerWindow {
+			recent = append(recent, failure)
+		}
+	}
+	breaker.failures = append(recent, now)
+
+	if len(breaker.failures) >= breakerThreshold {
+		breaker.openUntil = now.Add(breakerCooldown)
+	}
+}
+
+func (breakers *breakerSet) succeeded(host string) {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	delete(breakers.hosts, host)
+}
@@ -0,0 +1,30 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import "net/url"
+
+// Decides what references to downloaded files look like in saved pages and stylesheets,
+// e.g. to point them at a CDN or an internal proxy instead of the local copies
+type Rewriter interface {
+	// Replacement for a reference to original, which has been saved to localPath
+	// (relative to the output directory, slash-separated and escaped for use in a URL)
+	Rewrite(original *url.URL, localPath string) string
+}
+
+// Function used as a Rewriter
+type RewriterFunc func(original *url.URL, localPath string) string
+
+func (rewrite RewriterFunc) Rewrite(original *url.URL, localPath string) string {
+	return rewrite(original, localPath)
+}
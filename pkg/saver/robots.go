@@ -0,0 +1,246 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Name gospa looks for in robots.txt user-agent lines, falling back to "*"
+	robotsAgent string = "gospa"
+	// Most of robots.txt that is read, as RFC 9309 allows crawlers to stop at 500KiB
+	maxRobotsSize int64 = 500 * 1024
+	// Longest Crawl-delay gospa is willing to keep
+	maxCrawlDelay time.Duration = time.Minute
+)
+
+// Allow or Disallow line of robots.txt
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// Rules of robots.txt that apply to gospa
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	// nothing may be fetched, as robots.txt could not be read because of the server
+	disallowAll bool
+}
+
+// User-agent group of robots.txt
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// Parse robots.txt and pick the group for gospa, or the one for everyone if there is none
+func parseRobots(contents io.Reader) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	// whether the group being read still only has user-agent lines
+	var collectingAgents bool = false
+
+	scanner := bufio.NewScanner(contents)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !collectingAgents {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+				collectingAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			collectingAgents = false
+			if current == nil || (key == "disallow" && value == "") {
+				// an empty Disallow allows everything
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			collectingAgents = false
+			seconds, err := strconv.ParseFloat(value, 64)
+			if current == nil || err != nil || seconds < 0 {
+				continue
+			}
+			current.crawlDelay = time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	var chosen []*robotsGroup
+	for _, wanted := range []string{robotsAgent, "*"} {
+		for _, group := range groups {
+			for _, agent := range group.agents {
+				if agent == wanted {
+					chosen = append(chosen, group)
+					break
+				}
+			}
+		}
+		if len(chosen) > 0 {
+			break
+		}
+	}
+
+	// groups for the same agent are combined
+	var rules robotsRules
+	for _, group := range chosen {
+		rules.rules = append(rules.rules, group.rules...)
+		if group.crawlDelay > rules.crawlDelay {
+			rules.crawlDelay = group.crawlDelay
+		}
+	}
+	if rules.crawlDelay > maxCrawlDelay {
+		rules.crawlDelay = maxCrawlDelay
+	}
+
+	return &rules
+}
+
+// Whether robots.txt pattern matches path. "*" matches any sequence of characters, "$" the end of path
+func robotsPatternMatches(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+
+	for index, part := range parts[1:] {
+		if index == len(parts)-2 && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+
+		found := strings.Index(rest, part)
+		if found == -1 {
+			return false
+		}
+		rest = rest[found+len(part):]
+	}
+
+	return !anchored || rest == ""
+}
+
+// Whether the page at link may be fetched. The longest matching rule decides, Allow winning ties
+func (rules *robotsRules) allowed(link *url.URL) bool {
+	if rules.disallowAll {
+		return false
+	}
+
+	path := link.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if link.RawQuery != "" {
+		path += "?" + link.RawQuery
+	}
+
+	var allowed bool = true
+	var longest int = -1
+	for _, rule := range rules.rules {
+		if !robotsPatternMatches(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			longest = len(rule.pattern)
+			allowed = rule.allow
+		}
+	}
+
+	return allowed
+}
+
+// robots.txt of every host a crawl visits, fetched once per host
+type robotsCache struct {
+	mutex sync.Mutex
+	hosts map[string]*robotsRules
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{hosts: make(map[string]*robotsRules)}
+}
+
+// Rules of link's host, fetching its robots.txt the first time. The host's Crawl-delay
+// is then kept between all requests to it
+func (session *session) robotsRules(link *url.URL) *robotsRules {
+	session.robots.mutex.Lock()
+	defer session.robots.mutex.Unlock()
+
+	if rules, ok := session.robots.hosts[link.Host]; ok {
+		return rules
+	}
+
+	rules := session.fetchRobots(link)
+	session.robots.hosts[link.Host] = rules
+	if rules.crawlDelay > 0 {
+		session.pacer.slowDown(link.Host, rules.crawlDelay)
+	}
+
+	return rules
+}
+
+// Fetch and parse robots.txt of link's host. Missing or unreachable robots.txt allows everything,
+// a server error forbids everything, as RFC 9309 says
+func (session *session) fetchRobots(link *url.URL) *robotsRules {
+	if isFTPScheme(link.Scheme) {
+		return &robotsRules{}
+	}
+
+	robotsURL := url.URL{Scheme: link.Scheme, User: link.User, Host: link.Host, Path: "/robots.txt"}
+	response, err := session.fetch(robotsURL.String())
+	if err != nil {
+		if response != nil {
+			response.Body.Close()
+		}
+		return &robotsRules{}
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 500:
+		session.warn("%s is failing (%s), not saving pages of %s", robotsURL.String(), response.Status, link.Host)
+		return &robotsRules{disallowAll: true}
+	case response.StatusCode >= 400:
+		return &robotsRules{}
+	}
+
+	return parseRobots(io.LimitReader(response.Body, maxRobotsSize))
+}
+
+// Whether the crawl may save the page at link
+func (session *session) robotsAllow(link *url.URL) bool {
+	if session.robots == nil {
+		return true
+	}
+
+	return session.robotsRules(link).allowed(link)
+}
@@ -0,0 +1,726 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How deep to follow @import chains in stylesheets
+const maxStylesheetImportDepth int = 8
+
+// Base name for page's output files, derived from its URL. Pages that differ by query only get different names
+func pageBaseName(from *url.URL) string {
+	baseName := fmt.Sprintf(
+		"%s_%s",
+		from.Host,
+		strings.ReplaceAll(readablePath(from), "/", "_"),
+	)
+	if query := readableQuery(from); query != "" {
+		baseName += "_" + query
+	}
+
+	return safeFileName(baseName, maxPageBaseNameLength)
+}
+
+// How many page files are downloaded at once unless told otherwise
+const DefaultConcurrency int = 4
+
+// How many redirects a request may follow unless told otherwise
+const DefaultMaxRedirects int = 10
+
+// Biggest page file to hold in memory unless told otherwise
+const DefaultMemoryThreshold int64 = 8 * 1024 * 1024
+
+// Stylesheet whose references are still being downloaded. It is written once they are done,
+// so that references to files that failed to download keep pointing online
+type pendingStylesheet struct {
+	contents []byte
+	url      *url.URL
+	file     io.WriteCloser
+}
+
+// Script whose worker and WebAssembly references are still being downloaded, written once they are done
+type pendingScript struct {
+	contents []byte
+	url      *url.URL
+	file     io.WriteCloser
+}
+
+// Downloads page's files into its files directory and remembers where each of them ended up
+type assetDownloader struct {
+	session  *session
+	out      output
+	filesDir string
+	// page the files are of, which worker scripts are resolved against
+	pageURL *url.URL
+
+	mutex sync.Mutex
+	// absolute URL -> file name inside filesDir, and lowercased file name -> what has taken it
+	saved       map[string]string
+	taken       map[string]string
+	outcomes    []AssetOutcome
+	stylesheets []pendingStylesheet
+	scripts     []pendingScript
+
+	// downloads waiting for a free worker, in order
+	queue   []downloadJob
+	workers int
+	// running downloads per host
+	running map[string]int
+	// signalled when a job is queued or a download is over
+	wake *sync.Cond
+	// queued and running downloads
+	wg sync.WaitGroup
+}
+
+type downloadJob struct {
+	link        *url.URL
+	name        string
+	importDepth int
+}
+
+func newAssetDownloader(session *session, out output, filesDir string, pageURL *url.URL) *assetDownloader {
+	downloader := &assetDownloader{
+		session:  session,
+		out:      out,
+		filesDir: filesDir,
+		pageURL:  pageURL,
+		saved:    make(map[string]string),
+		taken:    make(map[string]string),
+		running:  make(map[string]int),
+	}
+	downloader.wake = sync.NewCond(&downloader.mutex)
+
+	return downloader
+}
+
+// Local file name for the link, derived from the last element of its path and its query,
+// so that files such as /render?img=a and /render?img=b are told apart by name
+func assetFileName(link *url.URL) string {
+	name := path.Base(readablePath(link))
+	if name == "/" || name == "." || name == "" {
+		name = "index"
+	}
+	if query := readableQuery(link); query != "" {
+		name = withNameSuffix(name, query)
+	}
+
+	return safeFileName(name, maxAssetNameLength)
+}
+
+// Reserve local file name for the link. fresh is false if it has already been taken care of
+func (downloader *assetDownloader) reserve(link *url.URL) (name string, fresh bool) {
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	name, ok := downloader.saved[link.String()]
+	if ok {
+		return name, false
+	}
+
+	name = downloader.claim(assetFileName(link), link.String())
+	downloader.saved[link.String()] = name
+
+	return name, true
+}
+
+// Move the link to another local file name, which is returned as it may have to be told apart from others
+func (downloader *assetDownloader) rename(link *url.URL, name string) string {
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	downloader.unclaim(downloader.saved[link.String()], link.String())
+	name = downloader.claim(name, link.String())
+	downloader.saved[link.String()] = name
+
+	return name
+}
+
+// Forget about the link, so that references to it are not rewritten
+func (downloader *assetDownloader) release(link *url.URL) {
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	downloader.unclaim(downloader.saved[link.String()], link.String())
+	delete(downloader.saved, link.String())
+}
+
+// Local file name of the link if it has been downloaded
+func (downloader *assetDownloader) savedName(link *url.URL) (string, bool) {
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	name, ok := downloader.saved[link.String()]
+	return name, ok
+}
+
+// Queue download of a reserved page file. Downloads start in the order they were queued,
+// except that ones from hosts at their limit let others go first
+func (downloader *assetDownloader) enqueue(link *url.URL, name string, importDepth int) {
+	downloader.wg.Add(1)
+
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	downloader.queue = append(downloader.queue, downloadJob{link: link, name: name, importDepth: importDepth})
+	if downloader.workers < downloader.session.options.Concurrency {
+		downloader.workers++
+		go downloader.work()
+	} else {
+		downloader.wake.Signal()
+	}
+}
+
+// Index of the first queued job whose host is below its limit, -1 if there is none. Must be called with mutex held
+func (downloader *assetDownloader) nextJob() int {
+	limit := downloader.session.options.HostConcurrency
+	for index, job := range downloader.queue {
+		if limit <= 0 || downloader.running[job.link.Host] < limit {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// Take jobs off the queue until it is empty
+func (downloader *assetDownloader) work() {
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	for {
+		index := downloader.nextJob()
+		if index == -1 {
+			if len(downloader.queue) == 0 {
+				downloader.workers--
+				return
+			}
+
+			// everything left is for busy hosts, one of their downloads will be over soon
+			downloader.wake.Wait()
+			continue
+		}
+
+		job := downloader.queue[index]
+		downloader.queue = append(downloader.queue[:index], downloader.queue[index+1:]...)
+		downloader.running[job.link.Host]++
+		downloader.mutex.Unlock()
+
+		downloader.download(job.link, job.name, job.importDepth)
+
+		downloader.mutex.Lock()
+		downloader.running[job.link.Host]--
+		downloader.wake.Broadcast()
+		downloader.wg.Done()
+	}
+}
+
+// Wait for all queued downloads, including ones discovered along the way, then write stylesheets and scripts
+func (downloader *assetDownloader) finish() {
+	downloader.wg.Wait()
+
+	for _, stylesheet := range downloader.stylesheets {
+		stylesheet.file.Write(downloader.redactText(downloader.rewriteStylesheet(stylesheet.contents, stylesheet.url, false)))
+		stylesheet.file.Close()
+	}
+	downloader.stylesheets = nil
+
+	for _, script := range downloader.scripts {
+		script.file.Write(downloader.redactText(downloader.rewriteScript(script.contents, script.url, false)))
+		script.file.Close()
+	}
+	downloader.scripts = nil
+}
+
+func (downloader *assetDownloader) record(outcome AssetOutcome) {
+	downloader.mutex.Lock()
+	downloader.outcomes = append(downloader.outcomes, outcome)
+	downloader.mutex.Unlock()
+
+	if downloader.session.options.OnAsset != nil {
+		downloader.session.options.OnAsset(outcome)
+	}
+}
+
+// Why files of the kind are not downloaded at all, empty if they are
+func (downloader *assetDownloader) skipReason(kind AssetKind) string {
+	switch {
+	case downloader.session.options.Lite && skippedInLiteMode(kind):
+		return "not downloaded in lite mode"
+	case (downloader.session.options.Format == FormatMarkdown || downloader.session.options.Format == FormatObsidian) && kind != AssetImage && kind != AssetDocument && kind != AssetOther:
+		// only images and links make it into Markdown
+		return "not used by Markdown"
+	}
+
+	return ""
+}
+
+// Text with redaction rules applied, if there are any
+func (downloader *assetDownloader) redactText(text []byte) []byte {
+	if downloader.session.rules == nil {
+		return text
+	}
+
+	return downloader.session.rules.applyText(text)
+}
+
+// Whether a file of the kind and Content-Type has to be processed as a whole before being written
+func (downloader *assetDownloader) needsContents(link *url.URL, kind AssetKind, contentType string) bool {
+	if downloader.session.rules != nil && isRedactableText(contentType) {
+		return true
+	}
+
+	switch kind {
+	case AssetStylesheet, AssetScript:
+		return !isWebAssembly(link, contentType)
+	case AssetImage:
+		// size cap is checked on the whole image
+		return downloader.session.options.Lite
+	default:
+		return false
+	}
+}
+
+// Download a single reserved page file into the files directory
+func (downloader *assetDownloader) download(link *url.URL, name string, importDepth int) (outcome AssetOutcome) {
+	outcome = AssetOutcome{
+		URL:       link.String(),
+		LocalPath: path.Join(downloader.filesDir, name),
+		Kind:      ClassifyLink(link),
+		Status:    AssetFailed,
+	}
+
+	started := time.Now()
+	defer func() {
+		outcome.Duration = time.Since(started)
+		if outcome.Status != AssetSaved {
+			downloader.release(link)
+		}
+		downloader.record(outcome)
+	}()
+
+	response, err := downloader.session.fetchAsset(link)
+	if errors.Is(err, errHostUnavailable) {
+		outcome.Status = AssetSkipped
+		outcome.Reason = fmt.Sprintf("%s: %s", link.Host, err)
+		return outcome
+	}
+	if err != nil {
+		outcome.Reason = fmt.Sprintf("failed to receive response from %s: %s", link.String(), err)
+		return outcome
+	}
+	defer response.Body.Close()
+	outcome.ContentType = response.Header.Get("Content-Type")
+	asset := Classify(link, outcome.ContentType)
+	outcome.Kind = asset.Kind
+	outcome.MIMEType = asset.MIMEType
+
+	if isEndlessContentType(outcome.ContentType) {
+		outcome.Status = AssetSkipped
+		outcome.Reason = fmt.Sprintf("%s is a stream without end", link.String())
+		return outcome
+	}
+
+	if betterName := responseFileName(name, response.Header); betterName != name {
+		name = downloader.rename(link, betterName)
+		outcome.LocalPath = path.Join(downloader.filesDir, name)
+	}
+
+	capped := newCappedBody(response.Body, downloader.session.options.MaxAssetSize, downloader.session.options.MaxAssetTime)
+	defer capped.Stop()
+
+	var body io.Reader = capped
+	if downloader.session.options.Lite && outcome.Kind == AssetImage {
+		if response.ContentLength > liteMaxImageSize {
+			outcome.Status = AssetSkipped
+			outcome.Reason = "image is too big for lite mode"
+			return outcome
+		}
+		body = io.LimitReader(capped, liteMaxImageSize+1)
+	}
+
+	threshold := downloader.session.options.MemoryThreshold
+	contents, err := io.ReadAll(io.LimitReader(body, threshold+1))
+	if cutOff(&outcome, err) {
+		return outcome
+	}
+	if err != nil {
+		outcome.Reason = fmt.Sprintf("failed to read response from %s: %s", link.String(), err)
+		return outcome
+	}
+
+	if expectsNonHTML(outcome.Kind) && looksLikeHTMLDocument(contents) {
+		outcome.Reason = fmt.Sprintf("%s is a web page instead of a file, such as a login or error page", link.String())
+		return outcome
+	}
+
+	if int64(len(contents)) > threshold && !downloader.needsContents(link, outcome.Kind, outcome.ContentType) {
+		// too big to hold in memory, the rest goes straight into the file
+		outputFile, err := downloader.out.Create(outcome.LocalPath)
+		if err != nil {
+			outcome.Reason = fmt.Sprintf("failed to create output file for %s: %s", link.String(), err)
+			return outcome
+		}
+
+		written, err := io.Copy(outputFile, io.MultiReader(bytes.NewReader(contents), body))
+		if err != nil {
+			// a partial file would be taken for the whole one, the page keeps pointing online instead
+			outputFile.Abort()
+			if !cutOff(&outcome, err) {
+				outcome.Reason = fmt.Sprintf("failed to download %s: %s", link.String(), err)
+			}
+			return outcome
+		}
+		closeErr := outputFile.Close()
+		if closeErr != nil {
+			outcome.Reason = fmt.Sprintf("failed to write %s: %s", link.String(), closeErr)
+			return outcome
+		}
+
+		outcome.Status = AssetSaved
+		outcome.Size = written
+
+		return outcome
+	}
+
+	rest, err := io.ReadAll(body)
+	if cutOff(&outcome, err) {
+		return outcome
+	}
+	if err != nil {
+		outcome.Reason = fmt.Sprintf("failed to read response from %s: %s", link.String(), err)
+		return outcome
+	}
+	contents = append(contents, rest...)
+
+	if downloader.session.options.Lite && outcome.Kind == AssetImage && int64(len(contents)) > liteMaxImageSize {
+		outcome.Status = AssetSkipped
+		outcome.Reason = "image is too big for lite mode"
+		return outcome
+	}
+
+	var script bool = outcome.Kind == AssetScript && !isWebAssembly(link, outcome.ContentType)
+	if script && downloader.session.options.NoServiceWorkers {
+		contents = neutralizeServiceWorkers(contents)
+	}
+
+	outputFile, err := downloader.out.Create(outcome.LocalPath)
+	if err != nil {
+		outcome.Reason = fmt.Sprintf("failed to create output file for %s: %s", link.String(), err)
+		return outcome
+	}
+
+	if outcome.Kind == AssetStylesheet {
+		downloader.discoverStylesheetReferences(contents, link, importDepth)
+
+		downloader.mutex.Lock()
+		downloader.stylesheets = append(downloader.stylesheets, pendingStylesheet{
+			contents: contents,
+			url:      link,
+			file:     outputFile,
+		})
+		downloader.mutex.Unlock()
+	} else if script {
+		downloader.discoverScriptReferences(contents, link)
+
+		downloader.mutex.Lock()
+		downloader.scripts = append(downloader.scripts, pendingScript{
+			contents: contents,
+			url:      link,
+			file:     outputFile,
+		})
+		downloader.mutex.Unlock()
+	} else {
+		if downloader.session.rules != nil && isRedactableText(outcome.ContentType) {
+			contents = downloader.session.rules.applyBody(outcome.ContentType, contents)
+		}
+		outputFile.Write(contents)
+		outputFile.Close()
+	}
+
+	outcome.Status = AssetSaved
+	outcome.Size = int64(len(contents))
+
+	return outcome
+}
+
+// matches url(file), url("file") and url('file')
+var cssURLRegexp *regexp.Regexp = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+
+// matches @import "file" and @import 'file'. @import url(file) is handled by cssURLRegexp
+var cssImportRegexp *regexp.Regexp = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// <style> element of a page with its contents
+var inlineStyleRegexp *regexp.Regexp = regexp.MustCompile(`(?is)<style\b[^>]*>(.*?)</style>`)
+
+// First non-empty submatch
+func firstSubmatch(submatches [][]byte) string {
+	for _, submatch := range submatches[1:] {
+		if len(submatch) > 0 {
+			return string(submatch)
+		}
+	}
+
+	return ""
+}
+
+// Absolute link of a downloadable file a stylesheet or script references relative to base, nil if it is not one
+func fileReferenceLink(reference string, base *url.URL) *url.URL {
+	reference = strings.TrimSpace(reference)
+	if reference == "" || strings.HasPrefix(reference, "#") || strings.HasPrefix(strings.ToLower(reference), "data:") {
+		return nil
+	}
+
+	parsedReference, err := url.Parse(reference)
+	if err != nil {
+		return nil
+	}
+
+	absoluteLink := base.ResolveReference(parsedReference)
+	if !isFetchableScheme(absoluteLink.Scheme) {
+		return nil
+	}
+
+	return absoluteLink
+}
+
+// Same link without the fragment, which is what gets downloaded
+func withoutFragment(link *url.URL) *url.URL {
+	var fileLink url.URL = *link
+	fileLink.Fragment = ""
+	fileLink.RawFragment = ""
+
+	return &fileLink
+}
+
+// References of the stylesheet, both url() and @import ones
+func stylesheetReferences(stylesheet []byte) []string {
+	var references []string
+	for _, match := range cssImportRegexp.FindAllSubmatch(stylesheet, -1) {
+		references = append(references, firstSubmatch(match))
+	}
+	for _, match := range cssURLRegexp.FindAllSubmatch(stylesheet, -1) {
+		references = append(references, firstSubmatch(match))
+	}
+
+	return references
+}
+
+// Queue downloads of files referenced by url() and @import in a freshly downloaded stylesheet
+func (downloader *assetDownloader) discoverStylesheetReferences(stylesheet []byte, stylesheetURL *url.URL, importDepth int) {
+	for _, reference := range stylesheetReferences(stylesheet) {
+		absoluteLink := fileReferenceLink(reference, stylesheetURL)
+		if absoluteLink == nil {
+			continue
+		}
+
+		kind := ClassifyLink(absoluteLink)
+		if downloader.skipReason(kind) != "" || (kind == AssetStylesheet && importDepth >= maxStylesheetImportDepth) {
+			// stays online
+			continue
+		}
+
+		fileLink := withoutFragment(absoluteLink)
+		name, fresh := downloader.reserve(fileLink)
+		if fresh {
+			downloader.enqueue(fileLink, name, importDepth+1)
+		}
+	}
+}
+
+// What a stylesheet reference should become once downloads are over: local copy if there is one, original otherwise.
+// References of inline stylesheets are made relative to the page
+func (downloader *assetDownloader) localStylesheetReference(reference string, stylesheetURL *url.URL, inline bool) string {
+	absoluteLink := fileReferenceLink(reference, stylesheetURL)
+	if absoluteLink == nil {
+		return strings.TrimSpace(reference)
+	}
+
+	name, ok := downloader.savedName(withoutFragment(absoluteLink))
+	if !ok {
+		return absoluteLink.String()
+	}
+
+	if rewriter := downloader.session.options.Rewriter; rewriter != nil {
+		replacement := rewriter.Rewrite(withoutFragment(absoluteLink), escapePath(path.Join(downloader.filesDir, name)))
+		if absoluteLink.Fragment != "" {
+			replacement += "#" + absoluteLink.EscapedFragment()
+		}
+		return replacement
+	}
+
+	// the stylesheet itself lives in the same files directory
+	var local string = url.PathEscape(name)
+	if inline {
+		local = localReference(path.Join(downloader.filesDir, name))
+	}
+	if absoluteLink.Fragment != "" {
+		local += "#" + absoluteLink.EscapedFragment()
+	}
+
+	return local
+}
+
+// Point references of the stylesheet to local copies of the files
+func (downloader *assetDownloader) rewriteStylesheet(stylesheet []byte, stylesheetURL *url.URL, inline bool) []byte {
+	stylesheet = cssImportRegexp.ReplaceAllFunc(stylesheet, func(match []byte) []byte {
+		reference := firstSubmatch(cssImportRegexp.FindSubmatch(match))
+		return []byte(fmt.Sprintf("@import \"%s\"", downloader.localStylesheetReference(reference, stylesheetURL, inline)))
+	})
+
+	stylesheet = cssURLRegexp.ReplaceAllFunc(stylesheet, func(match []byte) []byte {
+		reference := firstSubmatch(cssURLRegexp.FindSubmatch(match))
+		return []byte(fmt.Sprintf("url(\"%s\")", downloader.localStylesheetReference(reference, stylesheetURL, inline)))
+	})
+
+	return stylesheet
+}
+
+// Queue downloads of files the page's <style> elements reference, such as fonts scripts put there
+func (downloader *assetDownloader) discoverInlineStyleReferences(pageBody []byte) {
+	if downloader.skipReason(AssetStylesheet) != "" {
+		return
+	}
+
+	for _, match := range inlineStyleRegexp.FindAllSubmatch(pageBody, -1) {
+		downloader.discoverStylesheetReferences(match[1], downloader.pageURL, 0)
+	}
+}
+
+// Point references of the page's <style> elements to local copies of the files
+func (downloader *assetDownloader) rewriteInlineStyles(pageBody []byte) []byte {
+	return inlineStyleRegexp.ReplaceAllFunc(pageBody, func(element []byte) []byte {
+		location := inlineStyleRegexp.FindSubmatchIndex(element)
+		var rewritten []byte = make([]byte, 0, len(element))
+		rewritten = append(rewritten, element[:location[2]]...)
+		rewritten = append(rewritten, downloader.rewriteStylesheet(element[location[2]:location[3]], downloader.pageURL, true)...)
+		rewritten = append(rewritten, element[location[3]:]...)
+
+		return rewritten
+	})
+}
+
+// Save page with all its files. Output files are named after baseName
+func (session *session) savePage(pageBody []byte, out output, from *url.URL, baseName string) (*PageReport, error) {
+	var report PageReport = PageReport{
+		URL:     from.String(),
+		Started: time.Now(),
+	}
+
+	// Directory with all file content on the page
+	var pageFilesDirectoryName string = baseName + "_files"
+	downloader := newAssetDownloader(session, out, pageFilesDirectoryName, from)
+
+	srcLinks := session.links.findPageFileContentURLs(pageBody)
+	var kept []*url.URL
+	for _, srcLink := range srcLinks {
+		if reason := downloader.skipReason(ClassifyLink(srcLink)); reason != "" {
+			downloader.record(AssetOutcome{
+				URL:    srcLink.String(),
+				Kind:   ClassifyLink(srcLink),
+				Status: AssetSkipped,
+				Reason: reason,
+			})
+			continue
+		}
+		kept = append(kept, srcLink)
+	}
+	srcLinks = kept
+	sortByPriority(srcLinks, session.priorities)
+
+	for _, srcLink := range srcLinks {
+		resolvedLink := from.ResolveReference(srcLink)
+		name, fresh := downloader.reserve(resolvedLink)
+		if fresh {
+			downloader.enqueue(resolvedLink, name, 0)
+		}
+	}
+	downloader.discoverInlineScriptReferences(pageBody)
+	downloader.discoverInlineStyleReferences(pageBody)
+	for _, loadedLink := range session.renderLoaded[crawlKey(from)] {
+		if downloader.skipReason(ClassifyLink(loadedLink)) != "" {
+			continue
+		}
+		// loaded by the page's scripts at runtime, so that they can be found by their references in the scripts
+		name, fresh := downloader.reserve(withoutFragment(loadedLink))
+		if fresh {
+			downloader.enqueue(withoutFragment(loadedLink), name, 0)
+		}
+	}
+	downloader.finish()
+
+	// Redirect old URLs to local files
+	var localPaths map[string]string = make(map[string]string)
+	for _, srcLink := range srcLinks {
+		resolvedLink := from.ResolveReference(srcLink)
+		name, ok := downloader.savedName(resolvedLink)
+		if !ok {
+			continue
+		}
+
+		if session.options.Rewriter != nil {
+			localPaths[srcLink.String()] = session.options.Rewriter.Rewrite(resolvedLink, escapePath(path.Join(pageFilesDirectoryName, name)))
+		} else {
+			localPaths[srcLink.String()] = localReference(path.Join(pageFilesDirectoryName, name))
+		}
+	}
+	pageBody = session.links.rewriteAssetLinks(pageBody, localPaths)
+	pageBody = downloader.rewriteInlineScripts(pageBody)
+	pageBody = downloader.rewriteInlineStyles(pageBody)
+
+	if session.options.ExplodeDataURIs {
+		pageBody = downloader.explodeDataURIs(pageBody)
+	}
+
+	if session.options.NoServiceWorkers {
+		pageBody = neutralizePageServiceWorkers(pageBody)
+	}
+
+	if session.options.VideoDownloader != "" && isVideoPlatformPage(from) {
+		pageBody = downloader.embedVideo(pageBody, from)
+	}
+
+	// Create page output file
+	report.OutputPath = baseName + ".html"
+	outfile, err := out.Create(report.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer outfile.Close()
+
+	outfile.Write(pageBody)
+	report.Size = int64(len(pageBody))
+	report.Assets = downloader.outcomes
+
+	if session.options.Print {
+		extra, err := writePrintVariant(pageBody, baseName, out)
+		if err != nil {
+			session.warn("Failed to write print variant of %s: %s", from.String(), err)
+		} else {
+			report.Extras = append(report.Extras, extra)
+		}
+	}
+	report.Duration = time.Since(report.Started)
+
+	return &report, nil
+}
@@ -0,0 +1,879 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+// Package saver downloads web pages together with everything they need to be viewed offline.
+// It is what the gospa command is built on
+package saver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+const VERSION string = "v0.1"
+
+// Most pages of a thread saved in thread mode
+const maxThreadPages int = 1000
+
+// How pages are saved. The zero value saves a single page with its files into the working directory
+type Options struct {
+	// Directory to save into, created if missing. Defaults to the working directory
+	OutputDir string
+	// Obsidian or Logseq vault FormatObsidian saves into instead of OutputDir
+	Vault string
+	// Also save pages linked from the page, following links up to this depth
+	Depth uint
+	// Follow links to other hosts when saving recursively
+	SpanHosts bool
+	// Save every page of a paginated thread or listing by following rel="next" links,
+	// up to maxThreadPages, linked to one another. Cannot be used together with Depth
+	Thread bool
+	// Stop fetching pages when saving recursively or in thread mode once this many have been fetched.
+	// 0 means no limit
+	MaxPages int
+	// Stop fetching pages when saving recursively or in thread mode after this long. Pages fetched
+	// by then are saved with all their files. 0 means no limit
+	MaxTime time.Duration
+	// Do not fetch robots.txt of crawled hosts when saving recursively. By default pages it
+	// disallows are not saved and its Crawl-delay is kept between requests
+	NoRobots bool
+	// Comma-separated languages to also save the page in (e.g. "en,ru")
+	Languages string
+	// One of Format* constants. Defaults to FormatHTML
+	Format string
+	// Save page as one self-contained .html. FormatHTML only
+	SingleFile bool
+	// Save page as one MHTML (.mht) archive. FormatHTML only
+	MHTML bool
+	// Embed files smaller than this many bytes as data: URIs. FormatHTML only
+	InlineThreshold int64
+	// Move big inline data: URIs of the page into separate files
+	ExplodeDataURIs bool
+	// Comma-separated age recipients (or "passphrase") to encrypt the output into a .tar.age archive for
+	Encrypt string
+	// Path to YAML file with redaction rules, applied to the page, its text files and HAR and WARC records
+	RedactionRules string
+	// Comma-separated age recipients (or "passphrase") to keep the unredacted page encrypted for
+	RedactKeepOriginal string
+	// Path to YAML file with media types of file extensions, extending and overriding the system's.
+	// They are used for the whole process from then on
+	MediaTypesPath string
+	// One of Srcset* constants. Defaults to SrcsetAll
+	Srcset string
+	// Also download <link rel=alternate> resources
+	Alternates bool
+	// Stub out service worker registration
+	NoServiceWorkers bool
+	// How many page files are downloaded at once. Defaults to DefaultConcurrency
+	Concurrency int
+	// How many of them may come from the same host. 0 means no limit besides Concurrency
+	HostConcurrency int
+	// Page files up to this many bytes are downloaded into memory, bigger ones are streamed
+	// into their files. Crawled pages waiting to be saved are held in memory up to as many bytes
+	// together. Defaults to DefaultMemoryThreshold
+	MemoryThreshold int64
+	// Give up on page files bigger than this many bytes. 0 means no limit
+	MaxAssetSize int64
+	// Give up on page files still downloading after this long, such as live streams. 0 means no limit
+	MaxAssetTime time.Duration
+	// Called with every page file as soon as it is downloaded, failed or skipped.
+	// May be called from several goroutines at once
+	OnAsset func(outcome AssetOutcome)
+	// Called with warnings about things that went wrong without stopping saving, such as pages
+	// that were skipped or extras that could not be written. May be called from several goroutines at once
+	OnMessage func(message string)
+	// Least time between two requests to the same host
+	Delay time.Duration
+	// Most requests per second to the same host. 0 means no limit
+	MaxRPS float64
+	// Up to this much is randomly added to the time between requests to the same host
+	DelayJitter time.Duration
+	// Most bytes per second downloaded by all requests together. 0 means no limit
+	LimitRate int64
+	// Put a table of contents of h1..h3 headings, with stable anchors, at the top of long pages
+	TOC bool
+	// Save MediaWiki articles without edit links and navigation, with image links leading to the images
+	Wiki bool
+	// Do not apply built-in knowledge of popular platforms' markup: where the article is
+	// for Markdown, EPUB and reader pages, which attributes hold lazily loaded images, and where
+	// the raw file behind a GitHub or GitLab file page is
+	NoSiteProfiles bool
+	// Directory with a YAML file of rules for each site: headers, elements to remove, lazy image
+	// attributes, where the article is and a script to run in its pages, which renders them
+	SiteRulesDir string
+	// User agent to send: a preset (chrome, firefox, edge, safari, mobile) or a full value. Go's default if empty
+	UserAgent string
+	// Take turns with desktop browser user agents from request to request instead
+	RotateUserAgent bool
+	// Credentials as user:password to log in with HTTP basic authentication
+	BasicAuth string
+	// Token to send as "Authorization: Bearer TOKEN". Credentials are only sent to the host
+	// of the page being saved, so that they do not leak to CDNs and other sites
+	BearerToken string
+	// Page with the login form to fill in with LoginFields and submit before saving.
+	// Other fields of the form, such as CSRF tokens, are submitted as they are
+	LoginURL    string
+	LoginFields url.Values
+	// How to tell that logging in worked: status:CODE, redirect:TEXT (the URL ended up at contains TEXT)
+	// or selector:SELECTOR (the resulting page has a matching element). Any response below 400 if empty
+	LoginCheck string
+	// Netscape cookies.txt to send cookies from, e.g. exported from a browser to save pages behind a login
+	CookiesPath string
+	// Write cookies, including ones servers set while saving, to this cookies.txt after every Save
+	SaveCookiesPath string
+	// Headers to send with every request. Headers gospa sets itself for a request take precedence
+	Headers http.Header
+	// yt-dlp (or a compatible downloader) to get the video of YouTube, Vimeo and Dailymotion pages with,
+	// which is then played by a <video> at the top of the saved page. It is given the proxy,
+	// user agent, cookies and certificate checks every other request is made with
+	VideoDownloader string
+	// Directory with executable pre-fetch, post-fetch and post-save scripts, run with JSON on stdin
+	HooksDir string
+	// Send every request through this proxy: http://, https:// or socks5://host:port.
+	// Onion services can be saved through Tor's SOCKS proxy, usually socks5://127.0.0.1:9050
+	Proxy string
+	// Do not verify certificates of HTTPS servers. Only for servers you trust anyway
+	Insecure bool
+	// PEM file with certificate authorities to trust in addition to the system ones,
+	// e.g. of a private PKI of internal servers
+	CACertPath string
+	// PEM client certificate and its key to present to servers that require mutual TLS
+	ClientCertPath string
+	ClientKeyPath  string
+	// Give up on a request when the server does not respond, or stops sending, for this long. 0 means never
+	Timeout time.Duration
+	// How many times to retry a request after a network error, 5xx or 429 response
+	Retries uint
+	// How many redirects a request may follow. Defaults to DefaultMaxRedirects, negative means none
+	MaxRedirects int
+	// Refuse redirects to other hosts than the one asked, e.g. to login pages of identity providers
+	SameHostRedirects bool
+	// Compatibility mode for ancient or embedded-device servers
+	Compat bool
+	// Low-bandwidth profile: send Save-Data, skip media and fonts, skip big images
+	Lite bool
+	// Write a HAR file describing every request to this path. When encrypting, it goes
+	// into the encrypted archive under its file name instead
+	HARPath string
+	// Keep Authorization, Proxy-Authorization, Cookie and Set-Cookie values in the HAR file
+	// instead of redacting them
+	HARCredentials bool
+	// Render pages in a headless browser and save the resulting DOM
+	Render bool
+	// CSS selector to wait for when rendering instead of network idle
+	RenderWaitFor string
+	// Render pages that typeset formulas with MathJax or KaTeX in a headless browser and save the formulas
+	// typeset, without the scripts that would typeset them again. Rendered pages are always saved so
+	Math bool
+	// Also print pages to PDF
+	PDF bool
+	// PDF page size: A3, A4, A5, letter, legal, tabloid or WIDTHxHEIGHT. Defaults to A4
+	PDFPageSize string
+	// PDF page margin in mm, cm or in. Defaults to 1cm
+	PDFMargin string
+	// Chrome/Chromium executable. Found automatically if not set
+	BrowserPath string
+	// Also write a citation record: "bibtex" or "csl"
+	Citation string
+	// Save pages as single files, each with a RIS record attaching it, which Zotero imports as
+	// a web page item with the page as its snapshot. FormatHTML only, without MHTML or InlineThreshold
+	Zotero bool
+	// Also write an accessibility report
+	Accessibility bool
+	// Also write a print-friendly copy of the page as *.print.html. FormatHTML or FormatReader, without MHTML
+	Print bool
+	// Comma-separated order in which asset kinds are fetched. Defaults to DefaultPriority
+	Priority string
+	// What references to downloaded files become. They point at the local copies if not set.
+	// FormatHTML only, without SingleFile, MHTML or InlineThreshold, which need the local references
+	Rewriter Rewriter
+}
+
+// Saves web pages. Safe to reuse for many pages; Close it when done
+type Saver struct {
+	options    Options
+	links      linkRules
+	priorities map[AssetKind]int
+	rules      *redactionRules
+	recipients []age.Recipient
+	// recipients the unredacted page is kept encrypted for
+	keepFor    []age.Recipient
+	pdf        pdfOptions
+	breakers   *breakerSet
+	pacer      *hostPacer
+	bandwidth  *bandwidthLimiter
+	hooks      hooks
+	siteRules  []siteProfile
+	client     *http.Client
+	userAgents *userAgentPicker
+	jar        *cookieJar
+	loginCheck loginCheck
+	loginOnce  sync.Once
+	loginErr   error
+	browser    *headlessBrowser
+	// payloads stored by the WARC files of every save
+	payloads *warcPayloads
+	// where FormatObsidian puts notes and their images
+	vault vaultLayout
+}
+
+// Check options and prepare everything that does not change between saves
+func New(options Options) (*Saver, error) {
+	if options.Format == "" {
+		options.Format = FormatHTML
+	}
+	if options.Srcset == "" {
+		options.Srcset = SrcsetAll
+	}
+	if options.Priority == "" {
+		options.Priority = DefaultPriority
+	}
+	if options.PDFPageSize == "" {
+		options.PDFPageSize = "A4"
+	}
+	if options.PDFMargin == "" {
+		options.PDFMargin = "1cm"
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	if options.MaxRedirects == 0 {
+		options.MaxRedirects = DefaultMaxRedirects
+	}
+	if options.MemoryThreshold <= 0 {
+		options.MemoryThreshold = DefaultMemoryThreshold
+	}
+	if options.MaxAssetSize < 0 || options.MaxAssetTime < 0 {
+		return nil, fmt.Errorf("page file limits cannot be negative")
+	}
+	if options.HostConcurrency < 0 {
+		options.HostConcurrency = 0
+	}
+
+	err := validateSrcsetMode(options.Srcset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid srcset mode: %s", err)
+	}
+
+	err = validateFormat(options.Format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format: %s", err)
+	}
+
+	if options.MaxPages < 0 || options.MaxTime < 0 {
+		return nil, fmt.Errorf("page and time limits cannot be negative")
+	}
+
+	if options.Thread && options.Depth > 0 {
+		return nil, fmt.Errorf("thread mode and depth cannot be used together")
+	}
+
+	if options.SingleFile && options.MHTML {
+		return nil, fmt.Errorf("single file and MHTML output cannot be used together")
+	}
+
+	if options.InlineThreshold < 0 {
+		return nil, fmt.Errorf("invalid inline threshold %d", options.InlineThreshold)
+	}
+	if options.InlineThreshold > 0 && (options.SingleFile || options.MHTML) {
+		return nil, fmt.Errorf("inline threshold cannot be used together with single file or MHTML output")
+	}
+
+	if options.Zotero {
+		if options.Format != FormatHTML || options.MHTML || options.InlineThreshold > 0 {
+			return nil, fmt.Errorf("Zotero snapshots are single \"%s\" files, they cannot be used together with other formats, MHTML or inline threshold", FormatHTML)
+		}
+		options.SingleFile = true
+	}
+
+	if options.Format != FormatHTML && (options.SingleFile || options.MHTML || options.InlineThreshold > 0) {
+		return nil, fmt.Errorf("single file, MHTML and inline threshold only apply to \"%s\" format", FormatHTML)
+	}
+
+	if options.Delay < 0 || options.MaxRPS < 0 || options.DelayJitter < 0 {
+		return nil, fmt.Errorf("delays and request rate cannot be negative")
+	}
+	if options.LimitRate < 0 {
+		return nil, fmt.Errorf("bandwidth limit cannot be negative")
+	}
+	var interval time.Duration = options.Delay
+	if options.MaxRPS > 0 && time.Duration(float64(time.Second)/options.MaxRPS) > interval {
+		interval = time.Duration(float64(time.Second) / options.MaxRPS)
+	}
+
+	var transport transportOptions = transportOptions{insecure: options.Insecure}
+	if options.Proxy != "" {
+		transport.proxyURL, err = url.Parse(options.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %s", err)
+		}
+		switch transport.proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme \"%s\"", transport.proxyURL.Scheme)
+		}
+	}
+	if (options.ClientCertPath == "") != (options.ClientKeyPath == "") {
+		return nil, fmt.Errorf("client certificate and its key must be given together")
+	}
+	if options.ClientCertPath != "" {
+		certificate, err := tls.LoadX509KeyPair(options.ClientCertPath, options.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		transport.clientCertificate = &certificate
+	}
+	if options.CACertPath != "" {
+		transport.rootCAs, err = loadCACertificates(options.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA certificates: %s", err)
+		}
+	}
+	// enough idle connections for every worker that may be busy with the same host
+	var hostWorkers int = options.Concurrency
+	if options.HostConcurrency > 0 && options.HostConcurrency < hostWorkers {
+		hostWorkers = options.HostConcurrency
+	}
+	if hostWorkers != DefaultConcurrency {
+		transport.idlePerHost = hostWorkers
+	}
+
+	if options.BasicAuth != "" && !strings.Contains(options.BasicAuth, ":") {
+		return nil, fmt.Errorf("basic auth credentials must be given as user:password")
+	}
+	if options.BasicAuth != "" && options.BearerToken != "" {
+		return nil, fmt.Errorf("basic auth and bearer token cannot be used together")
+	}
+
+	var siteRules []siteProfile
+	var siteLogins bool = false
+	if options.SiteRulesDir != "" {
+		siteRules, err = loadSiteRules(options.SiteRulesDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid site rules: %s", err)
+		}
+	}
+	for _, profile := range siteRules {
+		siteLogins = siteLogins || len(profile.login) > 0
+	}
+
+	var jar http.CookieJar = nil
+	var cookies *cookieJar = nil
+	switch {
+	case options.CookiesPath != "":
+		cookies, err = loadCookieJar(options.CookiesPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cookies: %s", err)
+		}
+		jar = cookies
+	case options.SaveCookiesPath != "" || options.LoginURL != "" || siteLogins:
+		cookies = &cookieJar{}
+		jar = cookies
+	}
+
+	var check loginCheck
+	if options.LoginURL != "" {
+		check, err = parseLoginCheck(options.LoginCheck)
+		if err != nil {
+			return nil, fmt.Errorf("invalid login check: %s", err)
+		}
+	}
+
+	if options.Rewriter != nil && (options.Format != FormatHTML || options.SingleFile || options.MHTML || options.InlineThreshold > 0) {
+		return nil, fmt.Errorf("custom rewriter only applies to \"%s\" format without single file, MHTML or inline threshold", FormatHTML)
+	}
+
+	if options.VideoDownloader != "" && (options.Format != FormatHTML || options.SingleFile || options.MHTML || options.InlineThreshold > 0) {
+		return nil, fmt.Errorf("video downloads only apply to \"%s\" format without single file, MHTML or inline threshold", FormatHTML)
+	}
+
+	if (options.Format == FormatObsidian) != (options.Vault != "") {
+		return nil, fmt.Errorf("a vault is needed for, and only used by, \"%s\" format", FormatObsidian)
+	}
+	if options.Format == FormatObsidian && options.Encrypt != "" {
+		return nil, fmt.Errorf("notes cannot be encrypted into a vault")
+	}
+
+	if options.Print && ((options.Format != FormatHTML && options.Format != FormatReader) || options.MHTML) {
+		return nil, fmt.Errorf("print variants only apply to \"%s\" and \"%s\" formats without MHTML", FormatHTML, FormatReader)
+	}
+
+	saver := &Saver{
+		options: options,
+		links: linkRules{
+			alternates: options.Alternates,
+			srcsetMode: options.Srcset,
+		},
+		breakers:   newBreakerSet(),
+		pacer:      newHostPacer(interval, options.DelayJitter),
+		bandwidth:  newBandwidthLimiter(options.LimitRate),
+		client:     newClient(options.Compat, transport, redirectPolicy{max: options.MaxRedirects, sameHost: options.SameHostRedirects}, jar),
+		userAgents: newUserAgentPicker(options.UserAgent, options.RotateUserAgent),
+		jar:        cookies,
+		siteRules:  siteRules,
+		loginCheck: check,
+		payloads:   newWARCPayloads(),
+		browser: &headlessBrowser{
+			path:      options.BrowserPath,
+			proxy:     options.Proxy,
+			userAgent: resolveUserAgent(options.UserAgent),
+			insecure:  options.Insecure,
+		},
+	}
+
+	if options.HooksDir != "" {
+		saver.hooks, err = loadHooks(options.HooksDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hooks directory: %s", err)
+		}
+	}
+
+	if options.Vault != "" {
+		saver.vault, err = readVaultLayout(options.Vault)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vault: %s", err)
+		}
+	}
+
+	if options.PDF {
+		saver.pdf, err = parsePDFOptions(options.PDFPageSize, options.PDFMargin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PDF settings: %s", err)
+		}
+	}
+
+	saver.priorities, err = parsePriority(options.Priority)
+	if err != nil {
+		return nil, fmt.Errorf("invalid priority: %s", err)
+	}
+
+	if options.MediaTypesPath != "" {
+		err = loadMediaTypes(options.MediaTypesPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load media types: %s", err)
+		}
+	}
+
+	if options.RedactionRules != "" {
+		saver.rules, err = loadRedactionRules(options.RedactionRules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redaction rules: %s", err)
+		}
+	}
+
+	if options.RedactKeepOriginal != "" {
+		saver.keepFor, err = parseRecipients(options.RedactKeepOriginal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption recipients for original page: %s", err)
+		}
+	}
+
+	if options.Encrypt != "" {
+		saver.recipients, err = parseRecipients(options.Encrypt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption recipients: %s", err)
+		}
+	}
+
+	return saver, nil
+}
+
+// Shut down the headless browser if it has been started
+func (saver *Saver) Close() error {
+	saver.browser.close()
+	return nil
+}
+
+// State of a single Save call
+type session struct {
+	*Saver
+	ctx       context.Context
+	out       output
+	outputDir string
+	// host credentials are sent to
+	authHost string
+	// what everything ends up in, if not separate files
+	archiveName string
+	warc        *warcWriter
+	har         *harRecorder
+	// robots.txt rules of crawled hosts, nil if they are not followed
+	robots *robotsCache
+	// crawlKey of a rendered page -> scripts and WebAssembly modules the browser saw it load
+	renderLoaded map[string][]*url.URL
+	// crawlKey of a rendered page -> its text with too little contrast, if accessibility is checked
+	renderContrast map[string][]contrastHint
+	// logging in again once the login expires midway
+	reloginOnce sync.Once
+	reloginErr  error
+}
+
+// Pass a warning on to whoever listens for them
+func (session *session) warn(format string, args ...interface{}) {
+	if session.options.OnMessage != nil {
+		session.options.OnMessage(fmt.Sprintf(format, args...))
+	}
+}
+
+// Save the page at pageURL, and pages linked from it if asked to, into the output directory.
+// Pages that fail are reported and skipped; the result describes the saved ones and is returned
+// even if ctx gets cancelled midway, together with ctx's error
+func (saver *Saver) Save(ctx context.Context, pageURL string) (*Result, error) {
+	var result Result = Result{
+		Started: time.Now(),
+	}
+
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %s", err)
+	}
+
+	if isOnionHost(parsedURL.Hostname()) && saver.options.Proxy == "" {
+		return nil, fmt.Errorf("onion services can only be reached through a Tor proxy")
+	}
+
+	outputDir := saver.options.OutputDir
+	if saver.options.Format == FormatObsidian {
+		outputDir = saver.options.Vault
+	}
+	if outputDir == "" {
+		outputDir = "."
+	}
+	outputDir, err = filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to figure out output directory: %s", err)
+	}
+	result.OutputDir = outputDir
+
+	err = prepareOutputDir(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("output directory %s is not usable: %s", outputDir, err)
+	}
+
+	session := &session{
+		Saver:          saver,
+		ctx:            ctx,
+		out:            newDirOutput(outputDir),
+		outputDir:      outputDir,
+		authHost:       parsedURL.Host,
+		renderLoaded:   make(map[string][]*url.URL),
+		renderContrast: make(map[string][]contrastHint),
+	}
+
+	if saver.options.LoginURL != "" {
+		// before anything is recorded, so that credentials do not end up in HAR and WARC files
+		saver.loginOnce.Do(func() {
+			saver.loginErr = session.logIn()
+		})
+		if saver.loginErr != nil {
+			return nil, fmt.Errorf("failed to log in: %s", saver.loginErr)
+		}
+	}
+
+	if saver.recipients != nil {
+		session.archiveName = pageBaseName(parsedURL) + ".tar.age"
+		session.out, err = newEncryptedTarOutput(filepath.Join(outputDir, session.archiveName), saver.recipients, saver.options.MemoryThreshold)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create encrypted archive: %s", err)
+		}
+	}
+
+	if saver.options.HARPath != "" {
+		session.har = &harRecorder{keepCredentials: saver.options.HARCredentials, rules: saver.rules}
+	}
+
+	var warcFile io.WriteCloser = nil
+	var warcName string
+	if saver.options.Format == FormatWARC {
+		var baseName string = pageBaseName(parsedURL)
+		if saver.rules != nil {
+			// named as its records, so that neither it nor its index gives away what they do not
+			baseName = safeFileName(saver.rules.applyString(baseName), maxPageBaseNameLength)
+		}
+		warcName = baseName + ".warc.gz"
+		warcFile, err = session.out.Create(warcName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create WARC file: %s", err)
+		}
+
+		session.warc, err = newWARCWriter(warcFile, saver.options.MemoryThreshold, saver.rules, saver.payloads)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write WARC file: %s", err)
+		}
+
+		if session.archiveName == "" {
+			session.archiveName = warcName
+		}
+	}
+
+	var languageList []string = parseLanguages(saver.options.Languages)
+	var variants []languageVariant
+	var languageFiles map[string]string = map[string]string{
+		"default": pageBaseName(parsedURL) + saver.pageFileExtension(),
+	}
+
+	var maxDepth int = int(saver.options.Depth)
+	var pageLinks func(pageBody []byte) []*url.URL = findPageLinks
+	if saver.options.Thread {
+		maxDepth = maxThreadPages - 1
+		pageLinks = findNextPageLinks
+	}
+	if maxDepth > 0 && !saver.options.NoRobots {
+		session.robots = newRobotsCache()
+		// so that the start host's Crawl-delay applies from the first request
+		session.robotsRules(parsedURL)
+	}
+
+	result.Pages = session.crawl(parsedURL, maxDepth, saver.options.SpanHosts, pageLinks, func(pageURL *url.URL, baseName string, body []byte, startPage bool) (*PageReport, error) {
+		if len(languageList) > 0 && startPage {
+			languageFiles["default"] = baseName + saver.pageFileExtension()
+			variants = findLanguageVariants(body, pageURL, baseName, languageList)
+			for _, variant := range variants {
+				languageFiles[variant.language] = variant.baseName + saver.pageFileExtension()
+			}
+			body = addLanguageCrossLinks(body, "default", languageList, languageFiles)
+		}
+
+		return session.saveFetchedPage(pageURL, baseName, body)
+	})
+
+	for _, variant := range variants {
+		if ctx.Err() != nil {
+			break
+		}
+
+		body, err := session.fetchLanguageVariant(&variant)
+		if err != nil {
+			session.warn("Failed to get %s version of %s: %s", variant.language, variant.url.String(), err)
+			continue
+		}
+		body = addLanguageCrossLinks(body, variant.language, languageList, languageFiles)
+
+		report, err := session.saveFetchedPage(variant.url, variant.baseName, body)
+		if err != nil {
+			session.warn("Failed to save %s version of %s: %s", variant.language, variant.url.String(), err)
+			continue
+		}
+		result.Pages = append(result.Pages, report)
+	}
+
+	if warcFile != nil {
+		err = session.warc.finish()
+		if err != nil {
+			session.warn("Some requests are missing from the WARC file: %s", err)
+		}
+		err = warcFile.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to finish writing WARC file: %s", err)
+		}
+
+		result.IndexPath, err = session.warc.writeIndex(session.out, warcName)
+		if err != nil {
+			session.warn("Failed to write CDXJ index of the WARC file: %s", err)
+			result.IndexPath = ""
+		}
+	}
+
+	if session.har != nil && saver.recipients != nil {
+		// nothing may land on disk in the clear
+		var harName string = filepath.Base(saver.options.HARPath)
+		err = session.har.writeInto(session.out, harName)
+		if err != nil {
+			session.warn("Failed to write HAR file: %s", err)
+		} else {
+			result.HARPath = harName
+		}
+	}
+
+	err = session.out.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish writing output: %s", err)
+	}
+
+	if session.har != nil && saver.recipients == nil {
+		err = session.har.write(saver.options.HARPath)
+		if err != nil {
+			session.warn("Failed to write HAR file: %s", err)
+		} else {
+			result.HARPath = saver.options.HARPath
+		}
+	}
+	if saver.options.SaveCookiesPath != "" {
+		err = saver.jar.write(saver.options.SaveCookiesPath)
+		if err != nil {
+			session.warn("Failed to write cookies: %s", err)
+		}
+	}
+	result.Duration = time.Since(result.Started)
+
+	return &result, ctx.Err()
+}
+
+// Apply redaction, save the page with all its files and write requested extras for it
+func (session *session) saveFetchedPage(pageURL *url.URL, baseName string, body []byte) (*PageReport, error) {
+	options := session.options
+
+	if session.rules != nil {
+		if session.keepFor != nil {
+			originalPath, err := joinOutputPath(session.outputDir, baseName+".original.html.age")
+			if err != nil {
+				return nil, err
+			}
+			originalFile, err := createEncryptedFile(originalPath, session.keepFor)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create encrypted original page file: %s", err)
+			}
+			_, err = originalFile.Write(body)
+			if err != nil {
+				// a cut-off original is no original
+				originalFile.Close()
+				os.Remove(originalPath)
+				return nil, fmt.Errorf("failed to write encrypted original page: %s", err)
+			}
+			err = originalFile.Close()
+			if err != nil {
+				os.Remove(originalPath)
+				return nil, fmt.Errorf("failed to write encrypted original page: %s", err)
+			}
+		}
+
+		body = session.rules.apply(body)
+	}
+
+	if options.Wiki && isMediaWikiPage(body) {
+		body = cleanWikiPage(body, pageURL)
+	}
+
+	profile := findSiteProfile(session.siteRules, pageURL, body)
+	if profile == nil && !options.NoSiteProfiles {
+		profile = findSiteProfile(siteProfiles, pageURL, body)
+	}
+	if profile != nil {
+		body = profile.removeElements(body)
+		body = profile.revealLazyImages(body)
+		if options.Format == FormatMarkdown || options.Format == FormatEPUB || options.Format == FormatReader || options.Format == FormatObsidian {
+			body = profile.extractContent(body)
+		}
+	}
+
+	if options.TOC && (options.Format == FormatHTML || options.Format == FormatEPUB) {
+		body = injectTOC(body)
+	}
+
+	var report *PageReport
+	var err error
+	switch {
+	case options.Format == FormatWARC:
+		// exchanges are recorded into the WARC file as they happen
+		report, err = session.savePage(body, discardOutput{}, pageURL, baseName)
+	case options.Format == FormatMarkdown:
+		report, err = session.saveMarkdownPage(body, session.out, pageURL, baseName)
+	case options.Format == FormatEPUB:
+		report, err = session.saveEPUBPage(body, session.out, pageURL, baseName)
+	case options.Format == FormatReader:
+		report, err = session.saveReaderPage(body, session.out, pageURL, baseName)
+	case options.Format == FormatObsidian:
+		report, err = session.saveObsidianNote(body, session.out, pageURL, baseName)
+	case options.MHTML:
+		report, err = session.saveMHTMLPage(body, session.out, pageURL, baseName)
+	case options.SingleFile:
+		report, err = session.saveInlinedPage(body, session.out, pageURL, baseName, 0)
+	case options.InlineThreshold > 0:
+		report, err = session.saveInlinedPage(body, session.out, pageURL, baseName, options.InlineThreshold)
+	default:
+		report, err = session.savePage(body, session.out, pageURL, baseName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pagePath string = report.OutputPath
+	if session.archiveName != "" {
+		// everything ends up in the archive
+		report.OutputPath = session.archiveName
+	}
+	report.Metadata = extractMetadata(body)
+
+	if options.Zotero {
+		extra, err := writeZoteroRecord(body, pageURL, baseName, pagePath, session.out)
+		if err != nil {
+			session.warn("Failed to write Zotero record for %s: %s", pageURL.String(), err)
+		} else {
+			report.Extras = append(report.Extras, extra)
+		}
+	}
+
+	if options.Citation != "" {
+		extra, err := writeCitation(options.Citation, body, pageURL, baseName, filepath.Join(session.outputDir, report.OutputPath), session.out)
+		if err != nil {
+			session.warn("Failed to write citation for %s: %s", pageURL.String(), err)
+		} else {
+			report.Extras = append(report.Extras, extra)
+		}
+	}
+
+	if options.Accessibility {
+		extra, err := writeAccessibilityReport(body, pageURL, baseName, session.out, session.renderContrast[crawlKey(pageURL)])
+		if err != nil {
+			session.warn("Failed to write accessibility report for %s: %s", pageURL.String(), err)
+		} else {
+			report.Extras = append(report.Extras, extra)
+		}
+	}
+
+	// the raw file comes straight from the site, redaction rules could not be applied to it
+	if !options.NoSiteProfiles && session.rules == nil {
+		if rawURL := rawSourceURL(pageURL, body); rawURL != nil {
+			extra, err := session.writeRawSource(rawURL, baseName)
+			if err != nil {
+				session.warn("Failed to save raw source of %s: %s", pageURL.String(), err)
+			} else {
+				report.Extras = append(report.Extras, extra)
+			}
+		}
+	}
+
+	if options.PDF {
+		var printedBody []byte
+		if session.rules != nil {
+			// the live page would have everything redaction took out
+			printedBody = body
+		}
+		extra, err := session.writePagePDF(pageURL.String(), printedBody, baseName)
+		if err != nil {
+			session.warn("Failed to print %s to PDF: %s", pageURL.String(), err)
+		} else {
+			report.Extras = append(report.Extras, extra)
+		}
+	}
+
+	_, err = session.hooks.run(session.ctx, hookInput{
+		Event:      hookPostSave,
+		URL:        pageURL.String(),
+		OutputPath: filepath.Join(session.outputDir, report.OutputPath),
+	})
+	if err != nil {
+		session.warn("%s", err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,173 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// Kind of file reference found in script code
+type scriptReferencePattern struct {
+	regexp *regexp.Regexp
+	// resolved against the script's URL instead of the page's
+	scriptRelative bool
+}
+
+// References scripts load files by. Worker scripts are resolved against the page, while new URL(..., import.meta.url)
+// and .wasm literals, as Emscripten and wasm-bindgen glue code uses them, are resolved against the script
+var scriptReferencePatterns []scriptReferencePattern = []scriptReferencePattern{
+	{regexp.MustCompile("new\\s+(?:Shared)?Worker\\s*\\(\\s*(?:\"([^\"]+)\"|'([^']+)'|`([^`$]+)`)"), false},
+	{regexp.MustCompile(`new\s+URL\s*\(\s*(?:"([^"]+)"|'([^']+)')\s*,\s*import\.meta\.url\s*\)`), true},
+	{regexp.MustCompile(`(?:"([^"\s]+\.wasm(?:\?[^"\s]*)?)"|'([^'\s]+\.wasm(?:\?[^'\s]*)?)')`), true},
+}
+
+// Inline script of a page, its code in the first group
+var inlineScriptRegexp *regexp.Regexp = regexp.MustCompile(`(?is)<script\b[^>]*>(.*?)</script>`)
+
+// Reference found in script code
+type scriptReference struct {
+	start, end     int
+	scriptRelative bool
+}
+
+// Worker, module URL and .wasm references of the script code in the order they appear
+func findScriptReferences(script []byte) []scriptReference {
+	var references []scriptReference
+	for _, pattern := range scriptReferencePatterns {
+		for _, match := range pattern.regexp.FindAllSubmatchIndex(script, -1) {
+			for group := 2; group < len(match); group += 2 {
+				if match[group] != -1 {
+					references = append(references, scriptReference{match[group], match[group+1], pattern.scriptRelative})
+					break
+				}
+			}
+		}
+	}
+
+	sort.Slice(references, func(i, j int) bool {
+		return references[i].start < references[j].start
+	})
+
+	// new URL("module.wasm", import.meta.url) is found by two patterns
+	var distinct []scriptReference
+	for _, reference := range references {
+		if len(distinct) > 0 && reference.start < distinct[len(distinct)-1].end {
+			continue
+		}
+		distinct = append(distinct, reference)
+	}
+
+	return distinct
+}
+
+// Call found for every worker, module URL and .wasm reference of the script code,
+// replacing the reference with what it returns
+func replaceScriptReferences(script []byte, found func(reference string, scriptRelative bool) string) []byte {
+	var replaced []byte = make([]byte, 0, len(script))
+	var last int = 0
+	for _, reference := range findScriptReferences(script) {
+		replaced = append(replaced, script[last:reference.start]...)
+		replaced = append(replaced, found(string(script[reference.start:reference.end]), reference.scriptRelative)...)
+		last = reference.end
+	}
+
+	return append(replaced, script[last:]...)
+}
+
+// What a script reference is resolved against
+func (downloader *assetDownloader) scriptReferenceBase(scriptRelative bool, scriptURL *url.URL) *url.URL {
+	if scriptRelative {
+		return scriptURL
+	}
+
+	return downloader.pageURL
+}
+
+// Queue downloads of worker scripts and WebAssembly modules the script code references.
+// scriptURL is the page's URL for inline scripts
+func (downloader *assetDownloader) discoverScriptReferences(script []byte, scriptURL *url.URL) {
+	if downloader.skipReason(AssetScript) != "" {
+		// only scripts need them
+		return
+	}
+
+	for _, reference := range findScriptReferences(script) {
+		value := string(script[reference.start:reference.end])
+		absoluteLink := fileReferenceLink(value, downloader.scriptReferenceBase(reference.scriptRelative, scriptURL))
+		if absoluteLink == nil {
+			continue
+		}
+
+		fileLink := withoutFragment(absoluteLink)
+		name, fresh := downloader.reserve(fileLink)
+		if fresh {
+			downloader.enqueue(fileLink, name, 0)
+		}
+	}
+}
+
+// Point worker and WebAssembly references of the script code to local copies of the files. Worker scripts
+// are looked up from the page, which the script is a part of if it is inline, and the rest from the script
+func (downloader *assetDownloader) rewriteScript(script []byte, scriptURL *url.URL, inline bool) []byte {
+	return replaceScriptReferences(script, func(reference string, scriptRelative bool) string {
+		absoluteLink := fileReferenceLink(reference, downloader.scriptReferenceBase(scriptRelative, scriptURL))
+		if absoluteLink == nil {
+			return reference
+		}
+
+		name, ok := downloader.savedName(withoutFragment(absoluteLink))
+		if !ok {
+			// left as it is, glue code may put its own directory in front of it
+			return reference
+		}
+
+		var local string
+		switch {
+		case downloader.session.options.Rewriter != nil:
+			local = downloader.session.options.Rewriter.Rewrite(withoutFragment(absoluteLink), escapePath(path.Join(downloader.filesDir, name)))
+		case scriptRelative && !inline:
+			// the script lives in the same files directory
+			local = url.PathEscape(name)
+		default:
+			local = localReference(path.Join(downloader.filesDir, name))
+		}
+		if absoluteLink.Fragment != "" {
+			local += "#" + absoluteLink.EscapedFragment()
+		}
+
+		return local
+	})
+}
+
+// Queue downloads of files the page's inline scripts reference
+func (downloader *assetDownloader) discoverInlineScriptReferences(pageBody []byte) {
+	for _, match := range inlineScriptRegexp.FindAllSubmatch(pageBody, -1) {
+		downloader.discoverScriptReferences(match[1], downloader.pageURL)
+	}
+}
+
+// Point references of the page's inline scripts to local copies of the files
+func (downloader *assetDownloader) rewriteInlineScripts(pageBody []byte) []byte {
+	return inlineScriptRegexp.ReplaceAllFunc(pageBody, func(element []byte) []byte {
+		location := inlineScriptRegexp.FindSubmatchIndex(element)
+		var rewritten []byte = make([]byte, 0, len(element))
+		rewritten = append(rewritten, element[:location[2]]...)
+		rewritten = append(rewritten, downloader.rewriteScript(element[location[2]:location[3]], downloader.pageURL, true)...)
+		rewritten = append(rewritten, element[location[3]:]...)
+
+		return rewritten
+	})
+}
@@ -0,0 +1,50 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"regexp"
+)
+
+// matches navigator.serviceWorker.register( with any whitespace around the dots
+var serviceWorkerRegisterRegexp *regexp.Regexp = regexp.MustCompile(`navigator\s*\.\s*serviceWorker\s*\.\s*register\s*\(`)
+
+// Replacement that keeps the call expression valid but never registers anything
+const serviceWorkerRegisterStub string = `(function(){return Promise.reject(new Error("Service workers are disabled in saved pages"))})(`
+
+// Script that disables registration for calls the static replacement misses
+const serviceWorkerStubScript string = `<script>if("serviceWorker" in navigator){navigator.serviceWorker.register=function(){return Promise.reject(new Error("Service workers are disabled in saved pages"))}}</script>`
+
+var headTagRegexp *regexp.Regexp = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// Replace service worker registration calls in script code with stubs
+func neutralizeServiceWorkers(script []byte) []byte {
+	return serviceWorkerRegisterRegexp.ReplaceAll(script, []byte(serviceWorkerRegisterStub))
+}
+
+// Stub service worker registration in inline scripts and make sure it cannot happen at runtime either
+func neutralizePageServiceWorkers(pageBody []byte) []byte {
+	pageBody = neutralizeServiceWorkers(pageBody)
+
+	location := headTagRegexp.FindIndex(pageBody)
+	if location == nil {
+		return append([]byte(serviceWorkerStubScript), pageBody...)
+	}
+
+	var neutralized []byte = make([]byte, 0, len(pageBody)+len(serviceWorkerStubScript))
+	neutralized = append(neutralized, pageBody[:location[1]]...)
+	neutralized = append(neutralized, serviceWorkerStubScript...)
+	neutralized = append(neutralized, pageBody[location[1]:]...)
+
+	return neutralized
+}
@@ -0,0 +1,506 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// Keeps all produced files, so they can be post-processed before being written anywhere:
+// in memory while they take up to memoryLimit bytes together, the rest in encrypted temporary files
+type memoryOutput struct {
+	mutex       sync.Mutex
+	files       map[string]*memoryFile
+	memoryLimit int64
+	// bytes of files kept in memory
+	held int64
+}
+
+func newMemoryOutput(memoryLimit int64) *memoryOutput {
+	return &memoryOutput{
+		files:       make(map[string]*memoryFile),
+		memoryLimit: memoryLimit,
+	}
+}
+
+type memoryFile struct {
+	spillBuffer
+	name   string
+	parent *memoryOutput
+}
+
+func (file *memoryFile) Close() error {
+	file.parent.mutex.Lock()
+	defer file.parent.mutex.Unlock()
+
+	if file.spill == nil && file.parent.held+file.size > file.parent.memoryLimit {
+		err := file.startSpilling()
+		if err != nil {
+			file.discard()
+			return err
+		}
+	}
+	if file.spill == nil {
+		file.parent.held += file.size
+	}
+
+	file.parent.remove(file.name)
+	file.parent.files[file.name] = file
+
+	return nil
+}
+
+func (file *memoryFile) Abort() error {
+	return file.discard()
+}
+
+func (out *memoryOutput) Create(relPath string) (outputFile, error) {
+	return &memoryFile{
+		spillBuffer: spillBuffer{limit: out.memoryLimit},
+		name:        path.Clean(strings.ReplaceAll(relPath, "\\", "/")),
+		parent:      out,
+	}, nil
+}
+
+// Drop a file. Must be called with mutex held
+func (out *memoryOutput) remove(name string) {
+	file, ok := out.files[name]
+	if !ok {
+		return
+	}
+
+	if file.spill == nil {
+		out.held -= file.size
+	}
+	file.discard()
+	delete(out.files, name)
+}
+
+// Remove temporary files of everything kept
+func (out *memoryOutput) Close() error {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	for name := range out.files {
+		out.remove(name)
+	}
+
+	return nil
+}
+
+// Paths of all kept files, sorted
+func (out *memoryOutput) names() []string {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	var names []string
+	for name := range out.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Size of the file, -1 if there is no such file
+func (out *memoryOutput) size(name string) int64 {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	file, ok := out.files[name]
+	if !ok {
+		return -1
+	}
+
+	return file.size
+}
+
+// Reader of the file's contents
+func (out *memoryOutput) open(name string) (io.Reader, error) {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	file, ok := out.files[name]
+	if !ok {
+		return nil, fmt.Errorf("no file \"%s\"", name)
+	}
+
+	return file.contents()
+}
+
+// All of the file's contents at once
+func (out *memoryOutput) read(name string) ([]byte, error) {
+	contents, err := out.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(contents)
+}
+
+// Media type of the file judging by its extension, or its first bytes if that does not help
+func (out *memoryOutput) detectMediaType(name string) (string, error) {
+	contents, err := out.open(name)
+	if err != nil {
+		return "", err
+	}
+
+	head := make([]byte, 512)
+	read, err := io.ReadFull(contents, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return detectMediaType(path.Base(name), head[:read]), nil
+}
+
+// Copy the file into another output as destinationName. Returns how many bytes were copied
+func (out *memoryOutput) copyTo(destination output, name string, destinationName string) (int64, error) {
+	contents, err := out.open(name)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := destination.Create(destinationName)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(file, contents)
+	if err != nil {
+		file.Abort()
+		return size, err
+	}
+
+	return size, file.Close()
+}
+
+// Media type of the file judging by its extension, or contents if that does not help
+func detectMediaType(name string, contents []byte) string {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(contents)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return mediaType
+}
+
+// Encode contents as a data: URI
+func dataURI(mediaType string, contents []byte) string {
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(contents)
+}
+
+// Turns saved page files into data: URIs
+type inliner struct {
+	links    linkRules
+	files    *memoryOutput
+	filesDir string
+	// files this big and bigger stay separate. 0 means no limit
+	threshold int64
+	// file name -> data URI
+	inlined map[string]string
+	// file name -> stylesheet with references to inlined files replaced
+	stylesheets map[string][]byte
+	// stylesheets being inlined at the moment, to break @import cycles
+	inProgress map[string]bool
+}
+
+func newInliner(links linkRules, files *memoryOutput, filesDir string, threshold int64) *inliner {
+	return &inliner{
+		links:       links,
+		files:       files,
+		filesDir:    filesDir,
+		threshold:   threshold,
+		inlined:     make(map[string]string),
+		stylesheets: make(map[string][]byte),
+		inProgress:  make(map[string]bool),
+	}
+}
+
+// data: URI for the file in the files directory, if there is such file and it may be inlined
+func (inliner *inliner) inline(name string) (string, bool) {
+	if uri, ok := inliner.inlined[name]; ok {
+		return uri, true
+	}
+
+	var filePath string = path.Join(inliner.filesDir, name)
+	size := inliner.files.size(filePath)
+	if size < 0 || inliner.inProgress[name] {
+		return "", false
+	}
+	if inliner.threshold > 0 && size >= inliner.threshold {
+		return "", false
+	}
+	contents, err := inliner.files.read(filePath)
+	if err != nil {
+		return "", false
+	}
+
+	var complete bool = true
+	mediaType := detectMediaType(name, contents)
+	if mediaType == "text/css" {
+		if processed, ok := inliner.stylesheets[name]; ok {
+			contents = processed
+		} else {
+			inliner.inProgress[name] = true
+			contents, complete = inliner.inlineStylesheet(contents)
+			delete(inliner.inProgress, name)
+			inliner.stylesheets[name] = contents
+		}
+	}
+
+	if !complete {
+		// relative references would not resolve from inside a data: URI
+		return "", false
+	}
+
+	uri := dataURI(mediaType, contents)
+	inliner.inlined[name] = uri
+
+	return uri, true
+}
+
+// Replace references to other saved files inside a stylesheet with data: URIs.
+// complete is false if some saved file it references has not been inlined
+func (inliner *inliner) inlineStylesheet(stylesheet []byte) (processed []byte, complete bool) {
+	complete = true
+
+	replace := func(regexpMatch []byte, submatches [][]byte, format string) []byte {
+		reference := firstSubmatch(submatches)
+
+		var fragment string
+		if index := strings.Index(reference, "#"); index != -1 {
+			reference, fragment = reference[:index], reference[index:]
+		}
+
+		reference, err := url.PathUnescape(reference)
+		if err != nil {
+			return regexpMatch
+		}
+
+		uri, ok := inliner.inline(reference)
+		if !ok {
+			exists := inliner.files.size(path.Join(inliner.filesDir, reference)) >= 0
+			if exists && !inliner.inProgress[reference] {
+				complete = false
+			}
+			return regexpMatch
+		}
+
+		return []byte(strings.Replace(format, "%s", uri+fragment, 1))
+	}
+
+	stylesheet = cssImportRegexp.ReplaceAllFunc(stylesheet, func(match []byte) []byte {
+		return replace(match, cssImportRegexp.FindSubmatch(match), `@import url("%s")`)
+	})
+	stylesheet = cssURLRegexp.ReplaceAllFunc(stylesheet, func(match []byte) []byte {
+		return replace(match, cssURLRegexp.FindSubmatch(match), `url("%s")`)
+	})
+
+	return stylesheet, complete
+}
+
+// data: URI for a reference from the page into its files directory
+func (inliner *inliner) inlinePageReference(reference string) (string, bool) {
+	var prefix string = localReference(inliner.filesDir) + "/"
+	if !strings.HasPrefix(reference, prefix) {
+		return "", false
+	}
+
+	name, err := url.PathUnescape(strings.TrimPrefix(reference, prefix))
+	if err != nil {
+		return "", false
+	}
+
+	return inliner.inline(name)
+}
+
+// Replace page's references to the saved files with data: URIs where possible
+func (inliner *inliner) inlinePage(pageBody []byte) []byte {
+	// files <style> elements reference
+	pageBody = inlineStyleRegexp.ReplaceAllFunc(pageBody, func(element []byte) []byte {
+		return cssURLRegexp.ReplaceAllFunc(element, func(match []byte) []byte {
+			reference := firstSubmatch(cssURLRegexp.FindSubmatch(match))
+
+			var fragment string
+			if index := strings.Index(reference, "#"); index != -1 {
+				reference, fragment = reference[:index], reference[index:]
+			}
+
+			uri, ok := inliner.inlinePageReference(reference)
+			if !ok {
+				return match
+			}
+			return []byte(`url("` + uri + fragment + `")`)
+		})
+	})
+
+	return walkPageAttributes(pageBody, func(token *html.Token, attribute *html.Attribute) bool {
+		if isSrcsetAttribute(token, attribute.Key) {
+			var changed bool = false
+			candidates := parseSrcset(attribute.Val)
+			for index := range candidates {
+				uri, ok := inliner.inlinePageReference(candidates[index].URL)
+				if ok {
+					candidates[index].URL = uri
+					changed = true
+				}
+			}
+			if changed {
+				attribute.Val = serializeSrcset(candidates)
+			}
+
+			return changed
+		}
+
+		if !inliner.links.isAssetAttribute(token, attribute.Key) {
+			return false
+		}
+
+		uri, ok := inliner.inlinePageReference(attribute.Val)
+		if !ok {
+			return false
+		}
+		attribute.Val = uri
+
+		return true
+	})
+}
+
+// Paths of files that have not been inlined and still have to be written
+func (inliner *inliner) leftovers(pagePath string) map[string]bool {
+	var leftovers map[string]bool = make(map[string]bool)
+	for _, filePath := range inliner.files.names() {
+		name := strings.TrimPrefix(filePath, inliner.filesDir+"/")
+		if filePath == pagePath {
+			continue
+		}
+		if _, ok := inliner.inlined[name]; ok {
+			continue
+		}
+
+		leftovers[filePath] = true
+	}
+
+	return leftovers
+}
+
+// Write a file that has not been inlined into out, with references to inlined files replaced if it is a stylesheet
+func (inliner *inliner) writeLeftover(out output, filePath string) error {
+	processed, ok := inliner.stylesheets[strings.TrimPrefix(filePath, inliner.filesDir+"/")]
+	if !ok {
+		_, err := inliner.files.copyTo(out, filePath, filePath)
+		return err
+	}
+
+	outfile, err := out.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = outfile.Write(processed)
+	if err != nil {
+		outfile.Abort()
+		return err
+	}
+
+	return outfile.Close()
+}
+
+// Save page with its files embedded as data: URIs. Files of threshold size and bigger are
+// kept in the files directory; with no threshold the result is one self-contained .html
+func (session *session) saveInlinedPage(pageBody []byte, out output, from *url.URL, baseName string, threshold int64) (*PageReport, error) {
+	memory := newMemoryOutput(session.options.MemoryThreshold)
+	defer memory.Close()
+	report, err := session.savePage(pageBody, memory, from, baseName)
+	if err != nil {
+		return nil, err
+	}
+
+	inliner := newInliner(session.links, memory, baseName+"_files", threshold)
+	pageFile, err := memory.read(report.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+	page := inliner.inlinePage(pageFile)
+
+	var writeFile = func(filePath string, contents []byte) error {
+		outfile, err := out.Create(filePath)
+		if err != nil {
+			return err
+		}
+		defer outfile.Close()
+
+		_, err = outfile.Write(contents)
+		return err
+	}
+
+	err = writeFile(report.OutputPath, page)
+	if err != nil {
+		return nil, err
+	}
+	report.Size = int64(len(page))
+
+	var extras map[string]bool = make(map[string]bool)
+	for _, extra := range report.Extras {
+		// the print variant references the same files as the page
+		extras[extra] = true
+		contents, err := memory.read(extra)
+		if err != nil {
+			return nil, err
+		}
+		err = writeFile(extra, inliner.inlinePage(contents))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var leftovers map[string]bool = nil
+	if threshold > 0 {
+		leftovers = inliner.leftovers(report.OutputPath)
+		for filePath := range leftovers {
+			if extras[filePath] {
+				continue
+			}
+			err = inliner.writeLeftover(out, filePath)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for index := range report.Assets {
+		asset := &report.Assets[index]
+		if asset.Status == AssetSaved && !leftovers[asset.LocalPath] {
+			asset.LocalPath = "(embedded)"
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,225 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules for a site as written in a YAML file of the site rules directory
+type siteRulesFile struct {
+	Hosts          []string          `yaml:"hosts"`
+	Markers        []string          `yaml:"markers"`
+	Headers        map[string]string `yaml:"headers"`
+	Remove         []string          `yaml:"remove"`
+	LazyAttributes map[string]string `yaml:"lazy-attributes"`
+	Content        string            `yaml:"content"`
+	Script         string            `yaml:"script"`
+	Login          []siteLoginStep   `yaml:"login"`
+}
+
+// Step of logging in to a site as written in its rules
+type siteLoginStep struct {
+	URL    string            `yaml:"url"`
+	Fields map[string]string `yaml:"fields"`
+	Check  string            `yaml:"check"`
+}
+
+// Outcome of logging in to a site, which is done once
+type siteLoginState struct {
+	once sync.Once
+	err  error
+}
+
+// Read every .yaml and .yml file in dir, in name order, as profiles of the sites they describe
+func loadSiteRules(dir string) ([]siteProfile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		extension := strings.ToLower(filepath.Ext(entry.Name()))
+		if !entry.IsDir() && (extension == ".yaml" || extension == ".yml") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var profiles []siteProfile
+	for _, name := range names {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var rules siteRulesFile
+		err = yaml.Unmarshal(contents, &rules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", name, err)
+		}
+
+		profile, err := rules.profile(strings.TrimSuffix(name, filepath.Ext(name)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// Check the rules and turn them into a profile
+func (rules *siteRulesFile) profile(name string) (siteProfile, error) {
+	if len(rules.Hosts) == 0 && len(rules.Markers) == 0 {
+		return siteProfile{}, fmt.Errorf("neither hosts nor markers are set")
+	}
+	if len(rules.Hosts) == 0 && (len(rules.Headers) > 0 || rules.Script != "" || len(rules.Login) > 0) {
+		// all are needed before the page is there to look for markers in
+		return siteProfile{}, fmt.Errorf("headers, script and login need hosts to be set")
+	}
+
+	var profile siteProfile = siteProfile{
+		name:           name,
+		markers:        rules.Markers,
+		content:        rules.Content,
+		lazyAttributes: rules.LazyAttributes,
+		headers:        make(http.Header),
+		script:         rules.Script,
+	}
+	for _, host := range rules.Hosts {
+		profile.hosts = append(profile.hosts, strings.ToLower(strings.TrimSpace(host)))
+	}
+	for key, value := range rules.Headers {
+		profile.headers.Set(key, value)
+	}
+	for attribute, target := range rules.LazyAttributes {
+		if target != "src" && target != "srcset" {
+			return siteProfile{}, fmt.Errorf("lazy attribute %s must go to src or srcset, not \"%s\"", attribute, target)
+		}
+	}
+	if rules.Content != "" {
+		_, _, _, err := parseSelector(rules.Content)
+		if err != nil {
+			return siteProfile{}, fmt.Errorf("content: %s", err)
+		}
+	}
+	for index, step := range rules.Login {
+		if index == 0 && step.URL == "" {
+			return siteProfile{}, fmt.Errorf("login: first step needs a url")
+		}
+		if step.URL != "" {
+			link, err := url.Parse(step.URL)
+			if err != nil || (link.Scheme != "http" && link.Scheme != "https") {
+				return siteProfile{}, fmt.Errorf("login: step %d: url \"%s\" is not an http(s) URL", index+1, step.URL)
+			}
+		}
+		check, err := parseLoginCheck(step.Check)
+		if err != nil {
+			return siteProfile{}, fmt.Errorf("login: step %d: invalid check: %s", index+1, err)
+		}
+
+		var fields url.Values = make(url.Values)
+		for name, value := range step.Fields {
+			fields.Set(name, value)
+		}
+		profile.login = append(profile.login, loginStep{url: step.URL, fields: fields, check: check})
+	}
+	if len(profile.login) > 0 {
+		profile.loginState = &siteLoginState{}
+	}
+	for _, selector := range rules.Remove {
+		_, _, _, err := parseSelector(selector)
+		if err != nil {
+			return siteProfile{}, fmt.Errorf("remove: %s", err)
+		}
+		profile.remove = append(profile.remove, selector)
+	}
+
+	return profile, nil
+}
+
+// The user's profile of the site serving link, by its host only
+func (saver *Saver) siteRulesFor(link *url.URL) *siteProfile {
+	host := strings.ToLower(link.Hostname())
+	for index := range saver.siteRules {
+		if saver.siteRules[index].servesHost(host) {
+			return &saver.siteRules[index]
+		}
+	}
+
+	return nil
+}
+
+// Log in to the site of link once for all saves, if its rules say how
+func (session *session) logInToSite(link *url.URL) error {
+	siteRules := session.siteRulesFor(link)
+	if siteRules == nil || len(siteRules.login) == 0 {
+		return nil
+	}
+
+	siteRules.loginState.once.Do(func() {
+		// not recorded, so that credentials do not end up in HAR and WARC files
+		siteRules.loginState.err = unrecordedSession(session).runLoginSteps(siteRules.login)
+	})
+
+	return siteRules.loginState.err
+}
+
+// Drop elements matching the profile's remove selectors from the page
+func (profile *siteProfile) removeElements(pageBody []byte) []byte {
+	if len(profile.remove) == 0 {
+		return pageBody
+	}
+
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return pageBody
+	}
+
+	var changed bool = false
+	for _, selector := range profile.remove {
+		tag, id, class, err := parseSelector(selector)
+		if err != nil {
+			continue
+		}
+
+		for element := findElement(document, tag, id, class); element != nil; element = findElement(document, tag, id, class) {
+			element.Parent.RemoveChild(element)
+			changed = true
+		}
+	}
+	if !changed {
+		return pageBody
+	}
+
+	var cleaned bytes.Buffer
+	err = html.Render(&cleaned, document)
+	if err != nil {
+		return pageBody
+	}
+
+	return cleaned.Bytes()
+}
@@ -0,0 +1,105 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// Most of a page gone through when telling whether it is a login page
+const maxSniffedPageSize int64 = 1024 * 1024
+
+// Start of a web page, as opposed to a file that merely has some markup in it
+var htmlDocumentRegexp *regexp.Regexp = regexp.MustCompile(`(?is)^(\xef\xbb\xbf)?\s*(<!--.*?-->\s*)*(<!doctype\s+html|<html|<head|<body)`)
+
+// Whether page files of the kind are never web pages themselves
+func expectsNonHTML(kind AssetKind) bool {
+	switch kind {
+	case AssetImage, AssetStylesheet, AssetScript, AssetFont, AssetMedia:
+		return true
+	default:
+		return false
+	}
+}
+
+// Whether contents are a web page, such as a login or error page served in place of a page file
+func looksLikeHTMLDocument(contents []byte) bool {
+	return htmlDocumentRegexp.Match(contents)
+}
+
+// Whether the web page has a login form in it
+func isLoginPage(pageBody []byte) bool {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return false
+	}
+
+	form := findLoginForm(document)
+	return form != nil && hasPasswordField(form)
+}
+
+// Body put back together after its start has been read
+type rejoinedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Fetch a page file. If it comes back as a login page although logging in is configured,
+// the login has probably expired midway: log in again and fetch the file once more
+func (session *session) fetchAsset(link *url.URL) (*http.Response, error) {
+	response, err := session.fetch(link.String())
+	if err != nil || session.options.LoginURL == "" || !expectsNonHTML(ClassifyLink(link)) {
+		return response, err
+	}
+
+	start, err := io.ReadAll(io.LimitReader(response.Body, maxSniffedPageSize))
+	response.Body = rejoinedBody{io.MultiReader(bytes.NewReader(start), response.Body), response.Body}
+	if err != nil || !looksLikeHTMLDocument(start) || !isLoginPage(start) {
+		return response, nil
+	}
+	response.Body.Close()
+
+	err = session.logInAgain()
+	if err != nil {
+		return nil, fmt.Errorf("got a login page, logging in again failed: %s", err)
+	}
+
+	return session.fetch(link.String())
+}
+
+// Log in again, only once per save
+func (session *session) logInAgain() error {
+	session.reloginOnce.Do(func() {
+		session.reloginErr = unrecordedSession(session).logIn()
+	})
+
+	return session.reloginErr
+}
+
+// Session sharing from's cookies and credentials but recording nothing, so that logging in
+// does not end up in HAR and WARC files
+func unrecordedSession(from *session) *session {
+	return &session{
+		Saver:    from.Saver,
+		ctx:      from.ctx,
+		out:      discardOutput{},
+		authHost: from.authHost,
+	}
+}
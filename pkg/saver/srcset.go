@@ -0,0 +1,152 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// One image candidate of a srcset attribute
+type srcsetCandidate struct {
+	URL        string
+	Descriptor string
+}
+
+// Whether attribute of the element holds a srcset-style list of image candidates
+func isSrcsetAttribute(token *html.Token, key string) bool {
+	switch token.Data {
+	case "img", "source":
+		return key == "srcset"
+	case "link":
+		return key == "imagesrcset"
+	default:
+		return false
+	}
+}
+
+// Parse srcset value into candidates as described by the HTML standard
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+
+	var position int = 0
+	for position < len(srcset) {
+		// skip whitespace and stray commas
+		for position < len(srcset) && (srcset[position] == ',' || unicode.IsSpace(rune(srcset[position]))) {
+			position++
+		}
+		if position >= len(srcset) {
+			break
+		}
+
+		// URL lasts until whitespace
+		start := position
+		for position < len(srcset) && !unicode.IsSpace(rune(srcset[position])) {
+			position++
+		}
+		candidateURL := srcset[start:position]
+
+		var descriptor string
+		if strings.HasSuffix(candidateURL, ",") {
+			// no descriptor at all
+			candidateURL = strings.TrimRight(candidateURL, ",")
+		} else {
+			// descriptor lasts until a comma outside of parentheses
+			start = position
+			var inParentheses bool = false
+			for position < len(srcset) {
+				if srcset[position] == '(' {
+					inParentheses = true
+				} else if srcset[position] == ')' {
+					inParentheses = false
+				} else if srcset[position] == ',' && !inParentheses {
+					break
+				}
+				position++
+			}
+			descriptor = strings.TrimSpace(srcset[start:position])
+		}
+
+		if candidateURL != "" {
+			candidates = append(candidates, srcsetCandidate{URL: candidateURL, Descriptor: descriptor})
+		}
+	}
+
+	return candidates
+}
+
+// Put candidates back together into a srcset value
+func serializeSrcset(candidates []srcsetCandidate) string {
+	var parts []string
+	for _, candidate := range candidates {
+		if candidate.Descriptor != "" {
+			parts = append(parts, candidate.URL+" "+candidate.Descriptor)
+		} else {
+			parts = append(parts, candidate.URL)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Numeric size of the candidate's descriptor (width or density). No descriptor means 1x
+func (candidate srcsetCandidate) size() float64 {
+	descriptor := strings.TrimSpace(candidate.Descriptor)
+	if descriptor == "" {
+		return 1
+	}
+
+	value, err := strconv.ParseFloat(descriptor[:len(descriptor)-1], 64)
+	if err != nil {
+		return 1
+	}
+
+	return value
+}
+
+// Which srcset candidates to download
+const (
+	SrcsetAll      string = "all"
+	SrcsetLargest  string = "largest"
+	SrcsetSmallest string = "smallest"
+)
+
+func validateSrcsetMode(mode string) error {
+	switch mode {
+	case SrcsetAll, SrcsetLargest, SrcsetSmallest:
+		return nil
+	default:
+		return fmt.Errorf("unknown srcset mode \"%s\"", mode)
+	}
+}
+
+// Pick candidates to download according to mode
+func selectSrcsetCandidates(candidates []srcsetCandidate, mode string) []srcsetCandidate {
+	if mode == SrcsetAll || len(candidates) == 0 {
+		return candidates
+	}
+
+	var chosen srcsetCandidate = candidates[0]
+	for _, candidate := range candidates[1:] {
+		if (mode == SrcsetLargest && candidate.size() > chosen.size()) ||
+			(mode == SrcsetSmallest && candidate.size() < chosen.size()) {
+			chosen = candidate
+		}
+	}
+
+	return []srcsetCandidate{chosen}
+}
@@ -0,0 +1,107 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"sync/atomic"
+	"time"
+)
+
+// Returned once a page file grows bigger than allowed
+var errAssetTooBig error = errors.New("page file is bigger than allowed")
+
+// Returned once a page file takes longer than allowed
+var errAssetTooLong error = errors.New("page file takes longer than allowed")
+
+// Body of a page file that is given up on once it grows bigger, or takes longer, than allowed,
+// as live streams and other endless responses would keep a download worker forever
+type cappedBody struct {
+	body io.ReadCloser
+	// bytes left to read, no limit if negative
+	remaining int64
+	// closes body once time is up, so that even a read waiting on a silent server returns
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// Cap reading body at maxSize bytes and maxTime, each unlimited if 0. Stop must be called once done
+func newCappedBody(body io.ReadCloser, maxSize int64, maxTime time.Duration) *cappedBody {
+	capped := &cappedBody{body: body, remaining: -1}
+	if maxSize > 0 {
+		capped.remaining = maxSize
+	}
+	if maxTime > 0 {
+		capped.timer = time.AfterFunc(maxTime, func() {
+			capped.expired.Store(true)
+			body.Close()
+		})
+	}
+
+	return capped
+}
+
+func (capped *cappedBody) Read(buffer []byte) (int, error) {
+	if capped.remaining >= 0 && int64(len(buffer)) > capped.remaining+1 {
+		// one byte more tells a file of exactly the allowed size from a bigger one
+		buffer = buffer[:capped.remaining+1]
+	}
+
+	read, err := capped.body.Read(buffer)
+	if capped.expired.Load() {
+		return read, errAssetTooLong
+	}
+	if capped.remaining >= 0 {
+		capped.remaining -= int64(read)
+		if capped.remaining < 0 {
+			return read, errAssetTooBig
+		}
+	}
+
+	return read, err
+}
+
+// Stop the clock
+func (capped *cappedBody) Stop() {
+	if capped.timer != nil {
+		capped.timer.Stop()
+	}
+}
+
+// Whether a response of this Content-Type never ends by design: server-sent events and MJPEG-like streams
+func isEndlessContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/event-stream" || mediaType == "multipart/x-mixed-replace"
+}
+
+// Mark the outcome skipped if err says its file was given up on for being too big or taking too long
+func cutOff(outcome *AssetOutcome, err error) bool {
+	switch err {
+	case errAssetTooBig:
+		outcome.Status = AssetSkipped
+		outcome.Reason = "cut off: " + err.Error()
+	case errAssetTooLong:
+		outcome.Status = AssetSkipped
+		outcome.Reason = "cut off: " + err.Error() + ", it may be a live stream"
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,120 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Biggest chunk a throttled body reads at once, so that concurrent downloads share bandwidth evenly
+const maxThrottledRead int = 32 * 1024
+
+// Token bucket shared by every download. Bytes read put the bucket into debt,
+// which readers wait out before reading more
+type bandwidthLimiter struct {
+	// bytes per second
+	rate float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Create a limiter for given bytes per second. 0 means no limit
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &bandwidthLimiter{
+		rate: float64(rate),
+		last: time.Now(),
+	}
+}
+
+// Biggest chunk to read at once: a tenth of a second's worth, within reasonable bounds
+func (limiter *bandwidthLimiter) chunkSize() int {
+	chunk := int(limiter.rate / 10)
+	if chunk < 512 {
+		chunk = 512
+	}
+	if chunk > maxThrottledRead {
+		chunk = maxThrottledRead
+	}
+
+	return chunk
+}
+
+// Account for read bytes, waiting until the bandwidth allows them
+func (limiter *bandwidthLimiter) take(ctx context.Context, read int) error {
+	limiter.mutex.Lock()
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.rate {
+		// at most a second of burst
+		limiter.tokens = limiter.rate
+	}
+	limiter.last = now
+	limiter.tokens -= float64(read)
+	debt := limiter.tokens
+	limiter.mutex.Unlock()
+
+	if debt >= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(-debt / limiter.rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wrap body so that reading it respects the bandwidth limit. Returns body as is when there is no limit
+func (limiter *bandwidthLimiter) wrap(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if limiter == nil {
+		return body
+	}
+
+	return &throttledBody{ReadCloser: body, ctx: ctx, limiter: limiter}
+}
+
+// Response body read no faster than its limiter allows
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (body *throttledBody) Read(buffer []byte) (int, error) {
+	if chunk := body.limiter.chunkSize(); len(buffer) > chunk {
+		buffer = buffer[:chunk]
+	}
+
+	read, err := body.ReadCloser.Read(buffer)
+	if read > 0 {
+		waitErr := body.limiter.take(body.ctx, read)
+		if err == nil {
+			err = waitErr
+		}
+	}
+
+	return read, err
+}
@@ -0,0 +1,170 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Heading listed in a table of contents
+type tocEntry struct {
+	level int
+	id    string
+	text  string
+}
+
+var nonSlugCharRegexp *regexp.Regexp = regexp.MustCompile(`[^\pL\pN]+`)
+
+// Anchor made of heading's text: lowercase words joined with dashes
+func headingSlug(text string) string {
+	slug := strings.Trim(nonSlugCharRegexp.ReplaceAllString(strings.ToLower(text), "-"), "-")
+	if slug == "" {
+		slug = "section"
+	}
+
+	return slug
+}
+
+// Give every h1..hN heading under root an id, keeping existing ones, and list them in document order.
+// Generated ids come from heading text, so they stay the same across saves of the same page
+func addHeadingAnchors(root *html.Node, maxLevel int) []tocEntry {
+	var used map[string]bool = make(map[string]bool)
+	var collect func(node *html.Node)
+	collect = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			if id, ok := getAttribute(node, "id"); ok && id != "" {
+				used[id] = true
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(root)
+
+	var entries []tocEntry
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && len(node.Data) == 2 && node.Data[0] == 'h' &&
+			node.Data[1] >= '1' && node.Data[1] <= byte('0'+maxLevel) {
+			text := nodeText(node)
+			if text == "" {
+				return
+			}
+
+			id, ok := getAttribute(node, "id")
+			if !ok || id == "" {
+				base := headingSlug(text)
+				id = base
+				for number := 2; used[id]; number++ {
+					id = fmt.Sprintf("%s-%d", base, number)
+				}
+				used[id] = true
+				node.Attr = append(node.Attr, html.Attribute{Key: "id", Val: id})
+			}
+
+			entries = append(entries, tocEntry{level: int(node.Data[1] - '0'), id: id, text: text})
+			return
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return entries
+}
+
+// Nested list of links to the headings
+func renderTOC(entries []tocEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+
+	// the first heading sets the top level, higher ones that come later are put on it too
+	topLevel := entries[0].level
+
+	var toc strings.Builder
+	toc.WriteString(`<nav class="gospa-toc"><p><b>Contents</b></p><ul>`)
+	var depth int = 0
+	for index, entry := range entries {
+		level := entry.level - topLevel
+		if level < 0 {
+			level = 0
+		}
+		if level > depth+1 {
+			// a level deeper at most, skipped levels do not make empty lists
+			level = depth + 1
+		}
+		if index > 0 {
+			if level > depth {
+				toc.WriteString("<ul>")
+				depth++
+			} else {
+				toc.WriteString("</li>")
+				for ; depth > level; depth-- {
+					toc.WriteString("</ul></li>")
+				}
+			}
+		}
+		fmt.Fprintf(&toc, `<li><a href="#%s">%s</a>`, html.EscapeString(entry.id), html.EscapeString(entry.text))
+	}
+	toc.WriteString("</li>")
+	for ; depth > 0; depth-- {
+		toc.WriteString("</ul></li>")
+	}
+	toc.WriteString("</ul></nav>")
+
+	return toc.String()
+}
+
+// Fewest headings that make a page long enough for a table of contents
+const minTOCHeadings int = 3
+
+// Style of tables of contents injected into saved pages, kept apart from the page's own styles
+const injectedTOCStyle string = `<style>.gospa-toc{font:14px/1.5 sans-serif;background:#f4f4f4;color:#222;border:1px solid #ddd;` +
+	`padding:8px 16px;margin:8px;max-width:40em}.gospa-toc p{margin:0 0 4px}.gospa-toc ul{margin:0;padding-left:20px}` +
+	`.gospa-toc a{color:#1a5fb4}</style>`
+
+// Give h1..h3 headings of a long page stable anchors and put a table of contents linking to them
+// at the top of the page. Pages with fewer than minTOCHeadings headings are returned as is
+func injectTOC(pageBody []byte) []byte {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return pageBody
+	}
+
+	body := findElement(document, "body", "", "")
+	if body == nil {
+		return pageBody
+	}
+
+	entries := addHeadingAnchors(body, 3)
+	if len(entries) < minTOCHeadings {
+		return pageBody
+	}
+
+	var anchored bytes.Buffer
+	err = html.Render(&anchored, document)
+	if err != nil {
+		return pageBody
+	}
+
+	return insertAtBodyStart(anchored.Bytes(), []byte(injectedTOCStyle+renderTOC(entries)))
+}
@@ -0,0 +1,64 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// User agents of popular browsers, to use by name
+var userAgentPresets map[string]string = map[string]string{
+	"chrome":  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"firefox": "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"edge":    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0",
+	"safari":  "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Safari/605.1.15",
+	"mobile":  "Mozilla/5.0 (iPhone; CPU iPhone OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Mobile/15E148 Safari/604.1",
+}
+
+// Desktop presets requests take turns with when rotating
+var rotatedUserAgents []string = []string{"chrome", "firefox", "edge", "safari"}
+
+// Full user agent for a preset name, or value itself if it is not one
+func resolveUserAgent(value string) string {
+	if preset, ok := userAgentPresets[strings.ToLower(strings.TrimSpace(value))]; ok {
+		return preset
+	}
+
+	return value
+}
+
+// Picks the user agent for every request
+type userAgentPicker struct {
+	// sent with every request when not rotating. Empty means Go's default
+	base   string
+	rotate bool
+	turn   atomic.Uint64
+}
+
+func newUserAgentPicker(value string, rotate bool) *userAgentPicker {
+	return &userAgentPicker{
+		base:   resolveUserAgent(value),
+		rotate: rotate,
+	}
+}
+
+// User agent for the next request
+func (picker *userAgentPicker) pick() string {
+	if !picker.rotate {
+		return picker.base
+	}
+
+	turn := picker.turn.Add(1) - 1
+	return userAgentPresets[rotatedUserAgents[turn%uint64(len(rotatedUserAgents))]]
+}
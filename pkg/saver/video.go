@@ -0,0 +1,225 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Whether the page is a video on a platform whose player does not work offline
+func isVideoPlatformPage(pageURL *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(pageURL.Hostname()), "www.")
+	pagePath := strings.Trim(pageURL.Path, "/")
+
+	switch host {
+	case "youtube.com", "m.youtube.com":
+		return pagePath == "watch" && pageURL.Query().Get("v") != "" || strings.HasPrefix(pagePath, "shorts/")
+	case "youtu.be":
+		return pagePath != ""
+	case "vimeo.com":
+		return pagePath != "" && strings.Trim(pagePath, "0123456789") == ""
+	case "dailymotion.com":
+		return strings.HasPrefix(pagePath, "video/")
+	}
+
+	return false
+}
+
+// Arguments for the external downloader (yt-dlp or compatible) to fetch the page's video the way
+// every other request is made: through the same proxy, as the same user agent, with the same cookies
+// and certificate checks. Cookies are handed over in a temporary file, removed by the returned cleanup
+func (session *session) videoDownloaderArguments(pageURL *url.URL) ([]string, func(), error) {
+	var arguments []string = []string{
+		"--no-playlist",
+		// a single file with both video and audio that browsers can play
+		"--format", "best[ext=mp4]/best",
+	}
+	cleanup := func() {}
+
+	if session.options.Proxy != "" {
+		proxyURL, err := url.Parse(session.options.Proxy)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			// going around the proxy could give away who is asking
+			return nil, nil, fmt.Errorf("video downloader cannot use proxy \"%s\"", session.options.Proxy)
+		}
+		arguments = append(arguments, "--proxy", proxyURL.String())
+	}
+
+	if userAgent := session.userAgents.pick(); userAgent != "" {
+		arguments = append(arguments, "--user-agent", userAgent)
+	}
+
+	if session.options.Insecure {
+		arguments = append(arguments, "--no-check-certificates")
+	}
+
+	if session.jar != nil {
+		cookiesDir, err := os.MkdirTemp("", "gospa-video-cookies-*")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = func() {
+			os.RemoveAll(cookiesDir)
+		}
+
+		var cookiesPath string = filepath.Join(cookiesDir, "cookies.txt")
+		err = session.jar.write(cookiesPath)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		arguments = append(arguments, "--cookies", cookiesPath)
+	}
+
+	arguments = append(arguments, "--output", "-", pageURL.String())
+
+	return arguments, cleanup, nil
+}
+
+// Download the video of a video platform page into its files directory and put a player for it
+// at the top of the page. The page is returned as is if the video could not be downloaded
+func (downloader *assetDownloader) embedVideo(pageBody []byte, pageURL *url.URL) []byte {
+	started := time.Now()
+
+	name, size, err := downloader.streamVideo(pageURL)
+	if err != nil {
+		downloader.record(AssetOutcome{
+			URL:      pageURL.String(),
+			Kind:     AssetMedia,
+			Status:   AssetFailed,
+			Reason:   fmt.Sprintf("failed to save video: %s", err),
+			Duration: time.Since(started),
+		})
+		return pageBody
+	}
+
+	var localPath string = path.Join(downloader.filesDir, name)
+	downloader.record(AssetOutcome{
+		URL:       pageURL.String(),
+		LocalPath: localPath,
+		Kind:      AssetMedia,
+		Status:    AssetSaved,
+		Size:      size,
+		Duration:  time.Since(started),
+	})
+
+	player := fmt.Sprintf(
+		`<video class="gospa-video" controls preload="metadata" style="display:block;max-width:100%%;margin:8px auto" src="%s"></video>`,
+		html.EscapeString(localReference(localPath)),
+	)
+	return insertAtBodyStart(pageBody, []byte(player))
+}
+
+// Have the external downloader write the page's video to its standard output and stream it
+// straight into the files directory, named after the media type its first bytes give away.
+// Returns the name it has been saved under and its size
+func (downloader *assetDownloader) streamVideo(pageURL *url.URL) (string, int64, error) {
+	arguments, cleanup, err := downloader.session.videoDownloaderArguments(pageURL)
+	if err != nil {
+		return "", 0, err
+	}
+	defer cleanup()
+
+	var messages bytes.Buffer
+	command := exec.CommandContext(downloader.session.ctx, downloader.session.options.VideoDownloader, arguments...)
+	command.Stderr = &messages
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return "", 0, err
+	}
+	err = command.Start()
+	if err != nil {
+		return "", 0, err
+	}
+
+	// wait for the downloader to finish, with what it said if it failed
+	finish := func() error {
+		err := command.Wait()
+		if err != nil {
+			if message := strings.TrimSpace(messages.String()); message != "" {
+				err = fmt.Errorf("%s: %s", err, message)
+			}
+		}
+		return err
+	}
+
+	video := bufio.NewReaderSize(stdout, 512)
+	head, _ := video.Peek(512)
+	if len(head) == 0 {
+		err = finish()
+		if err == nil {
+			err = fmt.Errorf("downloader did not produce a video")
+		}
+		return "", 0, err
+	}
+
+	var extension string = extensionForMediaType(http.DetectContentType(head))
+	if extension == ".bin" {
+		extension = ".mp4"
+	}
+	var owner string = "video of " + pageURL.String()
+	downloader.mutex.Lock()
+	var name string = downloader.claim("video"+extension, owner)
+	downloader.mutex.Unlock()
+	release := func() {
+		downloader.mutex.Lock()
+		downloader.unclaim(name, owner)
+		downloader.mutex.Unlock()
+	}
+
+	file, err := downloader.out.Create(path.Join(downloader.filesDir, name))
+	if err != nil {
+		release()
+		command.Process.Kill()
+		finish()
+		return "", 0, err
+	}
+
+	size, err := io.Copy(file, video)
+	if err != nil {
+		command.Process.Kill()
+	}
+	if waitErr := finish(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		file.Abort()
+		release()
+		return "", 0, err
+	}
+
+	err = file.Close()
+	if err != nil {
+		release()
+		return "", 0, err
+	}
+
+	return name, size, nil
+}
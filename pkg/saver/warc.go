@@ -0,0 +1,570 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Page with its files directory
+	FormatHTML string = "html"
+	// WARC 1.1 file with every request and response made while saving
+	FormatWARC string = "warc"
+	// E-book with the page and its images, stylesheets and fonts
+	FormatEPUB string = "epub"
+	// Markdown with images next to it
+	FormatMarkdown string = "markdown"
+	// Clean page with just the article, a table of contents and estimated reading time, images next to it
+	FormatReader string = "reader"
+	// Note with the article and YAML frontmatter in an Obsidian or Logseq vault, images among its attachments
+	FormatObsidian string = "obsidian"
+)
+
+// Check whether format is known
+func validateFormat(format string) error {
+	switch format {
+	case FormatHTML, FormatWARC, FormatEPUB, FormatMarkdown, FormatReader, FormatObsidian:
+		return nil
+	default:
+		return fmt.Errorf("unknown format \"%s\"", format)
+	}
+}
+
+// Profile of revisit records standing in for a response whose payload has been stored already
+const warcIdenticalPayloadProfile string = "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest"
+
+// Response record a payload has been stored in
+type warcStoredPayload struct {
+	recordID  string
+	targetURI string
+	date      string
+}
+
+// Payloads stored by the WARC files of a Saver: payload digest -> the record holding it.
+// Shared between its saves, so that a collection of them stores every payload once
+type warcPayloads struct {
+	mutex  sync.Mutex
+	stored map[string]warcStoredPayload
+}
+
+func newWARCPayloads() *warcPayloads {
+	return &warcPayloads{stored: make(map[string]warcStoredPayload)}
+}
+
+// Record the payload with given digest is stored in, if any
+func (payloads *warcPayloads) lookup(digest string) (warcStoredPayload, bool) {
+	payloads.mutex.Lock()
+	defer payloads.mutex.Unlock()
+
+	stored, ok := payloads.stored[digest]
+	return stored, ok
+}
+
+// Remember the record the payload with given digest has been stored in, unless one is known already
+func (payloads *warcPayloads) remember(digest string, stored warcStoredPayload) {
+	payloads.mutex.Lock()
+	defer payloads.mutex.Unlock()
+
+	if _, ok := payloads.stored[digest]; !ok {
+		payloads.stored[digest] = stored
+	}
+}
+
+// Writes captured exchanges as WARC 1.1 records, each one compressed as a separate gzip member
+type warcWriter struct {
+	mutex      sync.Mutex
+	underlying *countingWriter
+	// how much of a recorded body is kept in memory before it goes to an encrypted temporary file
+	memoryLimit int64
+	// applied to URLs, headers and text bodies of every record, if set
+	rules *redactionRules
+	// payloads stored so far, which later responses with the same one get revisit records for
+	payloads *warcPayloads
+	// index entries of the records written so far
+	index []cdxjEntry
+	// first record that could not be written
+	err      error
+	finished bool
+}
+
+func newWARCWriter(underlying io.Writer, memoryLimit int64, rules *redactionRules, payloads *warcPayloads) (*warcWriter, error) {
+	writer := &warcWriter{underlying: &countingWriter{Writer: underlying}, memoryLimit: memoryLimit, rules: rules, payloads: payloads}
+
+	var fields bytes.Buffer
+	fmt.Fprintf(&fields, "software: Gospa %s\r\n", VERSION)
+	fmt.Fprintf(&fields, "format: WARC File Format 1.1\r\n")
+	fmt.Fprintf(&fields, "conformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n")
+
+	_, err := writer.writeRecord("warcinfo", "", "application/warc-fields", fields.Bytes(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Random urn:uuid: record identifier
+func newWARCRecordID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// Write a single record and return its ID
+func (writer *warcWriter) writeRecord(recordType string, targetURI string, contentType string, block []byte, extraFields map[string]string) (string, error) {
+	digest := sha1.Sum(block)
+
+	return writer.writeRecordFrom(recordType, targetURI, contentType, bytes.NewReader(block), int64(len(block)), digest[:], extraFields, nil)
+}
+
+// Current time as WARC-Date wants it
+func warcDate() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// "sha1:..." digest field value
+func warcDigest(digest []byte) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(digest)
+}
+
+// Write a single record with size bytes of block, whose SHA-1 is digest, and return its ID.
+// WARC-Date is the current time unless extraFields has it. The record is indexed with entry, if given
+func (writer *warcWriter) writeRecordFrom(recordType string, targetURI string, contentType string, block io.Reader, size int64, digest []byte, extraFields map[string]string, entry *cdxjEntry) (string, error) {
+	recordID := newWARCRecordID()
+	date, ok := extraFields["WARC-Date"]
+	if !ok {
+		date = warcDate()
+	}
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "WARC/1.1\r\n")
+	fmt.Fprintf(&header, "WARC-Type: %s\r\n", recordType)
+	fmt.Fprintf(&header, "WARC-Record-ID: %s\r\n", recordID)
+	fmt.Fprintf(&header, "WARC-Date: %s\r\n", date)
+	if targetURI != "" {
+		fmt.Fprintf(&header, "WARC-Target-URI: %s\r\n", targetURI)
+	}
+	for key, value := range extraFields {
+		if key != "WARC-Date" {
+			fmt.Fprintf(&header, "%s: %s\r\n", key, value)
+		}
+	}
+	fmt.Fprintf(&header, "WARC-Block-Digest: %s\r\n", warcDigest(digest))
+	fmt.Fprintf(&header, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&header, "Content-Length: %d\r\n\r\n", size)
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.finished {
+		return "", fmt.Errorf("WARC file is already finished")
+	}
+
+	offset := writer.underlying.written
+	compressor := gzip.NewWriter(writer.underlying)
+	_, err := compressor.Write(header.Bytes())
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(compressor, block)
+	if err != nil {
+		return "", err
+	}
+	_, err = compressor.Write([]byte("\r\n\r\n"))
+	if err != nil {
+		return "", err
+	}
+	err = compressor.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if entry != nil {
+		entry.Offset = strconv.FormatInt(offset, 10)
+		entry.Length = strconv.FormatInt(writer.underlying.written-offset, 10)
+		writer.index = append(writer.index, *entry)
+	}
+
+	return recordID, nil
+}
+
+// Remember the first record that could not be written
+func (writer *warcWriter) fail(err error) {
+	writer.mutex.Lock()
+	if writer.err == nil {
+		writer.err = err
+	}
+	writer.mutex.Unlock()
+}
+
+// Stop taking records. Returns the first record that could not be written, if any.
+// Bodies closed after this are not recorded
+func (writer *warcWriter) finish() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.finished = true
+
+	return writer.err
+}
+
+// Write CDXJ index of the records of the finished WARC file named warcName into out, named after it.
+// Returns the index's name
+func (writer *warcWriter) writeIndex(out output, warcName string) (string, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	indexName := strings.TrimSuffix(warcName, ".warc.gz") + ".cdxj"
+	file, err := out.Create(indexName)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(buildCDXJ(writer.index, warcName))
+	if err != nil {
+		file.Close()
+		return "", err
+	}
+
+	return indexName, file.Close()
+}
+
+// Response body that copies what is read from it into a WARC record, written once the body is closed.
+// The record holds as much of the body as was read, so that size and time caps, skipped streams
+// and timeouts apply to it just as they do to the download
+type warcRecordingBody struct {
+	io.ReadCloser
+	ctx         context.Context
+	writer      *warcWriter
+	recordType  string
+	targetURI   string
+	contentType string
+	// what goes in the block before the body, for HTTP responses
+	statusLine string
+	header     http.Header
+	// request record to write along, nil for resources
+	requestBlock []byte
+	block        spillBuffer
+	digest       hash.Hash
+	// of the body alone
+	payloadDigest hash.Hash
+	// why the body was not read to the end
+	truncated string
+	complete  bool
+	closed    bool
+}
+
+func (body *warcRecordingBody) Read(buffer []byte) (int, error) {
+	read, err := body.ReadCloser.Read(buffer)
+	if read > 0 && body.truncated == "" {
+		body.digest.Write(buffer[:read])
+		body.payloadDigest.Write(buffer[:read])
+		_, spillErr := body.block.Write(buffer[:read])
+		if spillErr != nil {
+			body.truncated = "unspecified"
+			body.writer.fail(spillErr)
+		}
+	}
+	switch {
+	case err == io.EOF:
+		body.complete = true
+	case err != nil && context.Cause(body.ctx) == errRequestTimedOut || errors.Is(err, context.DeadlineExceeded):
+		body.truncated = "time"
+	case err != nil && body.truncated == "":
+		body.truncated = "disconnect"
+	}
+
+	return read, err
+}
+
+func (body *warcRecordingBody) Close() error {
+	err := body.ReadCloser.Close()
+	if body.closed {
+		return err
+	}
+	body.closed = true
+	defer body.block.discard()
+
+	if !body.complete && body.truncated == "" {
+		// given up on by the reader: skipped, or over a size or time cap
+		body.truncated = "unspecified"
+	}
+
+	recordErr := body.write()
+	if recordErr != nil {
+		body.writer.fail(recordErr)
+	}
+
+	return err
+}
+
+// Status line and headers that go in the block before the body, nothing for resources
+func (body *warcRecordingBody) head() []byte {
+	if body.header == nil {
+		return nil
+	}
+
+	var head bytes.Buffer
+	head.WriteString(body.statusLine + "\r\n")
+	body.header.Write(&head)
+	head.WriteString("\r\n")
+
+	return head.Bytes()
+}
+
+// Content-Type of the body itself
+func (body *warcRecordingBody) payloadType() string {
+	if body.header == nil {
+		return body.contentType
+	}
+
+	return body.header.Get("Content-Type")
+}
+
+// Write the record, followed by its request record if there is one
+func (body *warcRecordingBody) write() error {
+	contents, err := body.block.contents()
+	if err != nil {
+		return err
+	}
+
+	var extraFields map[string]string = map[string]string{"WARC-Date": warcDate()}
+	var head []byte = body.head()
+	var block io.Reader = io.MultiReader(bytes.NewReader(head), contents)
+	var size int64 = int64(len(head)) + body.block.size
+	var digest []byte = body.digest.Sum(nil)
+	var payloadDigest []byte = body.payloadDigest.Sum(nil)
+	var payloadSize int64 = body.block.size
+
+	if body.writer.rules != nil && isRedactableText(body.payloadType()) {
+		payload, err := body.redactedPayload(contents)
+		if err != nil {
+			// cannot be told what is in it, better lost than leaked
+			payload = nil
+			body.truncated = "unspecified"
+		}
+		if body.header != nil {
+			body.header.Del("Content-Encoding")
+			body.header.Set("Content-Length", strconv.Itoa(len(payload)))
+		}
+
+		redacted := append(body.head(), payload...)
+		blockDigest := sha1.Sum(redacted)
+		redactedPayloadDigest := sha1.Sum(payload)
+		block = bytes.NewReader(redacted)
+		size = int64(len(redacted))
+		digest = blockDigest[:]
+		payloadDigest = redactedPayloadDigest[:]
+		payloadSize = int64(len(payload))
+	}
+	if body.truncated != "" {
+		extraFields["WARC-Truncated"] = body.truncated
+	}
+
+	// only whole payloads of responses can be told apart by their digest
+	var deduplicate bool = body.header != nil && body.truncated == "" && payloadSize > 0 && body.writer.payloads != nil
+	var recordType string = body.recordType
+	if deduplicate {
+		extraFields["WARC-Payload-Digest"] = warcDigest(payloadDigest)
+
+		stored, ok := body.writer.payloads.lookup(warcDigest(payloadDigest))
+		if ok {
+			// stored already: just the status line and headers, pointing at the record with the payload
+			recordType = "revisit"
+			head = body.head()
+			headDigest := sha1.Sum(head)
+			block = bytes.NewReader(head)
+			size = int64(len(head))
+			digest = headDigest[:]
+			extraFields["WARC-Profile"] = warcIdenticalPayloadProfile
+			extraFields["WARC-Refers-To"] = stored.recordID
+			extraFields["WARC-Refers-To-Target-URI"] = stored.targetURI
+			extraFields["WARC-Refers-To-Date"] = stored.date
+			deduplicate = false
+		}
+	}
+
+	entry := newCDXJEntry(body.targetURI, extraFields["WARC-Date"], body.payloadType(), statusLineCode(body.statusLine), extraFields["WARC-Payload-Digest"])
+	if recordType == "revisit" {
+		entry.MIME = "warc/revisit"
+	}
+
+	recordID, err := body.writer.writeRecordFrom(
+		recordType,
+		body.targetURI,
+		body.contentType,
+		block,
+		size,
+		digest,
+		extraFields,
+		&entry,
+	)
+	if err == nil && deduplicate {
+		body.writer.payloads.remember(warcDigest(payloadDigest), warcStoredPayload{
+			recordID:  recordID,
+			targetURI: body.targetURI,
+			date:      extraFields["WARC-Date"],
+		})
+	}
+	if err != nil || body.requestBlock == nil {
+		return err
+	}
+
+	_, err = body.writer.writeRecord(
+		"request",
+		body.targetURI,
+		"application/http;msgtype=request",
+		body.requestBlock,
+		map[string]string{"WARC-Concurrent-To": recordID},
+	)
+
+	return err
+}
+
+// Body as it was read, decoded and redacted
+func (body *warcRecordingBody) redactedPayload(contents io.Reader) ([]byte, error) {
+	var header http.Header = body.header
+	if header == nil {
+		header = http.Header{}
+	}
+
+	decoded := &http.Response{
+		Header:     header.Clone(),
+		Body:       io.NopCloser(contents),
+		StatusCode: http.StatusOK,
+		Request:    &http.Request{Method: http.MethodGet},
+	}
+	err := decodeContent(decoded)
+	if err != nil {
+		return nil, err
+	}
+	if coding := decoded.Header.Get("Content-Encoding"); coding != "" && coding != "identity" {
+		return nil, fmt.Errorf("unknown content coding \"%s\"", coding)
+	}
+
+	payload, err := io.ReadAll(decoded.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.writer.rules.applyBody(body.payloadType(), payload), nil
+}
+
+// Wrap the body in a warcRecordingBody of given type. Status line and header go before
+// the body in the block, unless header is nil
+func (writer *warcWriter) record(response *http.Response, recordType string, contentType string, statusLine string, header http.Header, requestBlock []byte) {
+	var targetURI string = response.Request.URL.String()
+	if writer.rules != nil {
+		targetURI = writer.rules.applyString(targetURI)
+	}
+
+	recordingBody := &warcRecordingBody{
+		ReadCloser:    response.Body,
+		ctx:           response.Request.Context(),
+		writer:        writer,
+		recordType:    recordType,
+		targetURI:     targetURI,
+		contentType:   contentType,
+		statusLine:    statusLine,
+		header:        header,
+		requestBlock:  requestBlock,
+		block:         spillBuffer{limit: writer.memoryLimit},
+		digest:        sha1.New(),
+		payloadDigest: sha1.New(),
+	}
+	recordingBody.digest.Write(recordingBody.head())
+
+	response.Body = recordingBody
+}
+
+// Copy of header with redaction rules applied to its values
+func (writer *warcWriter) redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	if writer.rules == nil {
+		return header
+	}
+
+	for _, values := range header {
+		for index, value := range values {
+			values[index] = writer.rules.applyString(value)
+		}
+	}
+
+	return header
+}
+
+// Record HTTP request and response pair. The response record is written once its body is closed
+func (writer *warcWriter) recordHTTP(response *http.Response) error {
+	requestBlock, err := httputil.DumpRequestOut(response.Request, false)
+	if err != nil {
+		return err
+	}
+	if writer.rules != nil {
+		requestBlock = writer.rules.applyText(requestBlock)
+	}
+
+	writer.record(
+		response,
+		"response",
+		"application/http;msgtype=response",
+		fmt.Sprintf("HTTP/%d.%d %s", response.ProtoMajor, response.ProtoMinor, response.Status),
+		writer.redactHeader(response.Header),
+		requestBlock,
+	)
+
+	return nil
+}
+
+// Most of a redirect body kept in its record
+const maxWARCRedirectBody int64 = 64 * 1024
+
+// Record a redirect along with the request that got it. Called before the client
+// follows it, while its body is still there to read
+func (writer *warcWriter) recordRedirect(response *http.Response) {
+	err := writer.recordHTTP(response)
+	if err != nil {
+		writer.fail(err)
+		return
+	}
+
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxWARCRedirectBody))
+	// the client closes the body itself, record it now
+	response.Body.Close()
+}
+
+// Record a non-HTTP (FTP) download as a resource record, written once its body is closed
+func (writer *warcWriter) recordResource(response *http.Response) {
+	writer.record(response, "resource", response.Header.Get("Content-Type"), "", nil, nil)
+}
+
+// Context key of the warcWriter redirects are recorded with
+type warcContextKey struct{}
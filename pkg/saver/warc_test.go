@@ -0,0 +1,321 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type testWARCRecord struct {
+	fields textproto.MIMEHeader
+	block  []byte
+}
+
+// Read every record of a gzipped WARC file
+func readTestWARC(t *testing.T, path string) []testWARCRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decompressor, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(decompressor)
+
+	var records []testWARCRecord
+	for {
+		version, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return records
+		}
+		if err != nil || version != "WARC/1.1\r\n" {
+			t.Fatalf("expected a record header, got %q (%v)", version, err)
+		}
+
+		fields, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(fields.Get("Content-Length"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := make([]byte, length+4)
+		_, err = io.ReadFull(reader, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		digest := sha1.Sum(block[:length])
+		if fields.Get("WARC-Block-Digest") != "sha1:"+base32.StdEncoding.EncodeToString(digest[:]) {
+			t.Errorf("wrong block digest of %s record of %s", fields.Get("WARC-Type"), fields.Get("WARC-Target-URI"))
+		}
+		records = append(records, testWARCRecord{fields: fields, block: block[:length]})
+	}
+}
+
+func TestWARCRecordsRedirectsAndCappedBodies(t *testing.T) {
+	var image []byte = bytes.Repeat([]byte{0xff}, 64*1024)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/big.png"><img src="/small.png"></body></html>`))
+	})
+	mux.HandleFunc("/big.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image)
+	})
+	mux.HandleFunc("/small.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(image[:100])
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	pageSaver, err := New(Options{
+		OutputDir:       outputDir,
+		Format:          FormatWARC,
+		NoRobots:        true,
+		MaxAssetSize:    1024,
+		MemoryThreshold: 512,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageSaver.Close()
+
+	result, err := pageSaver.Save(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warcFiles, _ := filepath.Glob(filepath.Join(result.OutputDir, "*.warc.gz"))
+	if len(warcFiles) != 1 {
+		t.Fatalf("expected one WARC file, got %v", warcFiles)
+	}
+
+	var responses map[string]testWARCRecord = map[string]testWARCRecord{}
+	var requests int
+	for _, record := range readTestWARC(t, warcFiles[0]) {
+		switch record.fields.Get("WARC-Type") {
+		case "response":
+			target, _ := strings.CutPrefix(record.fields.Get("WARC-Target-URI"), server.URL)
+			responses[target] = record
+		case "request":
+			requests++
+		}
+	}
+
+	if requests != len(responses) {
+		t.Errorf("expected a request record for each of %d responses, got %d", len(responses), requests)
+	}
+
+	redirect, ok := responses["/"]
+	if !ok {
+		t.Fatal("redirect is not recorded")
+	}
+	if !bytes.HasPrefix(redirect.block, []byte("HTTP/1.1 302 Found\r\n")) {
+		t.Errorf("redirect record starts with %q", redirect.block[:min(len(redirect.block), 30)])
+	}
+
+	if page, ok := responses["/page"]; !ok || !bytes.Contains(page.block, []byte(`<img src="/big.png">`)) {
+		t.Error("page is not recorded in full")
+	}
+
+	small, ok := responses["/small.png"]
+	if !ok || !bytes.HasSuffix(small.block, image[:100]) || small.fields.Get("WARC-Truncated") != "" {
+		t.Error("small image is not recorded in full")
+	}
+
+	// over the size cap: recorded only as far as it was read, and marked so
+	big, ok := responses["/big.png"]
+	if !ok {
+		t.Fatal("big image is not recorded")
+	}
+	if len(big.block) >= len(image) || big.fields.Get("WARC-Truncated") == "" {
+		t.Errorf("big image should be truncated, got %d bytes with WARC-Truncated %q", len(big.block), big.fields.Get("WARC-Truncated"))
+	}
+}
+
+func TestWARCRevisitsIdenticalPayloads(t *testing.T) {
+	var image []byte = bytes.Repeat([]byte{0xab}, 4096)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><img src="/a.png"><img src="/b.png"></body></html>`))
+	})
+	for _, name := range []string{"/a.png", "/b.png"} {
+		mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(image)
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	pageSaver, err := New(Options{OutputDir: t.TempDir(), Format: FormatWARC, NoRobots: true, Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageSaver.Close()
+
+	result, err := pageSaver.Save(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warcFiles, _ := filepath.Glob(filepath.Join(result.OutputDir, "*.warc.gz"))
+	if len(warcFiles) != 1 {
+		t.Fatalf("expected one WARC file, got %v", warcFiles)
+	}
+
+	var images []testWARCRecord
+	var recordIDs map[string]testWARCRecord = map[string]testWARCRecord{}
+	for _, record := range readTestWARC(t, warcFiles[0]) {
+		recordIDs[record.fields.Get("WARC-Record-ID")] = record
+		if strings.HasSuffix(record.fields.Get("WARC-Target-URI"), ".png") && record.fields.Get("WARC-Type") != "request" {
+			images = append(images, record)
+		}
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected records of both images, got %d", len(images))
+	}
+
+	stored, revisit := images[0], images[1]
+	if stored.fields.Get("WARC-Type") != "response" || !bytes.HasSuffix(stored.block, image) {
+		t.Fatalf("first image should be stored in full, got a %s record", stored.fields.Get("WARC-Type"))
+	}
+	if revisit.fields.Get("WARC-Type") != "revisit" || bytes.Contains(revisit.block, image) {
+		t.Fatalf("second image should be a revisit without the payload, got a %s record of %d bytes", revisit.fields.Get("WARC-Type"), len(revisit.block))
+	}
+	if revisit.fields.Get("WARC-Profile") != warcIdenticalPayloadProfile ||
+		revisit.fields.Get("WARC-Payload-Digest") != stored.fields.Get("WARC-Payload-Digest") {
+		t.Errorf("revisit does not name the identical payload: %v", revisit.fields)
+	}
+	referred, ok := recordIDs[revisit.fields.Get("WARC-Refers-To")]
+	if !ok || referred.fields.Get("WARC-Target-URI") != revisit.fields.Get("WARC-Refers-To-Target-URI") ||
+		referred.fields.Get("WARC-Date") != revisit.fields.Get("WARC-Refers-To-Date") {
+		t.Errorf("revisit does not refer to the stored record: %v", revisit.fields)
+	}
+}
+
+func TestWARCIndexPointsAtRecords(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><body><img src="/b.png?size=2&color=red"><img src="/a.png"></body></html>`))
+	})
+	mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("a"))
+	})
+	mux.HandleFunc("/b.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("b"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	pageSaver, err := New(Options{OutputDir: t.TempDir(), Format: FormatWARC, NoRobots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageSaver.Close()
+
+	result, err := pageSaver.Save(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IndexPath == "" {
+		t.Fatal("no index has been written")
+	}
+	warcFiles, _ := filepath.Glob(filepath.Join(result.OutputDir, "*.warc.gz"))
+	if len(warcFiles) != 1 {
+		t.Fatalf("expected one WARC file, got %v", warcFiles)
+	}
+	archive, err := os.ReadFile(warcFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(filepath.Join(result.OutputDir, result.IndexPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(index)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected an entry for the page and both images, got:\n%s", index)
+	}
+	if !sort.StringsAreSorted(lines) {
+		t.Errorf("index is not sorted:\n%s", index)
+	}
+
+	for _, line := range lines {
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 || len(parts[1]) != 14 {
+			t.Fatalf("malformed line %q", line)
+		}
+		var entry map[string]string
+		err = json.Unmarshal([]byte(parts[2]), &entry)
+		if err != nil {
+			t.Fatalf("malformed line %q: %s", line, err)
+		}
+		if parts[0] != surtKey(entry["url"]) || entry["status"] != "200" || entry["filename"] != filepath.Base(warcFiles[0]) {
+			t.Errorf("wrong entry %q", line)
+		}
+
+		// the record is the gzip member at the offset
+		offset, _ := strconv.Atoi(entry["offset"])
+		length, _ := strconv.Atoi(entry["length"])
+		if offset+length > len(archive) {
+			t.Fatalf("entry %q points past the end of the file", line)
+		}
+		member, err := gzip.NewReader(bytes.NewReader(archive[offset : offset+length]))
+		if err != nil {
+			t.Fatalf("entry %q does not point at a record: %s", line, err)
+		}
+		member.Multistream(false)
+		record, _ := io.ReadAll(member)
+		if !bytes.Contains(record, []byte("WARC-Type: response\r\n")) || !bytes.Contains(record, []byte("WARC-Target-URI: "+entry["url"]+"\r\n")) {
+			t.Errorf("entry %q points at another record", line)
+		}
+	}
+
+	if !strings.Contains(string(index), ")/b.png?color=red&size=2 ") {
+		t.Errorf("query parameters of the key are not in order:\n%s", index)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package saver
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Classes of MediaWiki elements that make no sense offline: edit links, jump links, navigation boxes
+var wikiChromeClasses []string = []string{"mw-editsection", "mw-jump-link", "noprint", "navbox", "mw-empty-elt"}
+
+// Whether the page is served by MediaWiki
+func isMediaWikiPage(pageBody []byte) bool {
+	return bytes.Contains(pageBody, []byte(`<meta name="generator" content="MediaWiki`))
+}
+
+// Address of the full-size file a MediaWiki thumbnail was made from, or empty if src is not a thumbnail.
+// Thumbnails live at .../thumb/a/ab/Name.jpg/220px-Name.jpg, files at .../a/ab/Name.jpg
+func wikiOriginalFile(src string) string {
+	index := strings.Index(src, "/thumb/")
+	lastSlash := strings.LastIndex(src, "/")
+	if index == -1 || lastSlash <= index+len("/thumb") {
+		return ""
+	}
+
+	return src[:index] + src[index+len("/thumb"):lastSlash]
+}
+
+// Whether element has any of given classes
+func hasAnyClass(node *html.Node, classes []string) bool {
+	value, _ := getAttribute(node, "class")
+	for _, class := range strings.Fields(value) {
+		for _, wanted := range classes {
+			if class == wanted {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Reduce a MediaWiki article to its heading and content, without edit links and navigation,
+// with image links leading to the images themselves instead of their description pages.
+// The page is returned as is if it does not look like an article
+func cleanWikiPage(pageBody []byte, pageURL *url.URL) []byte {
+	document, err := html.Parse(bytes.NewReader(pageBody))
+	if err != nil {
+		return pageBody
+	}
+
+	content := findElement(document, "div", "mw-content-text", "")
+	body := findElement(document, "body", "", "")
+	if content == nil || body == nil {
+		return pageBody
+	}
+	heading := findElement(document, "h1", "firstHeading", "")
+
+	var clean func(node *html.Node)
+	clean = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; {
+			next := child.NextSibling
+			if child.Type == html.ElementNode && hasAnyClass(child, wikiChromeClasses) {
+				node.RemoveChild(child)
+			} else {
+				clean(child)
+			}
+			child = next
+		}
+
+		if node.Type != html.ElementNode || node.Data != "a" || !hasAnyClass(node, []string{"mw-file-description", "image"}) {
+			return
+		}
+		image := findElement(node, "img", "", "")
+		if image == nil {
+			return
+		}
+		src, _ := getAttribute(image, "src")
+		original, err := url.Parse(wikiOriginalFile(src))
+		if err != nil || original.Path == "" {
+			return
+		}
+		for index := range node.Attr {
+			if node.Attr[index].Key == "href" {
+				// absolute, so that it still works from the saved copy
+				node.Attr[index].Val = pageURL.ResolveReference(original).String()
+			}
+		}
+	}
+	clean(content)
+
+	for body.FirstChild != nil {
+		body.RemoveChild(body.FirstChild)
+	}
+	if heading != nil {
+		heading.Parent.RemoveChild(heading)
+		body.AppendChild(heading)
+	}
+	content.Parent.RemoveChild(content)
+	body.AppendChild(content)
+
+	var cleaned bytes.Buffer
+	err = html.Render(&cleaned, document)
+	if err != nil {
+		return pageBody
+	}
+
+	return cleaned.Bytes()
+}
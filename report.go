@@ -0,0 +1,155 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"Unbewohnte/gospa/pkg/saver"
+)
+
+// Human-readable byte count
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// Parse byte count like "512", "32k", "1.5MB" or "2GiB". Suffixes are powers of 1024
+func parseSize(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	size = strings.TrimSuffix(strings.TrimSuffix(size, "b"), "i")
+
+	var multiplier float64 = 1
+	if size != "" {
+		if index := strings.IndexByte("kmgt", size[len(size)-1]); index != -1 {
+			multiplier = math.Pow(1024, float64(index+1))
+			size = size[:len(size)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(size), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size")
+	}
+
+	return int64(value * multiplier), nil
+}
+
+var reportTemplate *template.Template = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": formatSize,
+	"duration": func(duration time.Duration) string {
+		return duration.Round(time.Millisecond).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Gospa report {{.Generated.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.5em; text-align: left; font-size: 0.9em; word-break: break-all; }
+.saved { color: #2a7a2a; }
+.failed { color: #b22; }
+.skipped { color: #888; }
+</style>
+</head>
+<body>
+<h1>Gospa report</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05"}}: {{len .Pages}} page(s), {{size .TotalSize}} in total</p>
+{{range .Pages}}
+<h2><a href="{{.Link}}">{{.URL}}</a></h2>
+<p>
+{{if .RequestedURL}}Redirected from {{.RequestedURL}}. {{end}}Started {{.Started.Format "15:04:05"}}, took {{duration .Duration}}.
+Assets: {{.Saved}} saved, {{.Failed}} failed, {{.Skipped}} skipped. Size: {{size .TotalSize}}
+</p>
+<table>
+<tr><th>Status</th><th>Kind</th><th>URL</th><th>Local path</th><th>Size</th><th>Time</th><th>Reason</th></tr>
+{{range .Assets}}<tr class="{{.Status}}"><td>{{.Status}}</td><td>{{.Kind}}</td><td>{{.URL}}</td><td>{{.LocalPath}}</td><td>{{size .Size}}</td><td>{{duration .Duration}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type reportPageView struct {
+	*saver.PageReport
+	Link      string
+	Saved     int
+	Failed    int
+	Skipped   int
+	TotalSize int64
+}
+
+// Write HTML summary of the saved pages to reportPath
+func writeReport(reportPath string, result *saver.Result) error {
+	absReportPath, err := filepath.Abs(reportPath)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Generated time.Time
+		TotalSize int64
+		Pages     []reportPageView
+	}
+	data.Generated = time.Now()
+
+	for _, report := range result.Pages {
+		link, err := filepath.Rel(filepath.Dir(absReportPath), filepath.Join(result.OutputDir, report.OutputPath))
+		if err != nil {
+			link = filepath.Join(result.OutputDir, report.OutputPath)
+		}
+
+		view := reportPageView{
+			PageReport: report,
+			Link:       filepath.ToSlash(link),
+			Saved:      report.Count(saver.AssetSaved),
+			Failed:     report.Count(saver.AssetFailed),
+			Skipped:    report.Count(saver.AssetSkipped),
+			TotalSize:  report.TotalSize(),
+		}
+		data.TotalSize += view.TotalSize
+		data.Pages = append(data.Pages, view)
+	}
+
+	err = os.MkdirAll(filepath.Dir(absReportPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	reportFile, err := os.Create(absReportPath)
+	if err != nil {
+		return err
+	}
+	defer reportFile.Close()
+
+	return reportTemplate.Execute(reportFile, data)
+}
@@ -0,0 +1,413 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind of personal data and a pattern to look for it
+type piiPattern struct {
+	kind   string
+	regexp *regexp.Regexp
+}
+
+var piiPatterns []piiPattern = []piiPattern{
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	{"phone", regexp.MustCompile(`(?:\+|\b)\d{1,3}[\s.-]?\(?\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{2}[\s.-]?\d{2}\b`)},
+	{"US SSN", regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{"UK NINO", regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z]{2}\s?\d{2}\s?\d{2}\s?\d{2}\s?[A-D]\b`)},
+	{"RU SNILS", regexp.MustCompile(`\b\d{3}-\d{3}-\d{3}[\s-]\d{2}\b`)},
+	{"IBAN", regexp.MustCompile(`\b[A-Z]{2}\d{2}[A-Z0-9]{11,30}\b`)},
+}
+
+// Extensions of files worth scanning for personal data
+var scannableExtensions map[string]bool = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true,
+	".json": true, ".txt": true, ".xml": true, ".md": true, ".svg": true,
+	".xhtml": true, ".opf": true, ".ncx": true,
+}
+
+// A piece of likely personal data found in the archive
+type piiFinding struct {
+	file  string
+	line  int
+	kind  string
+	match string
+}
+
+// Findings of a scan, with how many files could and could not be scanned
+type piiScan struct {
+	findings []piiFinding
+	scanned  int
+	failed   int
+}
+
+// Note that path could not be scanned
+func (scan *piiScan) fail(path string, err error) {
+	fmt.Printf("Failed to scan %s: %s\n", path, err)
+	scan.failed++
+}
+
+// Scan text line by line and collect findings
+func (scan *piiScan) scanText(name string, reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var lineNumber int = 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		for _, pattern := range piiPatterns {
+			for _, match := range pattern.regexp.FindAllString(line, -1) {
+				scan.findings = append(scan.findings, piiFinding{
+					file:  name,
+					line:  lineNumber,
+					kind:  pattern.kind,
+					match: match,
+				})
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+	scan.scanned++
+
+	return nil
+}
+
+// Scan saved page, its files directory, or a tar, EPUB, MHTML or WARC archive with them.
+// Files in a directory that cannot be scanned are reported and the rest is scanned still
+func (scan *piiScan) scanArchive(archivePath string) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		scan.fail(archivePath, err)
+		return
+	}
+	if !info.IsDir() {
+		err = scan.scanFile(archivePath)
+		if err != nil {
+			scan.fail(archivePath, err)
+		}
+		return
+	}
+
+	err = filepath.WalkDir(archivePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			scan.fail(path, err)
+			return nil
+		}
+		if entry.IsDir() || !(scannableExtensions[fileExtension(path)] || archiveExtensions[fileExtension(path)]) {
+			return nil
+		}
+
+		err = scan.scanFile(path)
+		if err != nil {
+			scan.fail(path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		scan.fail(archivePath, err)
+	}
+}
+
+// Extensions of archives pages can be saved as
+var archiveExtensions map[string]bool = map[string]bool{
+	".tar": true, ".age": true, ".epub": true, ".mht": true, ".mhtml": true, ".warc": true, ".warc.gz": true,
+}
+
+// Lowercased extension of the file, with .gz kept together with the one before it
+func fileExtension(filePath string) string {
+	extension := strings.ToLower(filepath.Ext(filePath))
+	if extension == ".gz" {
+		extension = strings.ToLower(filepath.Ext(strings.TrimSuffix(filePath, filepath.Ext(filePath)))) + extension
+	}
+
+	return extension
+}
+
+// Scan a saved page or page file, or an archive with them
+func (scan *piiScan) scanFile(filePath string) error {
+	extension := fileExtension(filePath)
+	switch {
+	case extension == ".age":
+		return fmt.Errorf("encrypted archives must be decrypted before scanning")
+	case extension == ".epub":
+		return scan.scanEPUB(filePath)
+	case extension == ".mht" || extension == ".mhtml":
+		return scan.scanMHTML(filePath)
+	case extension == ".warc" || extension == ".warc.gz":
+		return scan.scanWARC(filePath)
+	case extension != ".tar" && !scannableExtensions[extension]:
+		return fmt.Errorf("unsupported file type \"%s\": only pages, text files such as stylesheets and scripts, and .tar, .epub, .mht and .warc(.gz) archives can be scanned", extension)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if extension != ".tar" {
+		return scan.scanText(filePath, file)
+	}
+
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || !scannableExtensions[strings.ToLower(filepath.Ext(header.Name))] {
+			continue
+		}
+
+		err = scan.scanText(filePath+":"+header.Name, tarReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Scan the text files of an EPUB book
+func (scan *piiScan) scanEPUB(bookPath string) error {
+	book, err := zip.OpenReader(bookPath)
+	if err != nil {
+		return err
+	}
+	defer book.Close()
+
+	for _, entry := range book.File {
+		if entry.FileInfo().IsDir() || !scannableExtensions[strings.ToLower(path.Ext(entry.Name))] {
+			continue
+		}
+
+		contents, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = scan.scanText(bookPath+":"+entry.Name, contents)
+		contents.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Whether a part of an archive with this media type holds text
+func isTextMediaType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+xml"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "application/x-www-form-urlencoded":
+		return true
+	}
+
+	return false
+}
+
+// Scan the text parts of an MHTML archive
+func (scan *piiScan) scanMHTML(archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	message, err := mail.ReadMessage(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	mediaType, parameters, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("not an MHTML archive")
+	}
+
+	parts := multipart.NewReader(message.Body, parameters["boundary"])
+	for {
+		// quoted-printable parts come out decoded
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if !isTextMediaType(partType) {
+			continue
+		}
+
+		var contents io.Reader = part
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			contents = base64.NewDecoder(base64.StdEncoding, part)
+		}
+
+		err = scan.scanText(archivePath+":"+part.Header.Get("Content-Location"), contents)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Scan the records of a WARC file, gzipped or not: requests, and responses and resources holding text
+func (scan *piiScan) scanWARC(archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var contents io.Reader = file
+	if strings.HasSuffix(strings.ToLower(archivePath), ".gz") {
+		// every record is a gzip member of its own, read one after another
+		decompressor, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer decompressor.Close()
+		contents = decompressor
+	}
+
+	reader := bufio.NewReader(contents)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			// between records
+			continue
+		}
+		if !strings.HasPrefix(line, "WARC/") {
+			return fmt.Errorf("not a WARC file")
+		}
+
+		headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		length, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("WARC record without length")
+		}
+		block := io.LimitReader(reader, length)
+		name := archivePath + ":" + headers.Get("WARC-Type") + " " + headers.Get("WARC-Target-URI")
+
+		switch headers.Get("WARC-Type") {
+		case "request", "metadata", "warcinfo":
+			err = scan.scanText(name, block)
+		case "response":
+			var response *http.Response
+			response, err = http.ReadResponse(bufio.NewReader(block), nil)
+			if err == nil {
+				mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+				if isTextMediaType(mediaType) {
+					err = scan.scanText(name, response.Body)
+				}
+			}
+		case "resource":
+			mediaType, _, _ := mime.ParseMediaType(headers.Get("Content-Type"))
+			if isTextMediaType(mediaType) {
+				err = scan.scanText(name, block)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(io.Discard, block)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Entry point of "gospa scan". Exits with 1 if something could not be scanned
+func runScan(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("Usage: gospa scan [saved page, its directory or .tar, .epub, .mht or .warc(.gz) archive]...\n")
+		return
+	}
+
+	var scan piiScan
+	for _, archivePath := range args {
+		scan.scanArchive(archivePath)
+	}
+
+	var perKind map[string]int = make(map[string]int)
+	for _, finding := range scan.findings {
+		fmt.Printf("%s:%d: %s: %s\n", finding.file, finding.line, finding.kind, finding.match)
+		perKind[finding.kind]++
+	}
+
+	switch {
+	case len(scan.findings) > 0:
+		var kinds []string
+		for kind := range perKind {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+
+		fmt.Printf("\nFound %d likely personal data occurrences:\n", len(scan.findings))
+		for _, kind := range kinds {
+			fmt.Printf("  %s: %d\n", kind, perKind[kind])
+		}
+	case scan.scanned == 0:
+		fmt.Printf("Nothing has been scanned\n")
+	case scan.failed > 0:
+		fmt.Printf("No likely personal data found in %d scanned file(s), but %d could not be scanned\n", scan.scanned, scan.failed)
+	default:
+		fmt.Printf("No likely personal data found\n")
+	}
+
+	if scan.failed > 0 {
+		os.Exit(1)
+	}
+}
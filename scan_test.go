@@ -0,0 +1,123 @@
+/*
+The MIT License (MIT)
+
+Copyright © 2023 Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the “Software”), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED “AS IS”, WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPhonePattern(t *testing.T) {
+	var phone *piiPattern
+	for index := range piiPatterns {
+		if piiPatterns[index].kind == "phone" {
+			phone = &piiPatterns[index]
+		}
+	}
+
+	tests := []struct {
+		text  string
+		match bool
+	}{
+		{"call +1 555 123 45 67 now", true},
+		{"call 8-916-123-45-67", true},
+		{"order 123456789012345678901234", false},
+		{"id=a98765432101234567", false},
+	}
+	for _, test := range tests {
+		if phone.regexp.MatchString(test.text) != test.match {
+			t.Errorf("%q: expected match to be %v", test.text, test.match)
+		}
+	}
+}
+
+// gzipped WARC file with given records (type, target URI, block)
+func testWARC(records [][3]string) []byte {
+	var warc bytes.Buffer
+	for _, record := range records {
+		compressor := gzip.NewWriter(&warc)
+		fmt.Fprintf(compressor, "WARC/1.1\r\nWARC-Type: %s\r\nWARC-Target-URI: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n",
+			record[0], record[1], len(record[2]), record[2])
+		compressor.Close()
+	}
+
+	return warc.Bytes()
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+
+	var book bytes.Buffer
+	archive := zip.NewWriter(&book)
+	file, _ := archive.Create("OEBPS/page.xhtml")
+	file.Write([]byte("<p>write to book@example.com</p>"))
+	archive.Close()
+
+	files := map[string][]byte{
+		"page.html":      []byte("<p>mail me at page@example.com</p>"),
+		"clean.html":     []byte("<p>nothing here</p>"),
+		"book.epub":      book.Bytes(),
+		"broken.epub":    []byte("not a zip"),
+		"secret.tar.age": []byte("age-encryption.org/v1"),
+		"photo.png":      []byte("\x89PNG"),
+		"page.warc.gz": testWARC([][3]string{
+			{"response", "http://example.com/", "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<p>warc@example.com</p>"},
+			{"response", "http://example.com/a.png", "HTTP/1.1 200 OK\r\nContent-Type: image/png\r\n\r\nimage@example.com"},
+		}),
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, name), contents, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		name     string
+		inputs   []string
+		findings int
+		scanned  int
+		failed   int
+	}{
+		{"page", []string{path("page.html")}, 1, 1, 0},
+		{"clean page", []string{path("clean.html")}, 0, 1, 0},
+		{"epub", []string{path("book.epub")}, 1, 1, 0},
+		{"warc text responses only", []string{path("page.warc.gz")}, 1, 1, 0},
+		{"all inputs failing", []string{path("secret.tar.age"), path("broken.epub")}, 0, 0, 2},
+		{"unsupported file", []string{path("photo.png")}, 0, 0, 1},
+		{"missing file", []string{path("missing.html")}, 0, 0, 1},
+		// images in a directory are page files, not failures
+		{"directory", []string{dir}, 3, 4, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var scan piiScan
+			for _, input := range test.inputs {
+				scan.scanArchive(input)
+			}
+
+			if len(scan.findings) != test.findings || scan.scanned != test.scanned || scan.failed != test.failed {
+				t.Errorf("expected %d findings in %d scanned and %d failed, got %d findings in %d scanned and %d failed",
+					test.findings, test.scanned, test.failed, len(scan.findings), scan.scanned, scan.failed)
+			}
+		})
+	}
+}